@@ -0,0 +1,177 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"mechfeed/users"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Longest !pause with a duration, pause without one to pause until resumed
+const MAX_PAUSE = time.Hour * 24 * 365
+
+// Slash commands pass alerts by ID as "#<alert_id>" rather than by number, as
+// the numbers shift if an alert is deleted while the command is typed
+const ALERT_ID_PREFIX = "#"
+
+// Fetches one of the user's alerts by its number in !list, or by its ID
+// after ALERT_ID_PREFIX
+func numbered_alert(repo *users.Repository, user_id, n string) (users.UserAlert, error) {
+	if id, ok := strings.CutPrefix(n, ALERT_ID_PREFIX); ok {
+		alert_id, err := strconv.ParseInt(id, 10, 32)
+		if err != nil {
+			return users.UserAlert{}, fmt.Errorf("no alert %q, see `!list` for the numbers of your alerts", n)
+		}
+		alert, err := repo.Queries.GetAlert(repo.Ctx, int32(alert_id))
+		if err != nil || alert.ID != user_id {
+			return users.UserAlert{}, errors.New("alert not found, it may have been deleted")
+		}
+		return alert, nil
+	}
+	alerts, err := repo.Queries.GetUserAlerts(repo.Ctx, user_id)
+	if err != nil {
+		fmt.Println("failed to query DB for alerts")
+		return users.UserAlert{}, errors.New("failed to get alerts, please contact dev or try again later")
+	}
+	for i, alert := range alerts {
+		if strconv.Itoa(i+1) == n {
+			return alert, nil
+		}
+	}
+	return users.UserAlert{}, fmt.Errorf("no alert %q, see `!list` for the numbers of your alerts", n)
+}
+
+// Parses a pause duration such as 30m, 12h, 3d or 1w
+func parse_pause(arg string) (time.Duration, error) {
+	match := history_duration.FindStringSubmatch(strings.ToLower(arg))
+	if match == nil {
+		return 0, fmt.Errorf("invalid duration %q, use a duration such as 30m, 12h, 3d or 1w", arg)
+	}
+	n, _ := strconv.Atoi(match[1])
+	d := time.Duration(n) * duration_units[match[2]]
+	if n < 1 || d > MAX_PAUSE {
+		return 0, fmt.Errorf("invalid duration %q, pause for at most a year or leave out the duration to pause until `!resume`", arg)
+	}
+	return d, nil
+}
+
+// Time left until d is up, e.g. "3d" or "5h"
+func format_remaining(d time.Duration) string {
+	switch {
+	case d >= time.Hour*24:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dm", int(d.Minutes())+1)
+	}
+}
+
+// Shown next to paused alerts in !list
+func pause_status(alert users.UserAlert, now time.Time) string {
+	if !alert.PausedAt(now) {
+		return ""
+	}
+	if alert.Paused {
+		return " (paused)"
+	}
+	return " (paused for " + format_remaining(alert.PausedUntil.Time.Sub(now)) + ")"
+}
+
+func handleEdit(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to edit alert, please contact dev or try again later")
+	}
+	if len(args) < 2 {
+		return errors.New("usage: `!edit <n> <alert>`, e.g. `!edit 2 gmk & olivia`")
+	}
+	alert, err := numbered_alert(repo, m.Author.ID, args[0])
+	if err != nil {
+		return err
+	}
+	keyword, err := validate_alert(strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+
+	err = repo.Queries.UpdateAlertKeyword(repo.Ctx, users.UpdateAlertKeywordParams{
+		AlertID: alert.AlertID,
+		Keyword: keyword,
+	})
+	if err != nil {
+		fmt.Println("failed to update alert:", err)
+		return errors.New("failed to edit alert, please contact dev or try again later")
+	}
+	SendTextDM(s, m.Author.ID, fmt.Sprintf("Changed `%s` to `%s`.", alert.Keyword, keyword))
+	return nil
+}
+
+func handlePause(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to pause alert, please contact dev or try again later")
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: `!pause <n> [duration]`, e.g. `!pause 2 3d`")
+	}
+	alert, err := numbered_alert(repo, m.Author.ID, args[0])
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 1 {
+		if err := repo.Queries.PauseAlertIndefinitely(repo.Ctx, alert.AlertID); err != nil {
+			fmt.Println("failed to pause alert:", err)
+			return errors.New("failed to pause alert, please contact dev or try again later")
+		}
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("Paused `%s` until you `!resume %s`.", alert.Keyword, args[0]))
+		return nil
+	}
+
+	d, err := parse_pause(args[1])
+	if err != nil {
+		return err
+	}
+	err = repo.Queries.PauseAlert(repo.Ctx, users.PauseAlertParams{
+		AlertID:         alert.AlertID,
+		DurationSeconds: d.Seconds(),
+	})
+	if err != nil {
+		fmt.Println("failed to pause alert:", err)
+		return errors.New("failed to pause alert, please contact dev or try again later")
+	}
+	SendTextDM(s, m.Author.ID, fmt.Sprintf("Paused `%s` until <t:%d:f>.", alert.Keyword, time.Now().Add(d).Unix()))
+	return nil
+}
+
+func handleResume(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to resume alert, please contact dev or try again later")
+	}
+	if len(args) != 1 {
+		return errors.New("usage: `!resume <n>`, e.g. `!resume 2`")
+	}
+	alert, err := numbered_alert(repo, m.Author.ID, args[0])
+	if err != nil {
+		return err
+	}
+	if !alert.PausedAt(time.Now()) {
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("`%s` isn't paused.", alert.Keyword))
+		return nil
+	}
+	if err := repo.Queries.ResumeAlert(repo.Ctx, alert.AlertID); err != nil {
+		fmt.Println("failed to resume alert:", err)
+		return errors.New("failed to resume alert, please contact dev or try again later")
+	}
+	SendTextDM(s, m.Author.ID, fmt.Sprintf("Resumed `%s`.", alert.Keyword))
+	return nil
+}
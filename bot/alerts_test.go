@@ -0,0 +1,154 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"mechfeed/users"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePause(t *testing.T) {
+	cases := map[string]time.Duration{
+		"30m": time.Minute * 30,
+		"12H": time.Hour * 12,
+		"3d":  time.Hour * 24 * 3,
+		"1w":  time.Hour * 24 * 7,
+	}
+	for arg, expect := range cases {
+		if got, err := parse_pause(arg); err != nil || got != expect {
+			t.Errorf("parse_pause(%q) = %v, %v expect %v", arg, got, err, expect)
+		}
+	}
+	for _, arg := range []string{"0h", "3", "tomorrow", "100w"} {
+		if _, err := parse_pause(arg); err == nil {
+			t.Errorf("parse_pause(%q) expected error", arg)
+		}
+	}
+}
+
+func TestPauseStatus(t *testing.T) {
+	now := time.Now()
+	until := func(d time.Duration) users.UserAlert {
+		return users.UserAlert{PausedUntil: sql.NullTime{Time: now.Add(d), Valid: true}}
+	}
+	cases := []struct {
+		alert  users.UserAlert
+		expect string
+	}{
+		{users.UserAlert{}, ""},
+		{users.UserAlert{Paused: true}, " (paused)"},
+		{until(time.Hour * 50), " (paused for 2d)"},
+		{until(time.Hour*5 + time.Minute), " (paused for 5h)"},
+		{until(time.Second * 30), " (paused for 1m)"},
+		{until(-time.Hour), ""},
+	}
+	for _, c := range cases {
+		if got := pause_status(c.alert, now); got != c.expect {
+			t.Errorf("got %q expect %q", got, c.expect)
+		}
+	}
+}
+
+// heap_table is a user_alerts table that, like Postgres, returns rows in the
+// order they were last written unless the query orders them
+var err_unsupported = errors.New("query not supported by heap_table")
+
+type heap_table struct {
+	alerts []users.UserAlert
+}
+
+func (h *heap_table) Connect(ctx context.Context) (driver.Conn, error) { return h, nil }
+func (h *heap_table) Driver() driver.Driver                            { return h }
+func (h *heap_table) Open(name string) (driver.Conn, error)            { return h, nil }
+func (h *heap_table) Prepare(query string) (driver.Stmt, error)        { return nil, err_unsupported }
+func (h *heap_table) Begin() (driver.Tx, error)                        { return nil, err_unsupported }
+func (h *heap_table) Close() error                                     { return nil }
+
+// Supports UpdateAlertKeyword, moving the updated row to the end of the heap
+func (h *heap_table) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if !strings.Contains(query, "name: UpdateAlertKeyword") {
+		return nil, err_unsupported
+	}
+	for i, alert := range h.alerts {
+		if int64(alert.AlertID) == args[0].Value.(int64) {
+			alert.Keyword = args[1].Value.(string)
+			h.alerts = append(append(h.alerts[:i:i], h.alerts[i+1:]...), alert)
+			return driver.RowsAffected(1), nil
+		}
+	}
+	return driver.RowsAffected(0), nil
+}
+
+// Supports GetUserAlerts
+func (h *heap_table) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if !strings.Contains(query, "name: GetUserAlerts") {
+		return nil, err_unsupported
+	}
+	rows := &heap_rows{}
+	for _, alert := range h.alerts {
+		if alert.ID == args[0].Value.(string) {
+			rows.alerts = append(rows.alerts, alert)
+		}
+	}
+	if strings.Contains(query, "ORDER BY alert_id") {
+		sort.Slice(rows.alerts, func(i, j int) bool { return rows.alerts[i].AlertID < rows.alerts[j].AlertID })
+	}
+	return rows, nil
+}
+
+type heap_rows struct {
+	alerts []users.UserAlert
+}
+
+func (r *heap_rows) Columns() []string {
+	return []string{"alert_id", "id", "keyword", "urgent", "paused_until", "paused", "destinations"}
+}
+
+func (r *heap_rows) Close() error { return nil }
+
+func (r *heap_rows) Next(dest []driver.Value) error {
+	if len(r.alerts) == 0 {
+		return io.EOF
+	}
+	alert := r.alerts[0]
+	r.alerts = r.alerts[1:]
+	copy(dest, []driver.Value{int64(alert.AlertID), alert.ID, alert.Keyword, alert.Urgent, nil, alert.Paused, []byte("{}")})
+	return nil
+}
+
+func TestNumberedAlertAfterUpdate(t *testing.T) {
+	table := &heap_table{alerts: []users.UserAlert{
+		{AlertID: 1, ID: "alice", Keyword: "olivia"},
+		{AlertID: 2, ID: "bob", Keyword: "kaze"},
+		{AlertID: 3, ID: "alice", Keyword: "botanical"},
+		{AlertID: 4, ID: "alice", Keyword: "laser"},
+	}}
+	db := sql.OpenDB(table)
+	defer db.Close()
+	repo := &users.Repository{Db: db, Ctx: context.Background(), Queries: users.New(db)}
+
+	// !list numbers alice's alerts 1, 2 and 3, which must still hold after !edit 1
+	expect := map[string]int32{"1": 1, "2": 3, "3": 4}
+	err := repo.Queries.UpdateAlertKeyword(repo.Ctx, users.UpdateAlertKeywordParams{AlertID: 1, Keyword: "olivia & gmk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, alert_id := range expect {
+		alert, err := numbered_alert(repo, "alice", n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if alert.AlertID != alert_id {
+			t.Errorf("alert %s is ID %d expect %d", n, alert.AlertID, alert_id)
+		}
+	}
+	if _, err := numbered_alert(repo, "alice", "4"); err == nil {
+		t.Errorf("expected error for alert 4")
+	}
+}
@@ -0,0 +1,449 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mechfeed/notifications"
+	"mechfeed/users"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const DESTINATION_USAGE = "usage: `!destination add <name> <kind> <target>`, `!destination remove|enable|disable|test <name>`, `!destination confirm <name> <code>`"
+
+const ROUTE_USAGE = "usage: `!route <n> <destination...>` or `!route <n> all`, e.g. `!route 2 phone webhook`"
+
+// How long the test message of a destination may take
+const DESTINATION_TEST_TIMEOUT = time.Second * 15
+
+// How long the code emailed to a new email destination can be used for
+const EMAIL_CONFIRM_TTL = time.Hour * 24
+
+var destination_name = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// Kind of destination added as a Discord or Slack webhook, by its URL
+const WEBHOOK_KIND = "webhook"
+
+// Readable names of destination kinds
+var destination_kinds = map[string]string{
+	users.DestinationDM:       "Discord DMs",
+	users.DestinationDiscord:  "Discord webhook",
+	users.DestinationSlack:    "Slack webhook",
+	WEBHOOK_KIND:              "Discord or Slack webhook",
+	users.DestinationJSON:     "JSON webhook",
+	users.DestinationNtfy:     "ntfy",
+	users.DestinationEmail:    "Email",
+	users.DestinationTelegram: "Telegram",
+}
+
+// Checks the target of a destination of the given kind
+func validate_destination(kind, target string) error {
+	switch kind {
+	case users.DestinationDiscord:
+		return notifications.ValidateWebhookURL(target)
+	case users.DestinationSlack:
+		// Any host, for Slack-compatible webhooks
+		return notifications.ValidateJSONURL(target)
+	case users.DestinationJSON:
+		return notifications.ValidateJSONURL(target)
+	case users.DestinationNtfy:
+		_, _, err := notifications.ParseNtfyTarget(target)
+		return err
+	case users.DestinationEmail:
+		return notifications.ValidateEmail(target)
+	case users.DestinationTelegram:
+		return errors.New("telegram chats have to be linked with `!telegram`")
+	}
+	return fmt.Errorf("unknown destination kind %q, use one of %s, %s", kind, WEBHOOK_KIND, strings.Join(users.DestinationKinds[1:], ", "))
+}
+
+// Target of a destination as shown to its user and in logs, without secrets
+func destination_target(d users.UserDestination) string {
+	switch d.Kind {
+	case users.DestinationDM:
+		return ""
+	case users.DestinationNtfy:
+		// Anyone who knows a topic can read it
+		server, topic, err := notifications.ParseNtfyTarget(d.Target)
+		if err != nil {
+			return "***"
+		}
+		if len(topic) > 3 {
+			topic = topic[:3]
+		}
+		return strings.TrimPrefix(server, "https://") + "/" + topic + "***"
+	case users.DestinationEmail:
+		return d.Target
+	case users.DestinationTelegram:
+		return "chat " + d.Target
+	}
+	return notifications.RedactURL(d.Target)
+}
+
+func destination_line(d users.UserDestination) string {
+	line := fmt.Sprintf("- %s: %s", d.Name, destination_kinds[d.Kind])
+	if target := destination_target(d); target != "" {
+		line += " " + target
+	}
+	if !d.Enabled {
+		line += " (disabled)"
+	}
+	return line + "\n"
+}
+
+// Names of the destinations an alert is routed to, empty if sent to all
+func alert_routes(alert users.UserAlert, destinations []users.UserDestination) []string {
+	if len(alert.Destinations) == 0 {
+		return nil
+	}
+	var names []string
+	for _, d := range destinations {
+		if alert.RoutesTo(d) {
+			names = append(names, d.Name)
+		}
+	}
+	return names
+}
+
+// Sends a test message to a destination, so that broken ones are caught when
+// they're added
+func test_destination(s *discordgo.Session, user_id, kind, target string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DESTINATION_TEST_TIMEOUT)
+	defer cancel()
+	const text = "✅ Mechfeed notifications will be sent here."
+
+	var err error
+	switch kind {
+	case users.DestinationDM:
+		var channel *discordgo.Channel
+		channel, err = s.UserChannelCreate(user_id)
+		if err == nil {
+			_, err = s.ChannelMessageSend(channel.ID, text)
+		}
+	case users.DestinationDiscord:
+		err = notifications.DefaultWebhookClient.Send(ctx, target, notifications.DiscordNoti{Content: text, Username: "mechfeed"})
+	case users.DestinationSlack:
+		err = notifications.DefaultHookClient.Send(ctx, target, notifications.SlackNoti{Text: text})
+	case users.DestinationJSON:
+		err = notifications.DefaultHookClient.Send(ctx, target, notifications.JSONNoti{Alert: "test", Source: "mechfeed", ID: "test", Title: text})
+	case users.DestinationNtfy:
+		server, topic, _ := notifications.ParseNtfyTarget(target)
+		err = notifications.DefaultHookClient.Send(ctx, server, notifications.NtfyNoti{Topic: topic, Title: "Mechfeed", Message: text})
+	case users.DestinationEmail:
+		err = notifications.SendEmail(ctx, target, notifications.EmailNoti{Subject: "Mechfeed test", Text: text})
+	case users.DestinationTelegram:
+		err = notifications.DefaultTelegramClient.SendText(ctx, target, text)
+	}
+	if err != nil {
+		fmt.Println("destination test failed for", kind, destination_target(users.UserDestination{Kind: kind, Target: target}), err)
+		return fmt.Errorf("test message failed, check the %s: %v", strings.ToLower(destination_kinds[kind]), err)
+	}
+	return nil
+}
+
+// Validates, tests and saves a destination
+func add_destination(s *discordgo.Session, repo *users.Repository, user_id, name, kind, target string) error {
+	if !destination_name.MatchString(name) {
+		return errors.New("destination names can only have lowercase letters, digits, _ and -, up to 32 characters")
+	}
+	if kind == WEBHOOK_KIND {
+		kind = notifications.WebhookFormat(target)
+		if kind == "" {
+			return errors.New("not a Discord or Slack webhook URL, use the slack kind for Slack-compatible webhooks")
+		}
+	}
+	if kind == users.DestinationDM {
+		target = user_id
+	} else if err := validate_destination(kind, target); err != nil {
+		return err
+	}
+	// Email addresses are confirmed instead, anyone could be typed in
+	confirm := kind == users.DestinationEmail
+	if confirm && !notifications.EmailConfigured() {
+		return notifications.ErrEmailNotConfigured
+	}
+	if !confirm {
+		if err := test_destination(s, user_id, kind, target); err != nil {
+			return err
+		}
+	}
+	id, err := repo.Queries.AddDestination(repo.Ctx, users.AddDestinationParams{
+		ID:      user_id,
+		Name:    name,
+		Kind:    kind,
+		Target:  target,
+		Enabled: !confirm,
+	})
+	if err != nil {
+		fmt.Println("failed to add destination:", err)
+		return errors.New("failed to add destination, please contact dev or try again later")
+	}
+	if confirm {
+		return send_confirmation(repo, id, name, target)
+	}
+	return nil
+}
+
+// Emails a code to a destination, which stays disabled until the code is
+// given to !destination confirm
+func send_confirmation(repo *users.Repository, destination_id int32, name, target string) error {
+	code, err := new_link_code()
+	if err == nil {
+		err = repo.Queries.CreateDestinationConfirmation(repo.Ctx, users.CreateDestinationConfirmationParams{
+			DestinationID: destination_id,
+			Code:          code,
+			TtlSeconds:    EMAIL_CONFIRM_TTL.Seconds(),
+		})
+	}
+	if err != nil {
+		fmt.Println("failed to create destination confirmation:", err)
+		return errors.New("failed to add destination, please contact dev or try again later")
+	}
+	text := fmt.Sprintf("Your Mechfeed confirmation code is %s\n\n"+
+		"Send !destination confirm %s %s to the Mechfeed bot on Discord to get notifications at this address. "+
+		"The code expires in %d hours.\n\nIf you didn't ask for this, you can ignore this email.",
+		code, name, code, int(EMAIL_CONFIRM_TTL.Hours()))
+	ctx, cancel := context.WithTimeout(context.Background(), DESTINATION_TEST_TIMEOUT)
+	defer cancel()
+	err = notifications.SendEmail(ctx, target, notifications.EmailNoti{Subject: "Confirm your email for Mechfeed", Text: text})
+	if err != nil {
+		fmt.Println("confirmation email failed for", target, err)
+		return fmt.Errorf("couldn't email the confirmation code, check the address and send a new code with `!destination test %s`: %v", name, err)
+	}
+	return nil
+}
+
+// Enables an email destination given the code emailed to it
+func confirm_destination(repo *users.Repository, user_id, name, code string) error {
+	n, err := repo.Queries.ConfirmDestination(repo.Ctx, users.ConfirmDestinationParams{
+		ID:   user_id,
+		Name: name,
+		Code: strings.ToUpper(code),
+	})
+	if err != nil {
+		fmt.Println("failed to confirm destination:", err)
+		return errors.New("failed to confirm destination, please contact dev or try again later")
+	}
+	if n == 0 {
+		return fmt.Errorf("that code is wrong or has expired, send a new one with `!destination test %s`", name)
+	}
+	return nil
+}
+
+// Deletes a destination and removes it from the routes of alerts, returns the
+// keywords of the alerts paused since they weren't routed anywhere else
+func remove_destination(repo *users.Repository, user_id, name string) ([]string, error) {
+	id, err := repo.Queries.DeleteDestination(repo.Ctx, users.DeleteDestinationParams{ID: user_id, Name: name})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no destination named %q, see `!destinations`", name)
+	}
+	var paused []string
+	if err == nil {
+		paused, err = repo.Queries.RemoveAlertDestination(repo.Ctx, users.RemoveAlertDestinationParams{ID: user_id, DestinationID: id})
+	}
+	if err != nil {
+		fmt.Println("failed to remove destination:", err)
+		return nil, errors.New("failed to remove destination, please contact dev or try again later")
+	}
+	return paused, nil
+}
+
+// Tells the user which alerts remove_destination paused
+func paused_by_removal(paused []string) string {
+	if len(paused) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" Paused %s since they weren't routed anywhere else, use `!route` and `!resume` to get them back.", strings.Join(paused, ", "))
+}
+
+// Finds the user's destination called name
+func named_destination(repo *users.Repository, user_id, name string) (users.UserDestination, error) {
+	destinations, err := repo.Queries.GetUserDestinations(repo.Ctx, user_id)
+	if err != nil {
+		fmt.Println("failed to query DB for destinations:", err)
+		return users.UserDestination{}, errors.New("failed to get destinations, please contact dev or try again later")
+	}
+	for _, d := range destinations {
+		if d.Name == name {
+			return d, nil
+		}
+	}
+	return users.UserDestination{}, fmt.Errorf("no destination named %q, see `!destinations`", name)
+}
+
+func handleDestinations(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to get destinations, please contact dev or try again later")
+	}
+	destinations, err := repo.Queries.GetUserDestinations(repo.Ctx, m.Author.ID)
+	if err != nil {
+		fmt.Println("failed to query DB for destinations:", err)
+		return errors.New("failed to get destinations, please contact dev or try again later")
+	}
+	if len(destinations) == 0 {
+		SendTextDM(s, m.Author.ID, "You have no destinations, so you won't be notified. Add one with `!destination add`, e.g. `!destination add dm dm`.")
+		return nil
+	}
+	var sb strings.Builder
+	for _, d := range destinations {
+		sb.WriteString(destination_line(d))
+	}
+	SendTextDM(s, m.Author.ID, "Your notifications are sent to:\n```"+sb.String()+"```")
+	return nil
+}
+
+func handleDestination(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	if len(args) < 2 {
+		return errors.New(DESTINATION_USAGE)
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to update destinations, please contact dev or try again later")
+	}
+	action, name := strings.ToLower(args[0]), strings.ToLower(args[1])
+
+	switch action {
+	case "add":
+		if len(args) < 3 || (len(args) < 4 && strings.ToLower(args[2]) != users.DestinationDM) {
+			return errors.New(DESTINATION_USAGE)
+		}
+		kind, target := strings.ToLower(args[2]), ""
+		if len(args) > 3 {
+			// Discord wraps links in <> to suppress embeds
+			target = strings.TrimSuffix(strings.TrimPrefix(args[3], "<"), ">")
+		}
+		if err := add_destination(s, repo, m.Author.ID, name, kind, target); err != nil {
+			return err
+		}
+		fmt.Println(m.Author.Username, "added destination", name, kind, notifications.RedactURL(target))
+		if kind == users.DestinationEmail {
+			SendTextDM(s, m.Author.ID, fmt.Sprintf("Destination `%s` added. Enter the code emailed to %s with `!destination confirm %s <code>` to start getting notifications there.", name, target, name))
+			return nil
+		}
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("Destination `%s` added and a test message sent. Route alerts to it with `!route`, otherwise every alert is sent to it.", name))
+
+	case "confirm":
+		if len(args) != 3 {
+			return errors.New(DESTINATION_USAGE)
+		}
+		if err := confirm_destination(repo, m.Author.ID, name, args[2]); err != nil {
+			return err
+		}
+		fmt.Println(m.Author.Username, "confirmed destination", name)
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("Destination `%s` confirmed. Route alerts to it with `!route`, otherwise every alert is sent to it.", name))
+
+	case "remove":
+		paused, err := remove_destination(repo, m.Author.ID, name)
+		if err != nil {
+			return err
+		}
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("Destination `%s` removed.", name)+paused_by_removal(paused))
+
+	case "enable", "disable":
+		n, err := repo.Queries.SetDestinationEnabled(repo.Ctx, users.SetDestinationEnabledParams{
+			ID:      m.Author.ID,
+			Name:    name,
+			Enabled: action == "enable",
+		})
+		if err != nil {
+			fmt.Println("failed to update destination:", err)
+			return errors.New("failed to update destination, please contact dev or try again later")
+		}
+		if n == 0 {
+			if _, err := named_destination(repo, m.Author.ID, name); err != nil {
+				return err
+			}
+			return fmt.Errorf("`%s` isn't confirmed yet, enter the code emailed to it with `!destination confirm %s <code>`", name, name)
+		}
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("Destination `%s` %sd.", name, action))
+
+	case "test":
+		d, err := named_destination(repo, m.Author.ID, name)
+		if err != nil {
+			return err
+		}
+		if d.Kind == users.DestinationEmail {
+			unconfirmed, err := repo.Queries.IsDestinationUnconfirmed(repo.Ctx, d.DestinationID)
+			if err != nil {
+				fmt.Println("failed to query DB for destination confirmation:", err)
+				return errors.New("failed to test destination, please contact dev or try again later")
+			}
+			if unconfirmed {
+				if err := send_confirmation(repo, d.DestinationID, name, d.Target); err != nil {
+					return err
+				}
+				SendTextDM(s, m.Author.ID, fmt.Sprintf("New confirmation code emailed to %s, enter it with `!destination confirm %s <code>`.", d.Target, name))
+				return nil
+			}
+		}
+		if err := test_destination(s, m.Author.ID, d.Kind, d.Target); err != nil {
+			return err
+		}
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("Test message sent to `%s`.", name))
+
+	default:
+		return errors.New(DESTINATION_USAGE)
+	}
+	return nil
+}
+
+func handleRoute(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	if len(args) < 2 {
+		return errors.New(ROUTE_USAGE)
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to route alert, please contact dev or try again later")
+	}
+	alert, err := numbered_alert(repo, m.Author.ID, args[0])
+	if err != nil {
+		return err
+	}
+	destinations, err := repo.Queries.GetUserDestinations(repo.Ctx, m.Author.ID)
+	if err != nil {
+		fmt.Println("failed to query DB for destinations:", err)
+		return errors.New("failed to route alert, please contact dev or try again later")
+	}
+
+	var ids []int32
+	var names []string
+	if !(len(args) == 2 && strings.ToLower(args[1]) == "all") {
+		for _, name := range args[1:] {
+			name = strings.ToLower(strings.Trim(name, ","))
+			found := false
+			for _, d := range destinations {
+				if d.Name == name {
+					ids = append(ids, d.DestinationID)
+					names = append(names, name)
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("no destination named %q, see `!destinations`", name)
+			}
+		}
+	}
+	err = repo.Queries.SetAlertDestinations(repo.Ctx, users.SetAlertDestinationsParams{
+		AlertID:      alert.AlertID,
+		Destinations: ids,
+	})
+	if err != nil {
+		fmt.Println("failed to route alert:", err)
+		return errors.New("failed to route alert, please contact dev or try again later")
+	}
+	if len(names) == 0 {
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("`%s` will be sent to all your destinations.", alert.Keyword))
+	} else {
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("`%s` will only be sent to %s.", alert.Keyword, strings.Join(names, ", ")))
+	}
+	return nil
+}
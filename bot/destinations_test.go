@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"mechfeed/users"
+	"reflect"
+	"testing"
+)
+
+func TestDestinationTarget(t *testing.T) {
+	cases := []struct {
+		destination users.UserDestination
+		expect      string
+	}{
+		{users.UserDestination{Kind: users.DestinationDM, Target: "1234"}, ""},
+		{users.UserDestination{Kind: users.DestinationDiscord, Target: "https://discord.com/api/webhooks/123456789012345678/AbCdEfGhIjKlMnOpQrStUvWxYz0123456789"}, "https://discord.com/api/webhooks/123456789012345678/***"},
+		{users.UserDestination{Kind: users.DestinationSlack, Target: "https://hooks.slack.com/services/T000/B000/XXXX"}, "https://hooks.slack.com/***"},
+		{users.UserDestination{Kind: users.DestinationNtfy, Target: "mechfeed_x7k2"}, "ntfy.sh/mec***"},
+		{users.UserDestination{Kind: users.DestinationEmail, Target: "keebs@example.com"}, "keebs@example.com"},
+	}
+	for _, c := range cases {
+		if got := destination_target(c.destination); got != c.expect {
+			t.Errorf("got %q expect %q", got, c.expect)
+		}
+	}
+}
+
+func TestValidateDestination(t *testing.T) {
+	if err := validate_destination(users.DestinationNtfy, "mechfeed_x7k2"); err != nil {
+		t.Error(err)
+	}
+	if err := validate_destination(users.DestinationDiscord, "https://hooks.slack.com/services/T000/B000/XXXX"); err == nil {
+		t.Error("accepted Slack webhook as Discord")
+	}
+	if err := validate_destination(users.DestinationSlack, "http://localhost:8065/hooks/abc"); err == nil {
+		t.Error("accepted local Slack-compatible webhook")
+	}
+	if err := validate_destination("pager", "123"); err == nil {
+		t.Error("accepted unknown kind")
+	}
+}
+
+func TestAlertRoutes(t *testing.T) {
+	destinations := []users.UserDestination{
+		{DestinationID: 1, Name: "dm", Enabled: true},
+		{DestinationID: 2, Name: "phone", Enabled: true},
+		{DestinationID: 3, Name: "webhook", Enabled: false},
+	}
+	all := users.UserAlert{Keyword: "olivia"}
+	if got := alert_routes(all, destinations); got != nil {
+		t.Errorf("got routes %q for unrouted alert", got)
+	}
+	if got := all.Routes(destinations); len(got) != 2 {
+		t.Errorf("got %d destinations expect the 2 enabled", len(got))
+	}
+
+	routed := users.UserAlert{Keyword: "kaze", Destinations: []int32{2, 3}}
+	if got := alert_routes(routed, destinations); !reflect.DeepEqual(got, []string{"phone", "webhook"}) {
+		t.Errorf("got routes %q", got)
+	}
+	if got := routed.Routes(destinations); len(got) != 1 || got[0].Name != "phone" {
+		t.Errorf("got destinations %+v expect phone", got)
+	}
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"mechfeed/digest"
+	"mechfeed/users"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const DIGEST_USAGE = "usage: `!digest [instant|hourly|daily [HH:MM] [timezone]]`, e.g. `!digest daily 09:00 Europe/Berlin`"
+
+// Parses "!digest" arguments into a delivery mode, a time of day for daily
+// digests and a timezone, keeping the user's current settings when omitted.
+func parse_digest_args(args []string, user users.User) (users.UpdateUserDeliveryParams, error) {
+	params := users.UpdateUserDeliveryParams{
+		ID:           user.ID,
+		DeliveryMode: strings.ToLower(args[0]),
+		DigestAt:     user.DigestAt,
+		Timezone:     user.Timezone,
+	}
+	switch params.DeliveryMode {
+	case digest.Instant, digest.Hourly:
+		if len(args) > 1 {
+			return params, errors.New(DIGEST_USAGE)
+		}
+	case digest.Daily:
+		if len(args) > 3 {
+			return params, errors.New(DIGEST_USAGE)
+		}
+		for _, arg := range args[1:] {
+			if minutes, err := digest.ParseClock(arg); err == nil {
+				params.DigestAt = int16(minutes)
+			} else if valid_timezone(arg) {
+				params.Timezone = arg
+			} else {
+				return params, fmt.Errorf("invalid time or timezone %q, use 24-hour HH:MM and a timezone such as America/New_York", arg)
+			}
+		}
+	default:
+		return params, errors.New(DIGEST_USAGE)
+	}
+	return params, nil
+}
+
+func digest_setting(mode string, at int16, timezone string) string {
+	switch mode {
+	case digest.Hourly:
+		return "Notifications are sent as an hourly digest."
+	case digest.Daily:
+		return fmt.Sprintf("Notifications are sent as a daily digest at %s (%s).", digest.FormatClock(int(at)), timezone)
+	default:
+		return "Notifications are sent instantly."
+	}
+}
+
+func handleDigest(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to update digest settings, please contact dev or try again later")
+	}
+	user, err := repo.Queries.GetUser(repo.Ctx, m.Author.ID)
+	if err != nil {
+		fmt.Println("failed to query DB for user:", err)
+		return errors.New("failed to update digest settings, please contact dev or try again later")
+	}
+
+	if len(args) == 0 {
+		SendTextDM(s, m.Author.ID, digest_setting(user.DeliveryMode, user.DigestAt, user.Timezone)+"\n"+strings.ToUpper(DIGEST_USAGE[:1])+DIGEST_USAGE[1:])
+		return nil
+	}
+
+	params, err := parse_digest_args(args, user)
+	if err != nil {
+		return err
+	}
+	if err := repo.Queries.UpdateUserDelivery(repo.Ctx, params); err != nil {
+		fmt.Println("failed to update user delivery:", err)
+		return errors.New("failed to update digest settings, please contact dev or try again later")
+	}
+	reply := digest_setting(params.DeliveryMode, params.DigestAt, params.Timezone)
+	if params.DeliveryMode == digest.Instant && user.DeliveryMode != digest.Instant {
+		reply += " Notifications waiting for your digest will be sent shortly."
+	}
+	SendTextDM(s, m.Author.ID, reply)
+	return nil
+}
@@ -0,0 +1,35 @@
+package bot
+
+import (
+	"mechfeed/users"
+	"testing"
+)
+
+func TestParseDigestArgs(t *testing.T) {
+	user := users.User{ID: "1", DeliveryMode: "instant", DigestAt: 540, Timezone: "UTC"}
+	cases := []struct {
+		args     []string
+		mode     string
+		at       int16
+		timezone string
+		ok       bool
+	}{
+		{[]string{"hourly"}, "hourly", 540, "UTC", true},
+		{[]string{"Daily", "21:30"}, "daily", 1290, "UTC", true},
+		{[]string{"daily", "Europe/Berlin", "7:00"}, "daily", 420, "Europe/Berlin", true},
+		{[]string{"daily", "Mars/Olympus"}, "", 0, "", false},
+		{[]string{"daily", "Local"}, "", 0, "", false},
+		{[]string{"instant", "09:00"}, "", 0, "", false},
+		{[]string{"weekly"}, "", 0, "", false},
+	}
+	for _, c := range cases {
+		got, err := parse_digest_args(c.args, user)
+		if (err == nil) != c.ok {
+			t.Errorf("%q: got error %v", c.args, err)
+			continue
+		}
+		if c.ok && (got.DeliveryMode != c.mode || got.DigestAt != c.at || got.Timezone != c.timezone) {
+			t.Errorf("%q: got %+v", c.args, got)
+		}
+	}
+}
@@ -17,14 +17,75 @@ var HelpInformation = []*discordgo.MessageEmbedField{
 		Name:   "Setting Alerts",
 		Value:  "Use `!add`, example: `!add gmk,dandy,-daisy`\n" +
 				"```- Include 'gmk' and 'dandy' and exclude 'daisy'.\n" +
+				"- Use '|' for OR, '&' or ',' for AND, '-' to exclude and parentheses to group.\n" +
+				"- Quote phrases to match words together, e.g. (gmk | sa) & \"olivia dark\" & -keycap-only\n" +
+				"- Search a specific field with title:, author:, flair:, server: or channel:, e.g. title:olivia\n" +
+				"- Use have: or want: to match the [H] or [W] side of mechmarket titles, e.g. have:olivia\n" +
+				"- Limit mechmarket posts to locations with loc:, e.g. gmk & loc:US-CA,CA\n" +
+				"- Limit the price next to your keywords with price<, price<=, price> or price>=, e.g. olivia & price<200\n" +
+				"- Prices are USD unless you add a currency, e.g. price<150eur, and only match listings in that currency\n" +
 				"- Alerts are case-insensitive.\n" +
-				"- For multiple alerts separate them with a space.```",
+				"- For multiple alerts separate them with a space, and add alerts with loc: or price limits one at a time.```",
 		Inline: false,
 	},
 	{
 		Name:   "Viewing Alerts",
 		Value:  "Use `!list` to see a numbered list of your current alerts.\n" +
-				"",
+				"Pick an alert from the menu below the list to edit, delete or mark it urgent.\n" +
+				"Every command is also a slash command, e.g. `/add` or `/list`.",
+		Inline: false,
+	},
+	{
+		Name:   "Editing and Pausing Alerts",
+		Value:  "Use `!edit`, `!pause` and `!resume` with the number of an alert from `!list`, example: `!edit 2 gmk & olivia`\n" +
+				"```- Editing an alert keeps the sellers it ignores.\n" +
+				"- Pause for a while with a duration such as 12h, 3d or 1w, e.g. !pause 2 3d\n" +
+				"- Without a duration, the alert is paused until you !resume it.```",
+		Inline: false,
+	},
+	{
+		Name:   "Ignoring Sellers",
+		Value:  "Press **Ignore seller** on a notification to stop seeing a seller's listings for that alert.\n" +
+				"```- Use !block <seller> to ignore a seller on all your alerts.\n" +
+				"- Use !ignored to see who you ignore.\n" +
+				"- Use !unignore <seller> [n] to undo, on alert n or everywhere.```",
+		Inline: false,
+	},
+	{
+		Name:   "Destinations",
+		Value:  "Notifications are sent to your DMs and any other destination, list them with `!destinations`.\n" +
+				"```- !destination add <name> <kind> <target> where kind is webhook (Discord or Slack), discord, slack, json, ntfy or email\n" +
+				"  e.g. !destination add phone ntfy mechfeed_x7k2\n" +
+				"- !destination remove|enable|disable|test <name>\n" +
+				"- Email destinations are enabled once you enter the code emailed to them with !destination confirm <name> <code>\n" +
+				"- !route <n> <name...> to send alert n only to some destinations, !route <n> all to undo\n" +
+				"- !webhook set <url> adds a Discord or Slack webhook called webhook.\n" +
+				"- !telegram gives a code to send to the Telegram bot to link a chat.```",
+		Inline: false,
+	},
+	{
+		Name:   "Notification History",
+		Value:  "Use `!history` to see your recent notifications, example: `!history 20` or `!history 2d`\n" +
+				"```- Give a number to see that many notifications, up to 100.\n" +
+				"- Give a duration such as 12h, 3d or 1w to see everything since then.\n" +
+				"- React with ◀️ or ▶️ to turn the page.```",
+		Inline: false,
+	},
+	{
+		Name:   "Digests",
+		Value:  "Use `!digest` to bundle notifications into one message, example: `!digest daily 09:00 Europe/Berlin`\n" +
+				"```- instant sends every notification as it happens, the default.\n" +
+				"- hourly sends a digest at the top of every hour.\n" +
+				"- daily sends a digest at the given time, 09:00 UTC unless set.```",
+		Inline: false,
+	},
+	{
+		Name:   "Quiet Hours",
+		Value:  "Use `!quiet` to hold notifications overnight, example: `!quiet 23:00-07:00`\n" +
+				"```- Held notifications are sent together when quiet hours end.\n" +
+				"- Set your timezone with !timezone, e.g. !timezone America/New_York\n" +
+				"- Mark an alert urgent to be notified anyway, e.g. !urgent 2\n" +
+				"- To turn quiet hours off, use '!quiet off'```",
 		Inline: false,
 	},
 	{
@@ -0,0 +1,211 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"mechfeed/notifications"
+	"mechfeed/users"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	HISTORY_DEFAULT   = 10  // Notifications shown by a bare !history
+	HISTORY_MAX       = 100 // Most notifications fetched by a single !history
+	HISTORY_PAGE_SIZE = 5
+
+	HISTORY_PREV = "◀️"
+	HISTORY_NEXT = "▶️"
+)
+
+// The footer of history embeds keeps the page and the command, so that page
+// reactions can re-run it
+var history_footer = regexp.MustCompile(`^Page (\d+)/(\d+) · (!history.*)$`)
+
+var history_duration = regexp.MustCompile(`^(\d+)([mhdw])$`)
+
+var duration_units = map[string]time.Duration{
+	"m": time.Minute,
+	"h": time.Hour,
+	"d": time.Hour * 24,
+	"w": time.Hour * 24 * 7,
+}
+
+// Parses "!history [n|since]" arguments, where since is a duration such as
+// 12h, 3d or 1w. Returns a zero since when a count was given.
+func parse_history_args(args []string) (int, time.Duration, error) {
+	if len(args) == 0 {
+		return HISTORY_DEFAULT, 0, nil
+	}
+	if len(args) > 1 {
+		return 0, 0, errors.New("usage: `!history [n|since]`, e.g. `!history 20` or `!history 2d`")
+	}
+	arg := strings.ToLower(args[0])
+	if n, err := strconv.Atoi(arg); err == nil {
+		if n < 1 || n > HISTORY_MAX {
+			return 0, 0, fmt.Errorf("can show between 1 and %d notifications", HISTORY_MAX)
+		}
+		return n, 0, nil
+	}
+	if arg == "yesterday" {
+		arg = "1d"
+	}
+	match := history_duration.FindStringSubmatch(arg)
+	if match == nil {
+		return 0, 0, fmt.Errorf("invalid history range %q, use a number or a duration such as 12h, 3d or 1w", args[0])
+	}
+	n, _ := strconv.Atoi(match[1])
+	if n < 1 {
+		return 0, 0, fmt.Errorf("invalid history range %q", args[0])
+	}
+	return HISTORY_MAX, time.Duration(n) * duration_units[match[2]], nil
+}
+
+func fetch_history(user_id string, args []string) ([]users.NotificationHistory, error) {
+	limit, since, err := parse_history_args(args)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return nil, errors.New("failed to get history, please contact dev or try again later")
+	}
+
+	var history []users.NotificationHistory
+	if since > 0 {
+		history, err = repo.Queries.GetUserHistorySince(repo.Ctx, users.GetUserHistorySinceParams{
+			ID:         user_id,
+			AgeSeconds: since.Seconds(),
+			MaxResults: int32(limit),
+		})
+	} else {
+		history, err = repo.Queries.GetUserHistory(repo.Ctx, users.GetUserHistoryParams{
+			ID:    user_id,
+			Limit: int32(limit),
+		})
+	}
+	if err != nil {
+		fmt.Println("failed to query DB for history:", err)
+		return nil, errors.New("failed to get history, please contact dev or try again later")
+	}
+	return history, nil
+}
+
+func handleHistory(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	history, err := fetch_history(m.Author.ID, args)
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		SendTextDM(s, m.Author.ID, "No notifications found.")
+		return nil
+	}
+
+	command := strings.TrimSpace("!history " + strings.Join(args, " "))
+	channel, err := s.UserChannelCreate(m.Author.ID)
+	if err != nil {
+		fmt.Println("Error creating channel:", err)
+		return nil
+	}
+	msg, err := s.ChannelMessageSendEmbed(channel.ID, history_embed(history, command, 1))
+	if err != nil {
+		fmt.Println("Error sending DM embeds:", err)
+		return nil
+	}
+	if history_pages(history) > 1 {
+		s.MessageReactionAdd(channel.ID, msg.ID, HISTORY_PREV)
+		s.MessageReactionAdd(channel.ID, msg.ID, HISTORY_NEXT)
+	}
+	return nil
+}
+
+// Moves a history embed one page back or forward. Reactions can't be removed
+// from DMs by the bot, so both adding and removing one turns the page.
+func turnHistoryPage(s *discordgo.Session, r *discordgo.MessageReaction, msg *discordgo.Message) {
+	if len(msg.Embeds) == 0 || msg.Embeds[0].Footer == nil {
+		return
+	}
+	match := history_footer.FindStringSubmatch(msg.Embeds[0].Footer.Text)
+	if match == nil {
+		return
+	}
+	page, _ := strconv.Atoi(match[1])
+	if r.Emoji.Name == HISTORY_PREV {
+		page--
+	} else {
+		page++
+	}
+
+	history, err := fetch_history(r.UserID, strings.Fields(match[3])[1:])
+	if err != nil || len(history) == 0 {
+		return
+	}
+	if page < 1 || page > history_pages(history) {
+		return
+	}
+	if _, err := s.ChannelMessageEditEmbed(r.ChannelID, r.MessageID, history_embed(history, match[3], page)); err != nil {
+		fmt.Println("Error editing history embed:", err)
+	}
+}
+
+func history_pages(history []users.NotificationHistory) int {
+	return (len(history) + HISTORY_PAGE_SIZE - 1) / HISTORY_PAGE_SIZE
+}
+
+func history_embed(history []users.NotificationHistory, command string, page int) *discordgo.MessageEmbed {
+	start := (page - 1) * HISTORY_PAGE_SIZE
+	end := start + HISTORY_PAGE_SIZE
+	if end > len(history) {
+		end = len(history)
+	}
+
+	var fields []*discordgo.MessageEmbedField
+	for _, h := range history[start:end] {
+		link := h.Title
+		if link == "" {
+			link = "Message"
+		}
+		link = notifications.Truncate(link, 100)
+		if h.Url != "" {
+			link = fmt.Sprintf("[%s](%s)", link, h.Url)
+		}
+
+		via, ok := delivery_names[h.Delivery]
+		if !ok {
+			via = h.Delivery
+		}
+		value := fmt.Sprintf("%s\n<t:%d:R> via %s, %s", link, h.Created.Unix(), via, h.Status)
+		if len(h.MatchedTerms) > 0 {
+			value += "\nMatched `" + strings.Join(h.MatchedTerms, "`, `") + "`"
+		}
+		fields = append(fields, &discordgo.MessageEmbedField{
+			Name:  notifications.Truncate(h.Keyword, 256),
+			Value: notifications.Truncate(value, 1024),
+		})
+	}
+
+	return &discordgo.MessageEmbed{
+		Color:  0xe671dc,
+		Title:  fmt.Sprintf("Notification history (%d)", len(history)),
+		Fields: fields,
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: fmt.Sprintf("Page %d/%d · %s", page, history_pages(history), command),
+		},
+	}
+}
+
+var delivery_names = map[string]string{
+	"dm":       "DM",
+	"webhook":  "webhook",
+	"digest":   "digest",
+	"slack":    "Slack",
+	"json":     "JSON webhook",
+	"ntfy":     "ntfy",
+	"email":    "email",
+	"telegram": "Telegram",
+}
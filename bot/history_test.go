@@ -0,0 +1,36 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseHistoryArgs(t *testing.T) {
+	cases := []struct {
+		args  []string
+		limit int
+		since time.Duration
+	}{
+		{nil, HISTORY_DEFAULT, 0},
+		{[]string{"25"}, 25, 0},
+		{[]string{"12h"}, HISTORY_MAX, time.Hour * 12},
+		{[]string{"3D"}, HISTORY_MAX, time.Hour * 72},
+		{[]string{"yesterday"}, HISTORY_MAX, time.Hour * 24},
+	}
+	for _, c := range cases {
+		limit, since, err := parse_history_args(c.args)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", c.args, err)
+			continue
+		}
+		if limit != c.limit || since != c.since {
+			t.Errorf("%q: got %d, %v expect %d, %v", c.args, limit, since, c.limit, c.since)
+		}
+	}
+
+	for _, args := range [][]string{{"0"}, {"101"}, {"2x"}, {"0d"}, {"1", "2"}} {
+		if _, _, err := parse_history_args(args); err == nil {
+			t.Errorf("%q: expected error", args)
+		}
+	}
+}
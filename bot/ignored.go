@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"mechfeed/notifications"
+	"mechfeed/users"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Readable names of sources
+var source_names = map[string]string{
+	"discordportal": "Discord",
+	"redditportal":  "Reddit",
+}
+
+func ignored_line(ig users.IgnoredAuthor) string {
+	line := "- " + ig.AuthorName
+	if ig.AuthorName == "" {
+		line = "- " + ig.AuthorID
+	}
+	if name, ok := source_names[ig.Source]; ok {
+		line += " (" + name + ")"
+	}
+	return line + "\n"
+}
+
+// Lists ignored authors, the blocklist first and then by alert number
+func ignored_list(alerts []users.UserAlert, ignored []users.IgnoredAuthor) string {
+	by_alert := make(map[int32][]users.IgnoredAuthor)
+	var blocked []users.IgnoredAuthor
+	for _, ig := range ignored {
+		if ig.AlertID.Valid {
+			by_alert[ig.AlertID.Int32] = append(by_alert[ig.AlertID.Int32], ig)
+		} else {
+			blocked = append(blocked, ig)
+		}
+	}
+
+	var sb strings.Builder
+	if len(blocked) > 0 {
+		sb.WriteString("Blocked on all alerts:\n")
+		for _, ig := range blocked {
+			sb.WriteString(ignored_line(ig))
+		}
+	}
+	for i, alert := range alerts {
+		if len(by_alert[alert.AlertID]) == 0 {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(fmt.Sprintf("[%d] %s:\n", i+1, alert.Keyword))
+		for _, ig := range by_alert[alert.AlertID] {
+			sb.WriteString(ignored_line(ig))
+		}
+	}
+	return sb.String()
+}
+
+func handleIgnored(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to get ignored sellers, please contact dev or try again later")
+	}
+	alerts, err := repo.Queries.GetUserAlerts(repo.Ctx, m.Author.ID)
+	if err != nil {
+		fmt.Println("failed to query DB for alerts")
+		return errors.New("failed to get ignored sellers, please contact dev or try again later")
+	}
+	ignored, err := repo.Queries.GetUserIgnoredAuthors(repo.Ctx, m.Author.ID)
+	if err != nil {
+		fmt.Println("failed to query DB for ignored authors:", err)
+		return errors.New("failed to get ignored sellers, please contact dev or try again later")
+	}
+
+	list := ignored_list(alerts, ignored)
+	if list == "" {
+		SendTextDM(s, m.Author.ID, "You aren't ignoring any sellers.")
+		return nil
+	}
+	list = notifications.Truncate(list, 1900)
+	SendTextDM(s, m.Author.ID, "```"+list+"```Use `!unignore <seller> [n]` to see their listings again.")
+	return nil
+}
+
+func handleUnignore(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to unignore seller, please contact dev or try again later")
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: `!unignore <seller> [n]`, e.g. `!unignore keebseller 2`, without an alert number the seller is unignored everywhere")
+	}
+
+	params := users.DeleteIgnoredAuthorsParams{ID: m.Author.ID, Author: args[0]}
+	where := "anywhere"
+	if len(args) == 2 {
+		alert, err := numbered_alert(repo, m.Author.ID, args[1])
+		if err != nil {
+			return err
+		}
+		params.AlertID = sql.NullInt32{Int32: alert.AlertID, Valid: true}
+		where = fmt.Sprintf("on `%s`", alert.Keyword)
+	}
+	deleted, err := repo.Queries.DeleteIgnoredAuthors(repo.Ctx, params)
+	if err != nil {
+		fmt.Println("failed to delete ignored authors:", err)
+		return errors.New("failed to unignore seller, please contact dev or try again later")
+	}
+	if deleted == 0 {
+		return fmt.Errorf("'%s' isn't ignored %s, see `!ignored`", args[0], where)
+	}
+	SendTextDM(s, m.Author.ID, fmt.Sprintf("'%s' is no longer ignored %s.", args[0], where))
+	return nil
+}
+
+func handleBlock(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to block seller, please contact dev or try again later")
+	}
+	if len(args) != 1 {
+		return errors.New("usage: `!block <seller>` with their Reddit or Discord username, or Discord user ID")
+	}
+	if len(args[0]) > 64 {
+		return errors.New("seller names can be at most 64 characters long")
+	}
+
+	err = repo.Queries.AddIgnoredAuthor(repo.Ctx, users.AddIgnoredAuthorParams{
+		ID:         m.Author.ID,
+		AuthorID:   args[0],
+		AuthorName: args[0],
+	})
+	if err != nil {
+		fmt.Println("failed to add ignored author:", err)
+		return errors.New("failed to block seller, please contact dev or try again later")
+	}
+	SendTextDM(s, m.Author.ID, fmt.Sprintf("Will exclude '%s' from all your alerts. See `!ignored` to undo.", args[0]))
+	return nil
+}
@@ -0,0 +1,26 @@
+package bot
+
+import (
+	"database/sql"
+	"mechfeed/users"
+	"testing"
+)
+
+func TestIgnoredList(t *testing.T) {
+	alerts := []users.UserAlert{
+		{AlertID: 7, Keyword: "olivia"},
+		{AlertID: 9, Keyword: "kaze"},
+	}
+	ignored := []users.IgnoredAuthor{
+		{AuthorID: "spammer", AuthorName: "spammer"},
+		{AlertID: sql.NullInt32{Int32: 9, Valid: true}, Source: "discordportal", AuthorID: "1234", AuthorName: "flipper"},
+		{AlertID: sql.NullInt32{Int32: 9, Valid: true}, Source: "redditportal", AuthorID: "keebseller"},
+	}
+	expect := "Blocked on all alerts:\n- spammer\n\n[2] kaze:\n- flipper (Discord)\n- keebseller (Reddit)\n"
+	if got := ignored_list(alerts, ignored); got != expect {
+		t.Errorf("got %q expect %q", got, expect)
+	}
+	if got := ignored_list(alerts, nil); got != "" {
+		t.Errorf("got %q for no ignores", got)
+	}
+}
@@ -0,0 +1,887 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"mechfeed/filter"
+	"mechfeed/notifications"
+	"mechfeed/users"
+	"strconv"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Discord limits for autocomplete and select menus
+const (
+	MAX_CHOICES      = 25
+	MAX_CHOICE_CHARS = 100
+)
+
+var dm_permission = true
+
+func min_value(v float64) *float64 {
+	return &v
+}
+
+// Slash commands, each running the text command of the same name with its
+// options as arguments, in the order declared. Boolean options are flags
+// passed by name when set, e.g. /delete all:True runs "!delete all".
+var slash_commands = []*discordgo.ApplicationCommand{
+	{
+		Name:        "help",
+		Description: "Show how to use Mechfeed",
+	},
+	{
+		Name:        "start",
+		Description: "Sign up for Mechfeed alerts",
+	},
+	{
+		Name:        "add",
+		Description: "Add alerts",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "alerts",
+				Description: "Keywords, e.g. olivia | (gmk & botanical), separate alerts with a space",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        "list",
+		Description: "List your alerts",
+	},
+	{
+		Name:        "delete",
+		Description: "Delete an alert",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionInteger,
+				Name:         "alert",
+				Description:  "Alert to delete",
+				Autocomplete: true,
+				MinValue:     min_value(1),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionBoolean,
+				Name:        "all",
+				Description: "Delete all your alerts",
+			},
+		},
+	},
+	{
+		Name:        "edit",
+		Description: "Change an alert, keeping its ignored sellers",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionInteger,
+				Name:         "alert",
+				Description:  "Alert to change",
+				Required:     true,
+				Autocomplete: true,
+				MinValue:     min_value(1),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "keywords",
+				Description: "New keywords, e.g. gmk & olivia",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        "pause",
+		Description: "Pause an alert",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionInteger,
+				Name:         "alert",
+				Description:  "Alert to pause",
+				Required:     true,
+				Autocomplete: true,
+				MinValue:     min_value(1),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "duration",
+				Description: "How long to pause for, e.g. 12h, 3d or 1w, until resumed if left out",
+			},
+		},
+	},
+	{
+		Name:        "resume",
+		Description: "Resume a paused alert",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionInteger,
+				Name:         "alert",
+				Description:  "Alert to resume",
+				Required:     true,
+				Autocomplete: true,
+				MinValue:     min_value(1),
+			},
+		},
+	},
+	{
+		Name:        "ignored",
+		Description: "List the sellers you ignore",
+	},
+	{
+		Name:        "unignore",
+		Description: "See a seller's listings again",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "seller",
+				Description: "Seller's username or ID, as in /ignored",
+				Required:    true,
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionInteger,
+				Name:         "alert",
+				Description:  "Only unignore on this alert",
+				Autocomplete: true,
+				MinValue:     min_value(1),
+			},
+		},
+	},
+	{
+		Name:        "block",
+		Description: "Ignore a seller on all your alerts",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "seller",
+				Description: "Reddit or Discord username, or Discord user ID",
+				Required:    true,
+				MaxLength:   64,
+			},
+		},
+	},
+	{
+		Name:        "webhook",
+		Description: "Also post notifications to a Discord webhook",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "action",
+				Description: "What to do with your webhook",
+				Required:    true,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "set", Value: "set"},
+					{Name: "test", Value: "test"},
+					{Name: "remove", Value: "remove"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "url",
+				Description: "Webhook URL, for set",
+			},
+		},
+	},
+	{
+		Name:        "destinations",
+		Description: "List where your notifications are sent",
+	},
+	{
+		Name:        "destination",
+		Description: "Add, confirm, remove, enable, disable or test a notification destination",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "action",
+				Description: "What to do with the destination",
+				Required:    true,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "add", Value: "add"},
+					{Name: "confirm", Value: "confirm"},
+					{Name: "remove", Value: "remove"},
+					{Name: "enable", Value: "enable"},
+					{Name: "disable", Value: "disable"},
+					{Name: "test", Value: "test"},
+				},
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "name",
+				Description:  "Name of the destination, e.g. phone",
+				Required:     true,
+				Autocomplete: true,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "kind",
+				Description: "Kind of destination, for add",
+				Choices:     destination_kind_choices(),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "target",
+				Description: "Webhook URL, ntfy topic or email address, for add",
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "code",
+				Description: "Code emailed to the destination, for confirm",
+			},
+		},
+	},
+	{
+		Name:        "telegram",
+		Description: "Get a code to link a Telegram chat for notifications",
+	},
+	{
+		Name:        "route",
+		Description: "Send an alert to some of your destinations only",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionInteger,
+				Name:         "alert",
+				Description:  "Alert to route",
+				Required:     true,
+				Autocomplete: true,
+				MinValue:     min_value(1),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "destinations",
+				Description: "Names of destinations separated by spaces, or all",
+				Required:    true,
+			},
+		},
+	},
+	{
+		Name:        "history",
+		Description: "Show your recent notifications",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				Name:        "count",
+				Description: "Number of notifications",
+				MinValue:    min_value(1),
+				MaxValue:    HISTORY_MAX,
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "since",
+				Description: "Everything since a duration ago, e.g. 12h, 3d or 1w",
+			},
+		},
+	},
+	{
+		Name:        "digest",
+		Description: "Bundle notifications into an hourly or daily digest",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "mode",
+				Description: "How notifications are sent",
+				Required:    true,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "instant", Value: "instant"},
+					{Name: "hourly", Value: "hourly"},
+					{Name: "daily", Value: "daily"},
+				},
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "time",
+				Description: "Time of daily digests, e.g. 09:00",
+			},
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "timezone",
+				Description:  "Your timezone, e.g. America/New_York",
+				Autocomplete: true,
+			},
+		},
+	},
+	{
+		Name:        "timezone",
+		Description: "Show or set your timezone",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionString,
+				Name:         "timezone",
+				Description:  "Your timezone, e.g. America/New_York",
+				Autocomplete: true,
+			},
+		},
+	},
+	{
+		Name:        "quiet",
+		Description: "Show or set your quiet hours",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "hours",
+				Description: "Quiet hours, e.g. 23:00-07:00, or off",
+			},
+		},
+	},
+	{
+		Name:        "urgent",
+		Description: "Notify an alert during quiet hours",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:         discordgo.ApplicationCommandOptionInteger,
+				Name:         "alert",
+				Description:  "Alert to change",
+				Required:     true,
+				Autocomplete: true,
+				MinValue:     min_value(1),
+			},
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "urgent",
+				Description: "Whether the alert is urgent",
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "on", Value: "on"},
+					{Name: "off", Value: "off"},
+				},
+			},
+		},
+	},
+}
+
+func register_commands(s *discordgo.Session) error {
+	for _, command := range slash_commands {
+		command.DMPermission = &dm_permission
+	}
+	_, err := s.ApplicationCommandBulkOverwrite(s.State.User.ID, "", slash_commands)
+	return err
+}
+
+func interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		handleSlashCommand(s, i)
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		handleAutocomplete(s, i)
+	case discordgo.InteractionMessageComponent, discordgo.InteractionModalSubmit:
+		handleComponent(s, i)
+	}
+}
+
+// The user of an interaction, set on Member instead in servers
+func interaction_user(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// Converts the options of a slash command into text command arguments
+func slash_args(command *discordgo.ApplicationCommand, options []*discordgo.ApplicationCommandInteractionDataOption) []string {
+	given := make(map[string]*discordgo.ApplicationCommandInteractionDataOption, len(options))
+	for _, option := range options {
+		given[option.Name] = option
+	}
+	var args []string
+	for _, declared := range command.Options {
+		option, ok := given[declared.Name]
+		if !ok {
+			continue
+		}
+		switch option.Type {
+		case discordgo.ApplicationCommandOptionString:
+			args = append(args, strings.Fields(option.StringValue())...)
+		case discordgo.ApplicationCommandOptionInteger:
+			if option.Name == "alert" {
+				// Autocompleted with alert IDs
+				args = append(args, ALERT_ID_PREFIX+strconv.FormatInt(option.IntValue(), 10))
+			} else {
+				args = append(args, strconv.FormatInt(option.IntValue(), 10))
+			}
+		case discordgo.ApplicationCommandOptionBoolean:
+			if option.BoolValue() {
+				args = append(args, option.Name)
+			}
+		}
+	}
+	return args
+}
+
+func handleSlashCommand(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	data := i.ApplicationCommandData()
+	var command *discordgo.ApplicationCommand
+	for _, c := range slash_commands {
+		if c.Name == data.Name {
+			command = c
+		}
+	}
+	user := interaction_user(i)
+	if command == nil || user == nil {
+		fmt.Println("Invalid slash command:", data.Name)
+		return
+	}
+
+	// Acknowledge within Discord's 3 seconds, replies are sent by DM as for
+	// text commands
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+	})
+	if err != nil {
+		fmt.Println("Error responding to slash command:", err)
+		return
+	}
+
+	m := &discordgo.MessageCreate{Message: &discordgo.Message{
+		Author:    user,
+		ChannelID: i.ChannelID,
+		Content:   "!" + data.Name,
+	}}
+	_, err = run_command(s, m, "!"+data.Name, slash_args(command, data.Options))
+	if err == nil && i.GuildID == "" {
+		// The replies are right below in the DM
+		s.InteractionResponseDelete(i.Interaction)
+		return
+	}
+	content := "Sent you a DM."
+	if err != nil {
+		content = err.Error()
+	}
+	if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{Content: &content}); err != nil {
+		fmt.Println("Error editing slash command response:", err)
+	}
+}
+
+func handleAutocomplete(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user := interaction_user(i)
+	if user == nil {
+		return
+	}
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, option := range i.ApplicationCommandData().Options {
+		if !option.Focused {
+			continue
+		}
+		typed := strings.ToLower(strings.TrimSpace(fmt.Sprint(option.Value)))
+		switch option.Name {
+		case "alert":
+			choices = alert_choices(user.ID, typed)
+		case "timezone":
+			choices = timezone_choices(typed)
+		case "name":
+			choices = destination_choices(user.ID, typed)
+		}
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{Choices: choices},
+	})
+	if err != nil {
+		fmt.Println("Error responding to autocomplete:", err)
+	}
+}
+
+func destination_kind_choices() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, kind := range append([]string{WEBHOOK_KIND}, users.DestinationKinds...) {
+		if kind == users.DestinationTelegram {
+			// Linked with /telegram instead
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: destination_kinds[kind], Value: kind})
+	}
+	return choices
+}
+
+// The user's destinations starting with typed
+func destination_choices(user_id, typed string) []*discordgo.ApplicationCommandOptionChoice {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return nil
+	}
+	destinations, err := repo.Queries.GetUserDestinations(repo.Ctx, user_id)
+	if err != nil {
+		fmt.Println("failed to query DB for destinations:", err)
+		return nil
+	}
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, d := range destinations {
+		if strings.HasPrefix(d.Name, typed) && len(choices) < MAX_CHOICES {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: d.Name, Value: d.Name})
+		}
+	}
+	return choices
+}
+
+// The user's alerts containing typed, numbered as in !list and valued by ID
+func alert_choices(user_id, typed string) []*discordgo.ApplicationCommandOptionChoice {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return nil
+	}
+	alerts, err := repo.Queries.GetUserAlerts(repo.Ctx, user_id)
+	if err != nil {
+		fmt.Println("failed to query DB for alerts")
+		return nil
+	}
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for n, alert := range alerts {
+		name := notifications.Truncate(fmt.Sprintf("[%d] %s", n+1, alert.Keyword), MAX_CHOICE_CHARS)
+		if typed != "" && !strings.Contains(strings.ToLower(name), typed) {
+			continue
+		}
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: alert.AlertID})
+		if len(choices) == MAX_CHOICES {
+			break
+		}
+	}
+	return choices
+}
+
+// Suggested in autocomplete, any other IANA timezone can be typed out
+var common_timezones = []string{
+	"UTC",
+	"America/New_York",
+	"America/Chicago",
+	"America/Denver",
+	"America/Los_Angeles",
+	"America/Anchorage",
+	"America/Toronto",
+	"America/Vancouver",
+	"America/Mexico_City",
+	"America/Sao_Paulo",
+	"Europe/London",
+	"Europe/Dublin",
+	"Europe/Paris",
+	"Europe/Berlin",
+	"Europe/Amsterdam",
+	"Europe/Stockholm",
+	"Europe/Warsaw",
+	"Europe/Helsinki",
+	"Asia/Kolkata",
+	"Asia/Singapore",
+	"Asia/Manila",
+	"Asia/Shanghai",
+	"Asia/Seoul",
+	"Asia/Tokyo",
+	"Australia/Perth",
+	"Australia/Sydney",
+	"Pacific/Auckland",
+}
+
+func timezone_choices(typed string) []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	add := func(name string) {
+		if len(choices) < MAX_CHOICES {
+			choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+		}
+	}
+	known := false
+	for _, name := range common_timezones {
+		if strings.ToLower(name) == typed {
+			known = true
+		}
+	}
+	for _, name := range common_timezones {
+		if strings.Contains(strings.ToLower(name), typed) {
+			add(name)
+		}
+	}
+	if !known && typed != "" {
+		// Timezones are case-sensitive, e.g. europe/oslo doesn't load
+		for _, candidate := range []string{typed, title_timezone(typed)} {
+			if valid_timezone(candidate) {
+				choices = append([]*discordgo.ApplicationCommandOptionChoice{{Name: candidate, Value: candidate}}, choices...)
+				break
+			}
+		}
+		if len(choices) > MAX_CHOICES {
+			choices = choices[:MAX_CHOICES]
+		}
+	}
+	return choices
+}
+
+// "america/new_york" -> "America/New_York"
+func title_timezone(name string) string {
+	b := []byte(name)
+	for i := range b {
+		if i == 0 || b[i-1] == '/' || b[i-1] == '_' || b[i-1] == '-' {
+			b[i] = strings.ToUpper(string(b[i]))[0]
+		}
+	}
+	return string(b)
+}
+
+// Components
+
+// Component custom IDs are "<action>:<argument>", e.g. "alert_delete:42"
+var component_handlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, arg string) error{
+	"alert_select":     handleAlertSelect,
+	"alert_edit":       handleAlertEdit,
+	"alert_edit_modal": handleAlertEditSubmit,
+	"alert_urgent":     handleAlertUrgent,
+	"alert_delete":     handleAlertDelete,
+	"notify_ignore":    handleNotifyIgnore,
+	"notify_mute":      handleNotifyMute,
+	"notify_delete":    handleNotifyDelete,
+}
+
+func handleComponent(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var custom_id string
+	if i.Type == discordgo.InteractionModalSubmit {
+		custom_id = i.ModalSubmitData().CustomID
+	} else {
+		custom_id = i.MessageComponentData().CustomID
+	}
+	action, arg, _ := strings.Cut(custom_id, ":")
+	handler, ok := component_handlers[action]
+	user := interaction_user(i)
+	if !ok || user == nil {
+		fmt.Println("Unexpected component:", custom_id)
+		return
+	}
+	if err := handler(s, i, user, arg); err != nil {
+		respond(s, i, err.Error(), nil)
+	}
+}
+
+// Replies to an interaction with a message only the user sees
+func respond(s *discordgo.Session, i *discordgo.InteractionCreate, content string, components []discordgo.MessageComponent) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: components,
+			Flags:      discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		fmt.Println("Error responding to interaction:", err)
+	}
+}
+
+// Replaces the message the component is on
+func update(s *discordgo.Session, i *discordgo.InteractionCreate, content string, components []discordgo.MessageComponent) {
+	if components == nil {
+		components = []discordgo.MessageComponent{}
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseUpdateMessage,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: components,
+		},
+	})
+	if err != nil {
+		fmt.Println("Error updating interaction message:", err)
+	}
+}
+
+// Fetches an alert by its ID, checking that it belongs to the user
+func owned_alert(user_id, arg string) (users.UserAlert, error) {
+	alert_id, err := strconv.ParseInt(arg, 10, 32)
+	if err != nil {
+		return users.UserAlert{}, errors.New("invalid alert")
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return users.UserAlert{}, errors.New("failed to get alert, please contact dev or try again later")
+	}
+	alert, err := repo.Queries.GetAlert(repo.Ctx, int32(alert_id))
+	if err != nil || alert.ID != user_id {
+		return users.UserAlert{}, errors.New("alert not found, it may have been deleted")
+	}
+	return alert, nil
+}
+
+// Select menu on !list to pick an alert to edit or delete
+func alert_list_components(alerts []users.UserAlert) []discordgo.MessageComponent {
+	if len(alerts) == 0 {
+		return nil
+	}
+	var options []discordgo.SelectMenuOption
+	for n, alert := range alerts {
+		if len(options) == MAX_CHOICES {
+			break
+		}
+		options = append(options, discordgo.SelectMenuOption{
+			Label: notifications.Truncate(fmt.Sprintf("[%d] %s", n+1, alert.Keyword), MAX_CHOICE_CHARS),
+			Value: strconv.Itoa(int(alert.AlertID)),
+		})
+	}
+	placeholder := "Edit or delete an alert"
+	if len(alerts) > MAX_CHOICES {
+		placeholder = fmt.Sprintf("Edit or delete one of your first %d alerts", MAX_CHOICES)
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.SelectMenu{
+				CustomID:    "alert_select",
+				Placeholder: placeholder,
+				Options:     options,
+			},
+		}},
+	}
+}
+
+// Buttons to edit, toggle urgent and delete an alert
+func alert_components(alert users.UserAlert) []discordgo.MessageComponent {
+	id := strconv.Itoa(int(alert.AlertID))
+	urgent := "Mark urgent"
+	if alert.Urgent {
+		urgent = "Unmark urgent"
+	}
+	return []discordgo.MessageComponent{
+		discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+			discordgo.Button{Label: "Edit", Style: discordgo.PrimaryButton, CustomID: "alert_edit:" + id},
+			discordgo.Button{Label: urgent, Style: discordgo.SecondaryButton, CustomID: "alert_urgent:" + id},
+			discordgo.Button{Label: "Delete", Style: discordgo.DangerButton, CustomID: "alert_delete:" + id},
+		}},
+	}
+}
+
+func alert_summary(alert users.UserAlert) string {
+	summary := fmt.Sprintf("`%s`", alert.Keyword)
+	if alert.Urgent {
+		summary += " (urgent)"
+	}
+	return summary
+}
+
+func handleAlertSelect(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, _ string) error {
+	values := i.MessageComponentData().Values
+	if len(values) == 0 {
+		return nil
+	}
+	alert, err := owned_alert(user.ID, values[0])
+	if err != nil {
+		return err
+	}
+	respond(s, i, alert_summary(alert), alert_components(alert))
+	return nil
+}
+
+func handleAlertEdit(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, arg string) error {
+	alert, err := owned_alert(user.ID, arg)
+	if err != nil {
+		return err
+	}
+	err = s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseModal,
+		Data: &discordgo.InteractionResponseData{
+			CustomID: "alert_edit_modal:" + arg,
+			Title:    "Edit alert",
+			Components: []discordgo.MessageComponent{
+				discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+					discordgo.TextInput{
+						CustomID:  "keyword",
+						Label:     "Alert",
+						Style:     discordgo.TextInputShort,
+						Value:     alert.Keyword,
+						Required:  true,
+						MaxLength: MAX_ALERT_LENGTH,
+					},
+				}},
+			},
+		},
+	})
+	if err != nil {
+		fmt.Println("Error opening edit modal:", err)
+	}
+	return nil
+}
+
+func handleAlertEditSubmit(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, arg string) error {
+	alert, err := owned_alert(user.ID, arg)
+	if err != nil {
+		return err
+	}
+	keyword := ""
+	for _, row := range i.ModalSubmitData().Components {
+		if row, ok := row.(*discordgo.ActionsRow); ok {
+			for _, c := range row.Components {
+				if input, ok := c.(*discordgo.TextInput); ok && input.CustomID == "keyword" {
+					keyword = strings.TrimSpace(input.Value)
+				}
+			}
+		}
+	}
+	keyword, err = validate_alert(keyword)
+	if err != nil {
+		return err
+	}
+
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to edit alert, please contact dev or try again later")
+	}
+	err = repo.Queries.UpdateAlertKeyword(repo.Ctx, users.UpdateAlertKeywordParams{
+		AlertID: alert.AlertID,
+		Keyword: keyword,
+	})
+	if err != nil {
+		fmt.Println("failed to update alert:", err)
+		return errors.New("failed to edit alert, please contact dev or try again later")
+	}
+	alert.Keyword = keyword
+	respond(s, i, "Alert changed to "+alert_summary(alert), nil)
+	return nil
+}
+
+// Checks that input is a single valid alert
+func validate_alert(input string) (string, error) {
+	alerts, err := filter.SplitAlerts(input)
+	if err != nil {
+		return "", fmt.Errorf("invalid alert %v", err)
+	}
+	if len(alerts) != 1 {
+		return "", errors.New("enter a single alert, use `&` or `|` to combine keywords")
+	}
+	if len(alerts[0]) > MAX_ALERT_LENGTH {
+		return "", fmt.Errorf("invalid alert %q: alerts can be at most %d characters long", alerts[0], MAX_ALERT_LENGTH)
+	}
+	return alerts[0], nil
+}
+
+func handleAlertUrgent(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, arg string) error {
+	alert, err := owned_alert(user.ID, arg)
+	if err != nil {
+		return err
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to update alert, please contact dev or try again later")
+	}
+	alert.Urgent = !alert.Urgent
+	err = repo.Queries.SetAlertUrgent(repo.Ctx, users.SetAlertUrgentParams{
+		AlertID: alert.AlertID,
+		Urgent:  alert.Urgent,
+	})
+	if err != nil {
+		fmt.Println("failed to update alert:", err)
+		return errors.New("failed to update alert, please contact dev or try again later")
+	}
+	update(s, i, alert_summary(alert), alert_components(alert))
+	return nil
+}
+
+func handleAlertDelete(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, arg string) error {
+	alert, err := owned_alert(user.ID, arg)
+	if err != nil {
+		return err
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to delete alert, please contact dev or try again later")
+	}
+	if err := repo.Queries.DeleteAlert(repo.Ctx, alert.AlertID); err != nil {
+		fmt.Println("failed to delete alert:", err)
+		return errors.New("failed to delete alert, please contact dev or try again later")
+	}
+	update(s, i, fmt.Sprintf("Deleted `%s`.", alert.Keyword), nil)
+	return nil
+}
@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func slash_command(name string) *discordgo.ApplicationCommand {
+	for _, c := range slash_commands {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestSlashArgs(t *testing.T) {
+	option := func(name string, typ discordgo.ApplicationCommandOptionType, value interface{}) *discordgo.ApplicationCommandInteractionDataOption {
+		return &discordgo.ApplicationCommandInteractionDataOption{Name: name, Type: typ, Value: value}
+	}
+	cases := []struct {
+		command string
+		options []*discordgo.ApplicationCommandInteractionDataOption
+		expect  []string
+	}{
+		{"list", nil, nil},
+		{"add", []*discordgo.ApplicationCommandInteractionDataOption{
+			option("alerts", discordgo.ApplicationCommandOptionString, "olivia  gmk,dandy"),
+		}, []string{"olivia", "gmk,dandy"}},
+		{"delete", []*discordgo.ApplicationCommandInteractionDataOption{
+			option("all", discordgo.ApplicationCommandOptionBoolean, true),
+		}, []string{"all"}},
+		{"delete", []*discordgo.ApplicationCommandInteractionDataOption{
+			option("alert", discordgo.ApplicationCommandOptionInteger, float64(3)),
+			option("all", discordgo.ApplicationCommandOptionBoolean, false),
+		}, []string{"#3"}},
+		{"destination", []*discordgo.ApplicationCommandInteractionDataOption{
+			option("action", discordgo.ApplicationCommandOptionString, "confirm"),
+			option("code", discordgo.ApplicationCommandOptionString, "ABCD2345"),
+			option("name", discordgo.ApplicationCommandOptionString, "mail"),
+		}, []string{"confirm", "mail", "ABCD2345"}},
+		{"history", []*discordgo.ApplicationCommandInteractionDataOption{
+			option("count", discordgo.ApplicationCommandOptionInteger, float64(20)),
+		}, []string{"20"}},
+		// Declared order, whatever order the options were given in
+		{"digest", []*discordgo.ApplicationCommandInteractionDataOption{
+			option("timezone", discordgo.ApplicationCommandOptionString, "Europe/Berlin"),
+			option("mode", discordgo.ApplicationCommandOptionString, "daily"),
+			option("time", discordgo.ApplicationCommandOptionString, "09:00"),
+		}, []string{"daily", "09:00", "Europe/Berlin"}},
+	}
+	for _, c := range cases {
+		got := slash_args(slash_command(c.command), c.options)
+		if !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("/%s: got %q expect %q", c.command, got, c.expect)
+		}
+	}
+}
+
+func TestSlashCommandsHaveTextCommands(t *testing.T) {
+	for _, c := range slash_commands {
+		_, ok := commands["!"+c.Name]
+		_, protected := protected_commands["!"+c.Name]
+		if !ok && !protected {
+			t.Errorf("/%s has no text command", c.Name)
+		}
+	}
+}
+
+func TestTimezoneChoices(t *testing.T) {
+	choices := timezone_choices("europe/oslo")
+	if len(choices) == 0 || choices[0].Value != "Europe/Oslo" {
+		t.Errorf("got %+v expect Europe/Oslo first", choices)
+	}
+	if got := len(timezone_choices("")); got != MAX_CHOICES {
+		t.Errorf("got %d choices expect %d", got, MAX_CHOICES)
+	}
+	for _, choice := range timezone_choices("new_york") {
+		if choice.Value != "America/New_York" {
+			t.Errorf("unexpected choice %v", choice.Value)
+		}
+	}
+}
+
+func TestNotificationComponents(t *testing.T) {
+	row := func(components []discordgo.MessageComponent) []discordgo.MessageComponent {
+		if len(components) != 1 {
+			t.Fatalf("got %d rows expect 1", len(components))
+		}
+		return components[0].(discordgo.ActionsRow).Components
+	}
+
+	buttons := row(notification_components(DMNotification{AlertID: 42, Source: "discordportal", AuthorID: "123456789012345678", URL: "https://discord.com/channels/1/2/3"}))
+	expect := []string{"notify_ignore:42:discordportal:123456789012345678", "notify_mute:42", "notify_delete:42", ""}
+	if len(buttons) != len(expect) {
+		t.Fatalf("got %d buttons expect %d", len(buttons), len(expect))
+	}
+	for i, b := range buttons {
+		if id := b.(discordgo.Button).CustomID; id != expect[i] || len(id) > 100 {
+			t.Errorf("button %d: got custom ID %q expect %q", i, id, expect[i])
+		}
+	}
+	if link := buttons[3].(discordgo.Button); link.Style != discordgo.LinkButton || link.URL == "" {
+		t.Errorf("expected link button to open the listing, got %+v", link)
+	}
+
+	if got := row(notification_components(DMNotification{AlertID: 42})); len(got) != 2 {
+		t.Errorf("got %d buttons without author and URL expect 2", len(got))
+	}
+	if got := notification_components(DMNotification{}); got != nil {
+		t.Errorf("got %+v for public notification", got)
+	}
+}
+
+func TestIgnoreCustomID(t *testing.T) {
+	cases := []struct {
+		n                              DMNotification
+		id                             string
+		source, author_id, author_name string
+	}{
+		{
+			DMNotification{AlertID: 42, Source: "redditportal", AuthorID: "keebseller", AuthorName: "keebseller"},
+			"notify_ignore:42:redditportal:keebseller",
+			"redditportal", "keebseller", "keebseller",
+		},
+		{
+			DMNotification{AlertID: 42, Source: "discordportal", AuthorID: "123456789012345678", AuthorName: "flipper"},
+			"notify_ignore:42:discordportal:123456789012345678:flipper",
+			"discordportal", "123456789012345678", "flipper",
+		},
+	}
+	for _, c := range cases {
+		id := ignore_custom_id(c.n)
+		if id != c.id {
+			t.Errorf("got custom ID %q expect %q", id, c.id)
+		}
+		alert_id, source, author_id, author_name, ok := parse_ignore_arg(id[len("notify_ignore:"):])
+		if !ok || alert_id != "42" || source != c.source || author_id != c.author_id || author_name != c.author_name {
+			t.Errorf("%q parsed to %q %q %q %q", id, alert_id, source, author_id, author_name)
+		}
+	}
+
+	// Buttons sent before sources were added
+	if _, source, author_id, _, ok := parse_ignore_arg("42:keebseller"); !ok || source != "" || author_id != "keebseller" {
+		t.Errorf("failed to parse legacy custom ID")
+	}
+}
@@ -1,11 +1,14 @@
 package bot
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"mechfeed/filter"
 	"mechfeed/users"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
@@ -13,6 +16,9 @@ import (
 
 var DISCORD_BOT_TOKEN string
 
+// Matches the size of user_alerts.keyword
+const MAX_ALERT_LENGTH = 255
+
 var BotSession struct {
 	active bool
 	dg *discordgo.Session
@@ -27,7 +33,8 @@ func init_bot() error {
 	return nil
 }
 
-func MechfeedBot() {
+// MechfeedBot runs the bot until ctx is cancelled, then closes its session.
+func MechfeedBot(ctx context.Context) {
 	err := init_bot()
 	if err != nil {
 		fmt.Println("error starting mechfeed bot,", err)
@@ -45,6 +52,8 @@ func MechfeedBot() {
 
 	dg.AddHandler(messageCreate)
 	dg.AddHandler(messageReact)
+	dg.AddHandler(messageUnreact)
+	dg.AddHandler(interactionCreate)
 	dg.Identify.Intents = discordgo.IntentsGuildMessages |
 						  discordgo.IntentsDirectMessages |
 						  discordgo.IntentsDirectMessageReactions
@@ -56,8 +65,15 @@ func MechfeedBot() {
 		return
 	}
 
+	// Text commands keep working if slash commands can't be registered
+	if err := register_commands(dg); err != nil {
+		fmt.Println("error registering slash commands,", err)
+	}
+
 	fmt.Println("🤖 Mechfeed bot is now running.")
-	select {}
+	<-ctx.Done()
+	BotSession.active = false
+	fmt.Println("🤖 Mechfeed bot is shutting down.")
 }
 
 var commands = map[string]func(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
@@ -70,6 +86,22 @@ var protected_commands = map[string]func(s *discordgo.Session, m *discordgo.Mess
 	"!add": handleAdd,
 	"!list": handleList,
 	"!delete": handleDelete,
+	"!history": handleHistory,
+	"!digest": handleDigest,
+	"!timezone": handleTimezone,
+	"!quiet": handleQuiet,
+	"!urgent": handleUrgent,
+	"!edit": handleEdit,
+	"!pause": handlePause,
+	"!resume": handleResume,
+	"!ignored": handleIgnored,
+	"!unignore": handleUnignore,
+	"!block": handleBlock,
+	"!webhook": handleWebhook,
+	"!destinations": handleDestinations,
+	"!destination": handleDestination,
+	"!route": handleRoute,
+	"!telegram": handleTelegram,
 }
 func messageReact(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
 	if r.UserID == s.State.User.ID {
@@ -78,62 +110,36 @@ func messageReact(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
 	}
 
 	msg, err := s.ChannelMessage(r.ChannelID, r.MessageID)
-	if msg.Author.ID != s.State.User.ID {
-		fmt.Println("Reacted to message not sent by bot")
-		return
-	}
-
 	if err != nil {
 		fmt.Println("Error fetching message,", err)
 		return
 	}
 
-	if r.Emoji.Name != "🔕" {
-		fmt.Println("Unexpected reaction emoji:", r.Emoji.Name)
+	if msg.Author.ID != s.State.User.ID {
+		fmt.Println("Reacted to message not sent by bot")
 		return
 	}
 
-	ignored_author := ""
-	alert := ""
-
-	if msg != nil {
-		for _, field := range msg.Embeds[0].Fields {
-			if field.Name == "Sent by" || field.Name == "Posted by" {
-				ignored_author = field.Value
-			} else if field.Name == "Matched alert" {
-				alert = field.Value
-			}
-		}
+	if r.Emoji.Name == HISTORY_PREV || r.Emoji.Name == HISTORY_NEXT {
+		turnHistoryPage(s, r.MessageReaction, msg)
 	}
+}
 
-	if ignored_author == "" {
-		fmt.Println("No author found, skipping user ignore...")
+func messageUnreact(s *discordgo.Session, r *discordgo.MessageReactionRemove) {
+	if r.UserID == s.State.User.ID {
 		return
 	}
-	if alert == "" {
-		fmt.Println("No alert found, skipping user ignore...")
+	if r.Emoji.Name != HISTORY_PREV && r.Emoji.Name != HISTORY_NEXT {
+		return
 	}
-
-	repo, err := users.DBConnection()
+	msg, err := s.ChannelMessage(r.ChannelID, r.MessageID)
 	if err != nil {
-		fmt.Println("Failed to get DB connection.")
-		SendTextDM(s, r.UserID, "Failed add user to ignore list for your alert. Please contact dev or try again later!")
+		fmt.Println("Error fetching message,", err)
 		return
 	}
-
-	err = repo.Queries.IgnoreUserForAlert(repo.Ctx, users.IgnoreUserForAlertParams{
-		Ignored: []string{ignored_author},
-		ID: r.UserID,
-		Keyword: strings.ReplaceAll(alert, "`", ""),
-	})
-
-	if err != nil {
-		fmt.Println("Failed to run ignore query.")
-		SendTextDM(s, r.UserID, "Failed add user to ignore list for your alert. Please contact dev or try again later!")
+	if msg.Author.ID == s.State.User.ID {
+		turnHistoryPage(s, r.MessageReaction, msg)
 	}
-
-	fmt.Println("Ignoring from Author:", ignored_author)
-	SendTextDM(s, r.UserID, fmt.Sprintf("`Will exclude '%s' from future matches for this alert.`", ignored_author))
 }
 
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
@@ -145,26 +151,31 @@ func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
 	cmd := input[0]
 	args := input[1:]
 
+	found, err := run_command(s, m, cmd, args)
+	if !found {
+		fmt.Println("Invalid command:", cmd)
+	} else if err != nil {
+		SendTextDM(s, m.Author.ID, err.Error())
+	}
+}
+
+// Runs a text command, also used by slash commands. Reports whether the
+// command exists.
+func run_command(s *discordgo.Session, m *discordgo.MessageCreate, cmd string, args []string) (bool, error) {
 	if handler, ok := commands[cmd]; ok {
 		fmt.Println(m.Author.Username, ":", cmd, " command received.")
-		err := handler(s, m, args)
-		if err != nil {
-			SendTextDM(s, m.Author.ID, err.Error())
-		}
-	} else if handler, ok := protected_commands[cmd]; ok {
-		repo, _ := users.DBConnection()
-		exists, _ := repo.Queries.GetUserExistence(repo.Ctx, m.Author.ID)
-		if exists == 0 {
-			SendTextDM(s, m.Author.ID, "Please use the `!start` command before using alert features.")
-			return
-		}
-		err := handler(s, m, args)
-		if err != nil {
-			SendTextDM(s, m.Author.ID, err.Error())
-		}
-	} else {
-		fmt.Println("Invalid command:", cmd)
+		return true, handler(s, m, args)
+	}
+	handler, ok := protected_commands[cmd]
+	if !ok {
+		return false, nil
 	}
+	repo, _ := users.DBConnection()
+	exists, _ := repo.Queries.GetUserExistence(repo.Ctx, m.Author.ID)
+	if exists == 0 {
+		return true, errors.New("Please use the `!start` command before using alert features.")
+	}
+	return true, handler(s, m, args)
 }
 
 func SendTextDM(s *discordgo.Session, userID, content string) {
@@ -179,6 +190,18 @@ func SendTextDM(s *discordgo.Session, userID, content string) {
 	}
 }
 
+func SendComplexDM(s *discordgo.Session, userID string, msg *discordgo.MessageSend) {
+	channel, err := s.UserChannelCreate(userID)
+	if err != nil {
+		fmt.Println("Error creating channel:", err)
+		return
+	}
+	_, err = s.ChannelMessageSendComplex(channel.ID, msg)
+	if err != nil {
+		fmt.Println("Error sending DM message:", err)
+	}
+}
+
 func SendMultipleEmbedsDM(s *discordgo.Session, userID string, embeds []*discordgo.MessageEmbed) {
 	channel, err := s.UserChannelCreate(userID)
 	if err != nil {
@@ -202,30 +225,21 @@ func SendEmbedDM(s *discordgo.Session, userID string, embed *discordgo.MessageEm
 		fmt.Println("Error sending DM embeds:", err)
 	}
 }
-// External use
-func IsolatedSendEmbedDM(user_id string, embed *discordgo.MessageEmbed) {
+var ErrBotInactive = errors.New("bot session inactive")
+
+// External use, sends up to 10 embeds in one message
+func IsolatedSendEmbedsDM(user_id string, embeds []*discordgo.MessageEmbed) error {
 	if !BotSession.active {
-		fmt.Println("bot session inactive.")
-		return
+		return ErrBotInactive
 	}
 	channel, err := BotSession.dg.UserChannelCreate(user_id)
 	if err != nil {
-		// can happen if no mutual servers
-		fmt.Println("error creating channel: ", err)
-		return
+		return fmt.Errorf("error creating channel: %w", err)
 	}
-
-	m, err := BotSession.dg.ChannelMessageSendEmbed(channel.ID, embed)
-	if err != nil {
-		// dont share server / disabled DM in settings
-		fmt.Println("error sending DM message:", err)
-	}
-
-	// Reaction to add user to ignore list
-	err = BotSession.dg.MessageReactionAdd(channel.ID, m.ID, "🔕")
-	if err != nil {
-		fmt.Println("Error adding reaction,", err)
+	if _, err := BotSession.dg.ChannelMessageSendEmbeds(channel.ID, embeds); err != nil {
+		return fmt.Errorf("error sending DM embeds: %w", err)
 	}
+	return nil
 }
 
 // Handlers
@@ -280,10 +294,33 @@ func handleList(s *discordgo.Session, m *discordgo.MessageCreate, args []string)
 	if len(alerts) == 0 {
 		SendTextDM(s, m.Author.ID, "No alerts found.")
 	} else {
+		ignored, err := repo.Queries.GetUserIgnoredAuthors(repo.Ctx, m.Author.ID)
+		if err != nil {
+			fmt.Println("failed to query DB for ignored authors:", err)
+		}
+		destinations, err := repo.Queries.GetUserDestinations(repo.Ctx, m.Author.ID)
+		if err != nil {
+			fmt.Println("failed to query DB for destinations:", err)
+		}
+		ignored_count := make(map[int32]int)
+		for _, ig := range ignored {
+			if ig.AlertID.Valid {
+				ignored_count[ig.AlertID.Int32]++
+			}
+		}
+
 		var sb strings.Builder
+		now := time.Now()
 		for i, alert := range alerts {
 			sb.WriteString(fmt.Sprintf("[%d] %s", i+1, alert.Keyword))
-			num_ignored := len(alert.Ignored)
+			if alert.Urgent {
+				sb.WriteString(" (urgent)")
+			}
+			sb.WriteString(pause_status(alert, now))
+			if routes := alert_routes(alert, destinations); len(routes) > 0 {
+				sb.WriteString(" -> " + strings.Join(routes, ", "))
+			}
+			num_ignored := ignored_count[alert.AlertID]
 			if num_ignored > 0 {
 				sb.WriteString(fmt.Sprintf(" (ignoring %d user", num_ignored))
 				if num_ignored > 1 {
@@ -293,7 +330,10 @@ func handleList(s *discordgo.Session, m *discordgo.MessageCreate, args []string)
 			}
 			sb.WriteString("\n")
 		}
-		SendTextDM(s, m.Author.ID, "```" + sb.String() + "```")
+		SendComplexDM(s, m.Author.ID, &discordgo.MessageSend{
+			Content:    "```" + sb.String() + "```",
+			Components: alert_list_components(alerts),
+		})
 	}
 	
 	return nil
@@ -310,11 +350,21 @@ func handleAdd(s *discordgo.Session, m *discordgo.MessageCreate, args []string)
 		return errors.New("no alerts provided")
 	}
 
+	alerts, err := filter.SplitAlerts(strings.Join(args, " "))
+	if err != nil {
+		return fmt.Errorf("invalid alert %v", err)
+	}
+	for _, alert := range alerts {
+		if len(alert) > MAX_ALERT_LENGTH {
+			return fmt.Errorf("invalid alert %q: alerts can be at most %d characters long", alert, MAX_ALERT_LENGTH)
+		}
+	}
+
 	failure := false
-	for _, arg := range args {
+	for _, alert := range alerts {
 		err := repo.Queries.CreateAlert(repo.Ctx, users.CreateAlertParams{
 			ID: m.Author.ID,
-			Keyword: arg,
+			Keyword: alert,
 		})
 		if err != nil {
 			failure = true
@@ -325,7 +375,7 @@ func handleAdd(s *discordgo.Session, m *discordgo.MessageCreate, args []string)
 		return errors.New("failed to add alerts, please contact dev or try again later")
 	} else {
 		var msg string
-		if len(args) == 1 {
+		if len(alerts) == 1 {
 			msg = "Successfully added alert!"
 		} else {
 			msg = "Successfully added alerts!"
@@ -375,6 +425,7 @@ func handleDelete(s *discordgo.Session, m *discordgo.MessageCreate, args []strin
 	var alert_id_map = map[string]int32{}
 	for i, alert := range alerts {
 		alert_id_map[fmt.Sprintf("%d", i+1)] = alert.AlertID
+		alert_id_map[fmt.Sprintf("%s%d", ALERT_ID_PREFIX, alert.AlertID)] = alert.AlertID
 	}
 
 	deleted := 0
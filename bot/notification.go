@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"mechfeed/users"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// How long "Mute alert" pauses an alert
+const MUTE_DURATION = time.Hour * 24
+
+// DMNotification is a notification DM with buttons acting on the alert and
+// the author of the listing that matched it.
+type DMNotification struct {
+	Embed      *discordgo.MessageEmbed `json:"embed"`
+	AlertID    int32                   `json:"alert_id"`
+	Source     string                  `json:"source"`
+	AuthorID   string                  `json:"author_id"`
+	AuthorName string                  `json:"author_name"`
+	URL        string                  `json:"url"`
+}
+
+// Discord limit for component custom IDs
+const MAX_CUSTOM_ID = 100
+
+// Custom ID of the "Ignore seller" button, the author's name is left out
+// when it doesn't fit or is the same as their ID
+func ignore_custom_id(n DMNotification) string {
+	id := fmt.Sprintf("notify_ignore:%d:%s:%s", n.AlertID, n.Source, n.AuthorID)
+	if n.AuthorName != "" && n.AuthorName != n.AuthorID && len(id)+1+len(n.AuthorName) <= MAX_CUSTOM_ID {
+		id += ":" + n.AuthorName
+	}
+	return id
+}
+
+// Parses the argument of an "Ignore seller" custom ID into the alert, source,
+// author ID and name. Buttons from before sources were added only carry the
+// alert and author.
+func parse_ignore_arg(arg string) (alert_id, source, author_id, author_name string, ok bool) {
+	parts := strings.SplitN(arg, ":", 4)
+	switch len(parts) {
+	case 2:
+		alert_id, author_id = parts[0], parts[1]
+	case 3:
+		alert_id, source, author_id = parts[0], parts[1], parts[2]
+	case 4:
+		alert_id, source, author_id, author_name = parts[0], parts[1], parts[2], parts[3]
+	}
+	if author_name == "" {
+		author_name = author_id
+	}
+	return alert_id, source, author_id, author_name, author_id != ""
+}
+
+// Buttons of a notification. Custom IDs carry the alert and author, e.g.
+// "notify_ignore:42:redditportal:keebseller" so that nothing is parsed back
+// from the embed.
+func notification_components(n DMNotification) []discordgo.MessageComponent {
+	var buttons []discordgo.MessageComponent
+	if n.AlertID != 0 {
+		id := strconv.Itoa(int(n.AlertID))
+		if n.AuthorID != "" && len(ignore_custom_id(n)) <= MAX_CUSTOM_ID {
+			buttons = append(buttons, discordgo.Button{Label: "Ignore seller", Style: discordgo.SecondaryButton, CustomID: ignore_custom_id(n)})
+		}
+		buttons = append(buttons,
+			discordgo.Button{Label: "Mute alert 24h", Style: discordgo.SecondaryButton, CustomID: "notify_mute:" + id},
+			discordgo.Button{Label: "Delete alert", Style: discordgo.DangerButton, CustomID: "notify_delete:" + id},
+		)
+	}
+	if strings.HasPrefix(n.URL, "https://") || strings.HasPrefix(n.URL, "http://") {
+		buttons = append(buttons, discordgo.Button{Label: "Open listing", Style: discordgo.LinkButton, URL: n.URL})
+	}
+	if len(buttons) == 0 {
+		return nil
+	}
+	return []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}
+}
+
+// External use
+func IsolatedSendNotificationDM(user_id string, n DMNotification) error {
+	if !BotSession.active {
+		return ErrBotInactive
+	}
+	channel, err := BotSession.dg.UserChannelCreate(user_id)
+	if err != nil {
+		// can happen if no mutual servers
+		return fmt.Errorf("error creating channel: %w", err)
+	}
+
+	_, err = BotSession.dg.ChannelMessageSendComplex(channel.ID, &discordgo.MessageSend{
+		Embeds:     []*discordgo.MessageEmbed{n.Embed},
+		Components: notification_components(n),
+	})
+	if err != nil {
+		// dont share server / disabled DM in settings
+		return fmt.Errorf("error sending DM message: %w", err)
+	}
+	return nil
+}
+
+func handleNotifyIgnore(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, arg string) error {
+	alert_id, source, author_id, author_name, ok := parse_ignore_arg(arg)
+	if !ok {
+		return errors.New("invalid seller")
+	}
+	alert, err := owned_alert(user.ID, alert_id)
+	if err != nil {
+		return err
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("Failed to get DB connection.")
+		return errors.New("failed to add seller to ignore list for your alert, please contact dev or try again later")
+	}
+	err = repo.Queries.AddIgnoredAuthor(repo.Ctx, users.AddIgnoredAuthorParams{
+		ID:         user.ID,
+		AlertID:    sql.NullInt32{Int32: alert.AlertID, Valid: true},
+		Source:     source,
+		AuthorID:   author_id,
+		AuthorName: author_name,
+	})
+	if err != nil {
+		fmt.Println("Failed to run ignore query:", err)
+		return errors.New("failed to add seller to ignore list for your alert, please contact dev or try again later")
+	}
+	fmt.Println("Ignoring from Author:", author_id)
+	respond(s, i, fmt.Sprintf("Will exclude '%s' from future matches for `%s`. See `!ignored` to undo.", author_name, alert.Keyword), nil)
+	return nil
+}
+
+func handleNotifyMute(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, arg string) error {
+	alert, err := owned_alert(user.ID, arg)
+	if err != nil {
+		return err
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("Failed to get DB connection.")
+		return errors.New("failed to mute alert, please contact dev or try again later")
+	}
+	err = repo.Queries.PauseAlert(repo.Ctx, users.PauseAlertParams{
+		AlertID:         alert.AlertID,
+		DurationSeconds: MUTE_DURATION.Seconds(),
+	})
+	if err != nil {
+		fmt.Println("Failed to mute alert:", err)
+		return errors.New("failed to mute alert, please contact dev or try again later")
+	}
+	until := time.Now().Add(MUTE_DURATION)
+	respond(s, i, fmt.Sprintf("Muted `%s` until <t:%d:f>.", alert.Keyword, until.Unix()), nil)
+	return nil
+}
+
+func handleNotifyDelete(s *discordgo.Session, i *discordgo.InteractionCreate, user *discordgo.User, arg string) error {
+	alert, err := owned_alert(user.ID, arg)
+	if err != nil {
+		return err
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("Failed to get DB connection.")
+		return errors.New("failed to delete alert, please contact dev or try again later")
+	}
+	if err := repo.Queries.DeleteAlert(repo.Ctx, alert.AlertID); err != nil {
+		fmt.Println("Failed to delete alert:", err)
+		return errors.New("failed to delete alert, please contact dev or try again later")
+	}
+	respond(s, i, fmt.Sprintf("Deleted `%s`.", alert.Keyword), nil)
+	return nil
+}
@@ -0,0 +1,150 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"mechfeed/digest"
+	"mechfeed/users"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Reports whether name is an IANA timezone such as Europe/Berlin or UTC
+func valid_timezone(name string) bool {
+	if name == "" || name == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(name)
+	return err == nil
+}
+
+func handleTimezone(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to update timezone, please contact dev or try again later")
+	}
+	if len(args) == 0 {
+		user, err := repo.Queries.GetUser(repo.Ctx, m.Author.ID)
+		if err != nil {
+			fmt.Println("failed to query DB for user:", err)
+			return errors.New("failed to get timezone, please contact dev or try again later")
+		}
+		local := time.Now().In(digest.Location(user.Timezone))
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("Your timezone is %s, where it is %s.\nChange it with `!timezone <zone>`, e.g. `!timezone America/New_York`", user.Timezone, local.Format("15:04")))
+		return nil
+	}
+	if len(args) > 1 || !valid_timezone(args[0]) {
+		return fmt.Errorf("invalid timezone %q, use a timezone such as America/New_York or Europe/Berlin", strings.Join(args, " "))
+	}
+
+	err = repo.Queries.UpdateUserTimezone(repo.Ctx, users.UpdateUserTimezoneParams{
+		ID:       m.Author.ID,
+		Timezone: args[0],
+	})
+	if err != nil {
+		fmt.Println("failed to update user timezone:", err)
+		return errors.New("failed to update timezone, please contact dev or try again later")
+	}
+	local := time.Now().In(digest.Location(args[0]))
+	SendTextDM(s, m.Author.ID, fmt.Sprintf("Timezone set to %s, where it is %s.", args[0], local.Format("15:04")))
+	return nil
+}
+
+func handleQuiet(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to update quiet hours, please contact dev or try again later")
+	}
+	user, err := repo.Queries.GetUser(repo.Ctx, m.Author.ID)
+	if err != nil {
+		fmt.Println("failed to query DB for user:", err)
+		return errors.New("failed to update quiet hours, please contact dev or try again later")
+	}
+
+	if len(args) == 0 {
+		if !user.QuietStart.Valid || !user.QuietEnd.Valid {
+			SendTextDM(s, m.Author.ID, "You have no quiet hours. Set them with `!quiet HH:MM-HH:MM`, e.g. `!quiet 23:00-07:00`")
+			return nil
+		}
+		SendTextDM(s, m.Author.ID, quiet_setting(int(user.QuietStart.Int16), int(user.QuietEnd.Int16), user.Timezone)+"\nTurn them off with `!quiet off`")
+		return nil
+	}
+	if len(args) > 1 {
+		return errors.New("usage: `!quiet HH:MM-HH:MM` or `!quiet off`, e.g. `!quiet 23:00-07:00`")
+	}
+
+	params := users.UpdateUserQuietHoursParams{ID: m.Author.ID}
+	if strings.ToLower(args[0]) != "off" {
+		start, end, err := digest.ParseQuietHours(args[0])
+		if err != nil {
+			return err
+		}
+		params.QuietStart = sql.NullInt16{Int16: int16(start), Valid: true}
+		params.QuietEnd = sql.NullInt16{Int16: int16(end), Valid: true}
+	}
+	if err := repo.Queries.UpdateUserQuietHours(repo.Ctx, params); err != nil {
+		fmt.Println("failed to update user quiet hours:", err)
+		return errors.New("failed to update quiet hours, please contact dev or try again later")
+	}
+
+	if !params.QuietStart.Valid {
+		SendTextDM(s, m.Author.ID, "Quiet hours turned off.")
+		return nil
+	}
+	msg := quiet_setting(int(params.QuietStart.Int16), int(params.QuietEnd.Int16), user.Timezone)
+	if user.Timezone == "UTC" {
+		msg += "\nSet your timezone with `!timezone <zone>` if you aren't on UTC."
+	}
+	SendTextDM(s, m.Author.ID, msg)
+	return nil
+}
+
+func quiet_setting(start, end int, timezone string) string {
+	return fmt.Sprintf("Notifications are held from %s to %s (%s), except for urgent alerts.", digest.FormatClock(start), digest.FormatClock(end), timezone)
+}
+
+func handleUrgent(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to update alert, please contact dev or try again later")
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("usage: `!urgent <n> [on|off]`, e.g. `!urgent 2`")
+	}
+	urgent := true
+	if len(args) == 2 {
+		switch strings.ToLower(args[1]) {
+		case "on":
+		case "off":
+			urgent = false
+		default:
+			return errors.New("usage: `!urgent <n> [on|off]`, e.g. `!urgent 2`")
+		}
+	}
+
+	alert, err := numbered_alert(repo, m.Author.ID, args[0])
+	if err != nil {
+		return err
+	}
+
+	err = repo.Queries.SetAlertUrgent(repo.Ctx, users.SetAlertUrgentParams{
+		AlertID: alert.AlertID,
+		Urgent:  urgent,
+	})
+	if err != nil {
+		fmt.Println("failed to update alert:", err)
+		return errors.New("failed to update alert, please contact dev or try again later")
+	}
+	if urgent {
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("`%s` is urgent and will notify you during quiet hours.", alert.Keyword))
+	} else {
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("`%s` is no longer urgent.", alert.Keyword))
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mechfeed/notifications"
+	"mechfeed/users"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// How long a code of !telegram can be used for
+const TELEGRAM_CODE_TTL = time.Minute * 15
+
+// Destination a linked Telegram chat is saved as
+const TELEGRAM_DESTINATION = "telegram"
+
+// How long to wait for updates in one request, and after a failed one
+const (
+	TELEGRAM_POLL_TIMEOUT = time.Second * 30
+	TELEGRAM_RETRY_DELAY  = time.Second * 5
+)
+
+// Without 0, O, 1 and I which are easily confused
+const LINK_CODE_ALPHABET = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+const LINK_CODE_LENGTH = 8
+
+// Username of the Telegram bot, known once RunTelegram has started
+var telegram_bot struct {
+	mu   sync.Mutex
+	name string
+}
+
+func new_link_code() (string, error) {
+	b := make([]byte, LINK_CODE_LENGTH)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = LINK_CODE_ALPHABET[int(b[i])%len(LINK_CODE_ALPHABET)]
+	}
+	return string(b), nil
+}
+
+// Finds a link code in a message to the Telegram bot, either the code alone
+// or "/start <code>" as sent by the bot's t.me link
+func parse_link_code(text string) (string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 2 && fields[0] == "/start" {
+		fields = fields[1:]
+	}
+	if len(fields) != 1 {
+		return "", false
+	}
+	code := strings.ToUpper(fields[0])
+	if len(code) != LINK_CODE_LENGTH || strings.Trim(code, LINK_CODE_ALPHABET) != "" {
+		return "", false
+	}
+	return code, true
+}
+
+// Alert ID of the data of a mute button, see notifications.CreateTelegramNotification
+func parse_mute_data(data string) (int32, bool) {
+	id, found := strings.CutPrefix(data, "mute:")
+	if !found {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(id, 10, 32)
+	return int32(n), err == nil && n > 0
+}
+
+func handleTelegram(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	if notifications.DefaultTelegramClient.Token == "" {
+		return notifications.ErrTelegramNotConfigured
+	}
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to link Telegram, please contact dev or try again later")
+	}
+	code, err := new_link_code()
+	if err == nil {
+		err = repo.Queries.CreateTelegramLinkCode(repo.Ctx, users.CreateTelegramLinkCodeParams{
+			ID:         m.Author.ID,
+			Code:       code,
+			TtlSeconds: TELEGRAM_CODE_TTL.Seconds(),
+		})
+	}
+	if err != nil {
+		fmt.Println("failed to create Telegram link code:", err)
+		return errors.New("failed to link Telegram, please contact dev or try again later")
+	}
+
+	telegram_bot.mu.Lock()
+	name := telegram_bot.name
+	telegram_bot.mu.Unlock()
+	bot := "the Mechfeed bot"
+	link := ""
+	if name != "" {
+		bot = "@" + name
+		link = fmt.Sprintf("\nOr open https://t.me/%s?start=%s and press Start.", name, code)
+	}
+	SendTextDM(s, m.Author.ID, fmt.Sprintf(
+		"To get notifications on Telegram, send `/start %s` to %s within %d minutes from the chat to notify.%s\nThe chat is added as the `%s` destination.",
+		code, bot, int(TELEGRAM_CODE_TTL.Minutes()), link, TELEGRAM_DESTINATION,
+	))
+	return nil
+}
+
+// RunTelegram answers the Telegram bot's updates until ctx is cancelled: the
+// codes of !telegram and the mute buttons of notifications.
+func RunTelegram(ctx context.Context) {
+	client := notifications.DefaultTelegramClient
+	if client.Token == "" {
+		return
+	}
+	if me, err := client.GetMe(ctx); err != nil {
+		fmt.Println("failed to get Telegram bot:", err)
+	} else {
+		telegram_bot.mu.Lock()
+		telegram_bot.name = me.Username
+		telegram_bot.mu.Unlock()
+	}
+
+	var offset int64
+	for ctx.Err() == nil {
+		updates, err := client.GetUpdates(ctx, offset, TELEGRAM_POLL_TIMEOUT)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Println("failed to get Telegram updates:", err)
+			}
+			select {
+			case <-time.After(TELEGRAM_RETRY_DELAY):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		for _, u := range updates {
+			offset = u.UpdateID + 1
+			handle_telegram_update(ctx, client, u)
+		}
+	}
+}
+
+func handle_telegram_update(ctx context.Context, client *notifications.TelegramClient, u notifications.TelegramUpdate) {
+	var reply string
+	switch {
+	case u.Message != nil:
+		chat := strconv.FormatInt(u.Message.Chat.ID, 10)
+		code, ok := parse_link_code(u.Message.Text)
+		if ok {
+			reply = link_telegram(chat, code)
+		} else {
+			reply = "Hi! Use !telegram with the Mechfeed bot on Discord to get a code, then send it here to get your notifications in this chat."
+		}
+		if err := client.SendText(ctx, chat, reply); err != nil {
+			fmt.Println("failed to reply on Telegram:", err)
+		}
+
+	case u.CallbackQuery != nil && u.CallbackQuery.Message != nil:
+		chat := strconv.FormatInt(u.CallbackQuery.Message.Chat.ID, 10)
+		if alert_id, ok := parse_mute_data(u.CallbackQuery.Data); ok {
+			reply = mute_telegram(chat, alert_id)
+		}
+		if err := client.AnswerCallback(ctx, u.CallbackQuery.ID, reply); err != nil {
+			fmt.Println("failed to answer Telegram button:", err)
+		}
+	}
+}
+
+// Saves chat as a destination of the user the code was given to
+func link_telegram(chat, code string) string {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return "Failed to link this chat, please try again later."
+	}
+	user_id, err := repo.Queries.TakeTelegramLinkCode(repo.Ctx, code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "That code is wrong or has expired, get a new one with !telegram on Discord."
+	}
+	if err == nil {
+		_, err = repo.Queries.AddDestination(repo.Ctx, users.AddDestinationParams{
+			ID:      user_id,
+			Name:    TELEGRAM_DESTINATION,
+			Kind:    users.DestinationTelegram,
+			Target:  chat,
+			Enabled: true,
+		})
+	}
+	if err != nil {
+		fmt.Println("failed to link Telegram chat:", err)
+		return "Failed to link this chat, please try again later."
+	}
+	fmt.Println("Linked Telegram chat for user", user_id)
+	return "Linked! Mechfeed notifications will be sent to this chat. Use !destination disable telegram on Discord to pause them."
+}
+
+// Pauses an alert from its notification, if chat is linked to the alert's user
+func mute_telegram(chat string, alert_id int32) string {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return "Failed to mute alert, please try again later."
+	}
+	alert, err := repo.Queries.GetAlert(repo.Ctx, alert_id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "This alert was deleted."
+	}
+	if err != nil {
+		fmt.Println("failed to query DB for alert:", err)
+		return "Failed to mute alert, please try again later."
+	}
+	linked, err := repo.Queries.GetDestinationsByTarget(repo.Ctx, users.GetDestinationsByTargetParams{
+		Kind:   users.DestinationTelegram,
+		Target: chat,
+	})
+	if err != nil {
+		fmt.Println("failed to query DB for destinations:", err)
+		return "Failed to mute alert, please try again later."
+	}
+	owned := false
+	for _, d := range linked {
+		owned = owned || d.ID == alert.ID
+	}
+	if !owned {
+		return "This chat isn't linked to the alert's owner."
+	}
+	err = repo.Queries.PauseAlert(repo.Ctx, users.PauseAlertParams{
+		DurationSeconds: MUTE_DURATION.Seconds(),
+		AlertID:         alert.AlertID,
+	})
+	if err != nil {
+		fmt.Println("failed to pause alert:", err)
+		return "Failed to mute alert, please try again later."
+	}
+	return fmt.Sprintf("Muted %s for %d hours.", alert.Keyword, int(MUTE_DURATION.Hours()))
+}
@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseLinkCode(t *testing.T) {
+	cases := []struct {
+		text string
+		code string
+		ok   bool
+	}{
+		{"/start ABCD2345", "ABCD2345", true},
+		{"abcd2345", "ABCD2345", true},
+		{"  ABCD2345\n", "ABCD2345", true},
+		{"/start", "", false},
+		{"ABCD1234", "", false}, // 1 isn't in the alphabet
+		{"ABCD23456", "", false},
+		{"hello there", "", false},
+	}
+	for _, c := range cases {
+		code, ok := parse_link_code(c.text)
+		if code != c.code || ok != c.ok {
+			t.Errorf("%q: got %q %t", c.text, code, ok)
+		}
+	}
+}
+
+func TestNewLinkCode(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := new_link_code()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := parse_link_code(code); !ok {
+			t.Errorf("generated unparseable code %q", code)
+		}
+		if strings.ContainsAny(code, "01IO") {
+			t.Errorf("generated ambiguous code %q", code)
+		}
+		seen[code] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("got %d distinct codes in 100", len(seen))
+	}
+}
+
+func TestParseMuteData(t *testing.T) {
+	if id, ok := parse_mute_data("mute:7"); !ok || id != 7 {
+		t.Errorf("got %d %t", id, ok)
+	}
+	for _, data := range []string{"mute:", "mute:-1", "mute:abc", "delete:7", "mute:99999999999"} {
+		if _, ok := parse_mute_data(data); ok {
+			t.Errorf("parsed %q", data)
+		}
+	}
+}
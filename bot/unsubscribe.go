@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"mechfeed/notifications"
+	"mechfeed/users"
+	"net/http"
+)
+
+const UNSUBSCRIBE_FAILED = "Failed to unsubscribe, please try again later."
+
+var unsubscribe_template = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>Unsubscribe - mechfeed</title>
+</head>
+<body style="max-width:600px;margin:32px auto;padding:0 16px;font-family:Helvetica,Arial,sans-serif;color:#2e3338">
+<h2>mechfeed</h2>
+<p>{{.Message}}</p>
+{{- if .Confirm}}
+<form method="post">
+<input type="hidden" name="List-Unsubscribe" value="One-Click">
+<button type="submit">Unsubscribe</button>
+</form>
+{{- end}}
+</body>
+</html>
+`))
+
+// Unsubscribe serves the links of notifications.UnsubscribeLink. GET asks
+// for confirmation so that link scanners don't unsubscribe anyone, POST
+// unsubscribes as mail clients do for List-Unsubscribe-Post.
+func Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	alert_id, destination_id, err := notifications.ParseUnsubscribeLink(r.URL.Query())
+	if err != nil {
+		unsubscribe_page(w, http.StatusBadRequest, "This unsubscribe link is invalid.", false)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		unsubscribe_page(w, http.StatusOK, "Stop emailing notifications of this alert?", true)
+	case http.MethodPost:
+		status, message := unsubscribe(alert_id, destination_id)
+		unsubscribe_page(w, status, message, false)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func unsubscribe_page(w http.ResponseWriter, status int, message string, confirm bool) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	unsubscribe_template.Execute(w, struct {
+		Message string
+		Confirm bool
+	}{message, confirm})
+}
+
+// Stops sending the alert to the email destination, returns the status and
+// message of the page
+func unsubscribe(alert_id, destination_id int32) (int, string) {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return http.StatusInternalServerError, UNSUBSCRIBE_FAILED
+	}
+	alert, err := repo.Queries.GetAlert(repo.Ctx, alert_id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return http.StatusOK, "This alert was deleted, it won't be emailed anymore."
+	}
+	if err != nil {
+		fmt.Println("failed to query DB for alert:", err)
+		return http.StatusInternalServerError, UNSUBSCRIBE_FAILED
+	}
+	destinations, err := repo.Queries.GetUserDestinations(repo.Ctx, alert.ID)
+	if err != nil {
+		fmt.Println("failed to query DB for destinations:", err)
+		return http.StatusInternalServerError, UNSUBSCRIBE_FAILED
+	}
+	var email *users.UserDestination
+	for i := range destinations {
+		if destinations[i].DestinationID == destination_id {
+			email = &destinations[i]
+		}
+	}
+	if email == nil || !alert.RoutesTo(*email) {
+		return http.StatusOK, fmt.Sprintf("You're already unsubscribed from %s.", alert.Keyword)
+	}
+
+	routes, sent := unsubscribe_routes(alert, destinations, *email)
+	if len(routes) > 0 {
+		err = repo.Queries.SetAlertDestinations(repo.Ctx, users.SetAlertDestinationsParams{
+			AlertID:      alert.AlertID,
+			Destinations: routes,
+		})
+	}
+	// Alerts without destinations are sent to all of them, so alerts only
+	// emailed are paused instead
+	if err == nil && !sent {
+		err = repo.Queries.PauseAlertIndefinitely(repo.Ctx, alert.AlertID)
+	}
+	if err != nil {
+		fmt.Println("failed to unsubscribe from alert:", err)
+		return http.StatusInternalServerError, UNSUBSCRIBE_FAILED
+	}
+	fmt.Println("Unsubscribed", email.Name, "from alert", alert.AlertID, "of user", alert.ID)
+	if !sent {
+		return http.StatusOK, fmt.Sprintf("Unsubscribed, %s is paused since it isn't sent anywhere else. Use !resume on Discord to get it back.", alert.Keyword)
+	}
+	return http.StatusOK, fmt.Sprintf("Unsubscribed, %s won't be emailed to %s anymore.", alert.Keyword, email.Target)
+}
+
+// The destinations alert is routed to without d, and whether any of them is
+// enabled
+func unsubscribe_routes(alert users.UserAlert, destinations []users.UserDestination, d users.UserDestination) ([]int32, bool) {
+	var routes []int32
+	sent := false
+	for _, other := range destinations {
+		if other.DestinationID == d.DestinationID || !alert.RoutesTo(other) {
+			continue
+		}
+		routes = append(routes, other.DestinationID)
+		sent = sent || other.Enabled
+	}
+	return routes, sent
+}
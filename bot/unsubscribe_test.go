@@ -0,0 +1,57 @@
+package bot
+
+import (
+	"mechfeed/notifications"
+	"mechfeed/users"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUnsubscribeRoutes(t *testing.T) {
+	dm := users.UserDestination{DestinationID: 1, Kind: users.DestinationDM, Enabled: true}
+	email := users.UserDestination{DestinationID: 2, Kind: users.DestinationEmail, Enabled: true}
+	ntfy := users.UserDestination{DestinationID: 3, Kind: users.DestinationNtfy}
+	destinations := []users.UserDestination{dm, email, ntfy}
+
+	cases := []struct {
+		routes []int32
+		expect []int32
+		sent   bool
+	}{
+		// Alerts sent everywhere keep every other destination
+		{nil, []int32{1, 3}, true},
+		{[]int32{1, 2}, []int32{1}, true},
+		// Only disabled destinations are left
+		{[]int32{2, 3}, []int32{3}, false},
+		{[]int32{2}, nil, false},
+	}
+	for _, c := range cases {
+		alert := users.UserAlert{AlertID: 7, Destinations: c.routes}
+		routes, sent := unsubscribe_routes(alert, destinations, email)
+		if !reflect.DeepEqual(routes, c.expect) || sent != c.sent {
+			t.Errorf("%v: got %v %t expect %v %t", c.routes, routes, sent, c.expect, c.sent)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	old := notifications.Email
+	notifications.Email = notifications.EmailConfig{UnsubscribeURL: "https://mechfeed.example/unsubscribe", UnsubscribeSecret: []byte("secret")}
+	t.Cleanup(func() { notifications.Email = old })
+
+	link := notifications.UnsubscribeLink(7, 2)
+	w := httptest.NewRecorder()
+	Unsubscribe(w, httptest.NewRequest(http.MethodGet, link, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<form method="post">`) {
+		t.Errorf("got %d\n%s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	Unsubscribe(w, httptest.NewRequest(http.MethodPost, strings.Replace(link, "alert=7", "alert=8", 1), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %d for a forged link", w.Code)
+	}
+}
@@ -0,0 +1,72 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"mechfeed/notifications"
+	"mechfeed/users"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const WEBHOOK_USAGE = "usage: `!webhook set <url>`, `!webhook test` or `!webhook remove`"
+
+// Destination managed by !webhook
+const WEBHOOK_DESTINATION = "webhook"
+
+// Shortcut for the Discord or Slack webhook destination called
+// WEBHOOK_DESTINATION
+func handleWebhook(s *discordgo.Session, m *discordgo.MessageCreate, args []string) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		fmt.Println("failed to get DB connection.")
+		return errors.New("failed to update webhook, please contact dev or try again later")
+	}
+
+	if len(args) == 0 {
+		d, err := named_destination(repo, m.Author.ID, WEBHOOK_DESTINATION)
+		if err != nil {
+			SendTextDM(s, m.Author.ID, "You have no webhook set, add one with `!webhook set <url>`.")
+			return nil
+		}
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("Notifications are also posted to `%s`.", destination_target(d)))
+		return nil
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "set":
+		if len(args) != 2 {
+			return errors.New(WEBHOOK_USAGE)
+		}
+		// Discord wraps links in <> to suppress embeds
+		url := strings.TrimSuffix(strings.TrimPrefix(args[1], "<"), ">")
+		if err := add_destination(s, repo, m.Author.ID, WEBHOOK_DESTINATION, WEBHOOK_KIND, url); err != nil {
+			return err
+		}
+		target := notifications.RedactURL(url)
+		fmt.Println(m.Author.Username, "set webhook", target)
+		SendTextDM(s, m.Author.ID, fmt.Sprintf("Webhook set, a test message was posted to `%s`. You can delete your message with the URL now.", target))
+
+	case "test":
+		d, err := named_destination(repo, m.Author.ID, WEBHOOK_DESTINATION)
+		if err != nil {
+			return errors.New("you have no webhook set, use `!webhook set <url>`")
+		}
+		if err := test_destination(s, m.Author.ID, d.Kind, d.Target); err != nil {
+			return err
+		}
+		SendTextDM(s, m.Author.ID, "Test message posted to your webhook.")
+
+	case "remove":
+		paused, err := remove_destination(repo, m.Author.ID, WEBHOOK_DESTINATION)
+		if err != nil {
+			return err
+		}
+		SendTextDM(s, m.Author.ID, "Webhook removed."+paused_by_removal(paused))
+
+	default:
+		return errors.New(WEBHOOK_USAGE)
+	}
+	return nil
+}
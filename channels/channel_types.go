@@ -1,28 +1,53 @@
 package channels
 
-type DiscordMessage struct {
-	ID        string                     `json:"id"`
-	Content   string                     `json:"content"`
-	GuildID   string                     `json:"guild_id"`
-	ChannelID string                     `json:"channel_id"`
-	Timestamp string                     `json:"timestamp"`
-	Author    DiscordMessageAuthor `json:"author"`
-}
+import (
+	"mechfeed/listing"
+	"time"
+)
+
+// Event is a message from any source, normalized for matching and
+// notifications.
+type Event struct {
+	Source    string // Name of the source that emitted the event
+	ID        string // Unique within the source
+	Title     string
+	Body      string
+	Author    Author
+	URL       string   // Link to the original message
+	Images    []string // Direct image links, the first is used as thumbnail
+	Gallery   string   // Link to an image album, e.g. Imgur
+	Timestamp time.Time
 
-type DiscordMessageAuthor struct {
-	Username      string `json:"username"`
-	GlobalName    string `json:"global_name"`
-	Discriminator string `json:"discriminator"`
-	ID            string `json:"id"`
+	// Where the event was posted
+	Server   string // e.g. Discord server name or subreddit
+	Channel  string // e.g. Discord channel name
+	Category string // e.g. post flair
+	Listing  listing.Listing
+
+	// Other places the same listing was posted, see pipeline.Collapser
+	Crossposts []Sighting
 }
 
-type RedditMessage struct {
-	ID        string
-	Title     string
+// Sighting is a place a listing was posted.
+type Sighting struct {
+	Source    string
+	Server    string
+	Channel   string
 	URL       string
-	Author    string
-	Category  string
-	Imgur     string
-	Thumbnail string
-	Content   string
-}
\ No newline at end of file
+	Timestamp time.Time
+}
+
+type Author struct {
+	ID          string // Stable identifier, e.g. Discord user ID or Reddit username
+	Username    string
+	DisplayName string
+	ContactURL  string // Link to message the author directly
+}
+
+// Name returns the author's display name, falling back to the username.
+func (a Author) Name() string {
+	if a.DisplayName != "" {
+		return a.DisplayName
+	}
+	return a.Username
+}
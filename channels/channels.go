@@ -1,6 +0,0 @@
-package channels
-
-var (
-	DiscordChannel = make(chan DiscordMessage)
-	RedditChannel  = make(chan RedditMessage)
-)
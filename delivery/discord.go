@@ -0,0 +1,101 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mechfeed/bot"
+	"mechfeed/fetch-errors"
+	"mechfeed/notifications"
+	"net/http"
+	"net/textproto"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	KindDM       = "dm"       // Target is a Discord user ID, Payload a bot.DMNotification
+	KindWebhook  = "webhook"  // Target is a Discord webhook URL, Payload a notifications.DiscordNoti, or a discordgo.WebhookParams for digests
+	KindDigest   = "digest"   // Target is a Discord user ID, Payload a list of discordgo.MessageEmbed
+	KindSlack    = "slack"    // Target is a Slack webhook URL, Payload a notifications.SlackNoti
+	KindJSON     = "json"     // Target is any URL, Payload a notifications.JSONNoti
+	KindNtfy     = "ntfy"     // Target is an ntfy server URL, Payload a notifications.NtfyNoti
+	KindEmail    = "email"    // Target is an email address, Payload a notifications.EmailNoti
+	KindTelegram = "telegram" // Target is a Telegram chat ID, Payload a notifications.TelegramNoti
+)
+
+// DM sends a notification to a user through the mechfeed bot.
+func DM(ctx context.Context, d Delivery) error {
+	var n bot.DMNotification
+	if err := json.Unmarshal(d.Payload, &n); err != nil {
+		return Permanent(err)
+	}
+	if n.Embed == nil {
+		// Queued as a bare embed, before notifications had buttons
+		n.Embed = &discordgo.MessageEmbed{}
+		if err := json.Unmarshal(d.Payload, n.Embed); err != nil {
+			return Permanent(err)
+		}
+	}
+	return dm_error(bot.IsolatedSendNotificationDM(d.Target, n))
+}
+
+// Digest sends one message of a digest to a user through the mechfeed bot.
+func Digest(ctx context.Context, d Delivery) error {
+	var embeds []*discordgo.MessageEmbed
+	if err := json.Unmarshal(d.Payload, &embeds); err != nil {
+		return Permanent(err)
+	}
+	return dm_error(bot.IsolatedSendEmbedsDM(d.Target, embeds))
+}
+
+func dm_error(err error) error {
+	var rest *discordgo.RESTError
+	if errors.As(err, &rest) && rest.Response != nil && is_permanent(rest.Response.StatusCode) {
+		// No mutual server or DMs disabled
+		return Permanent(err)
+	}
+	return err
+}
+
+// Webhook posts the payload to a webhook, waiting out its rate limit if
+// needed. Besides Discord it is used for Slack, JSON and ntfy deliveries,
+// which go through their own client.
+func Webhook(ctx context.Context, d Delivery) error {
+	client := notifications.DefaultWebhookClient
+	if d.Kind != KindWebhook {
+		client = notifications.DefaultHookClient
+	}
+	err := client.Send(ctx, d.Target, d.Payload)
+	var fetch_err fetcherrors.FetchError
+	if errors.As(err, &fetch_err) && is_permanent(fetch_err.Code) {
+		// Deleted webhook or rejected payload
+		return Permanent(err)
+	}
+	if errors.Is(err, notifications.ErrInvalidJSONURL) {
+		// Resolves to a private address
+		return Permanent(err)
+	}
+	return err
+}
+
+// Email sends the payload through SMTP.
+func Email(ctx context.Context, d Delivery) error {
+	var n notifications.EmailNoti
+	if err := json.Unmarshal(d.Payload, &n); err != nil {
+		return Permanent(err)
+	}
+	err := notifications.SendEmail(ctx, d.Target, n)
+	var smtp_err *textproto.Error
+	if errors.Is(err, notifications.ErrEmailNotConfigured) || (errors.As(err, &smtp_err) && smtp_err.Code >= 500) {
+		// Rejected recipient or message
+		return Permanent(err)
+	}
+	return err
+}
+
+// Client errors won't succeed on retry, except for rate limiting, and neither
+// will redirects since they aren't followed
+func is_permanent(status int) bool {
+	return status >= 300 && status < 500 && status != http.StatusTooManyRequests && status != http.StatusRequestTimeout
+}
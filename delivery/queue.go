@@ -0,0 +1,229 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Notification is a message to enqueue for delivery.
+type Notification struct {
+	UserID  string // Empty for notifications not sent on behalf of a user
+	AlertID int32  // Zero if not triggered by an alert
+	Kind    string // Selects the Deliverer, e.g. KindDM
+	Target  string // Where to deliver, e.g. a user ID or webhook URL
+	Payload interface{}
+
+	HistoryID int64 // notification_history row updated with the outcome, if any
+}
+
+// Delivery is a notification claimed from the queue.
+type Delivery struct {
+	ID       int64
+	Kind     string
+	Target   string
+	Payload  json.RawMessage
+	Attempts int // Including the current one
+}
+
+// Deliverer sends a delivery of one kind. Errors are retried unless wrapped
+// with Permanent.
+type Deliverer func(ctx context.Context, d Delivery) error
+
+// Store persists queued notifications, see DBStore.
+type Store interface {
+	Enqueue(ctx context.Context, n Notification, payload []byte) error
+	// Claim returns up to max due deliveries and hides them from other
+	// claims for lease, so that deliveries of a crashed worker are retried.
+	Claim(ctx context.Context, max int, lease time.Duration) ([]Delivery, error)
+	Delivered(ctx context.Context, id int64) error
+	Retry(ctx context.Context, id int64, delay time.Duration, reason string) error
+	DeadLetter(ctx context.Context, id int64, reason string) error
+	// Prune deletes deliveries that succeeded longer than age ago.
+	Prune(ctx context.Context, age time.Duration) error
+}
+
+// PermanentError is a delivery failure that retrying won't fix.
+type PermanentError struct {
+	Err error
+}
+
+func (e PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as not worth retrying.
+func Permanent(err error) error {
+	return PermanentError{Err: err}
+}
+
+// Queue delivers notifications from a Store with a pool of workers, retrying
+// failures with exponential backoff and dead-lettering them after MaxAttempts.
+type Queue struct {
+	Store       Store
+	Workers     int
+	MaxAttempts int
+	BaseDelay   time.Duration // Delay before the first retry, doubled on every attempt
+	MaxDelay    time.Duration
+	Lease       time.Duration // How long a claimed delivery may take, see DELIVER_SHARE
+	Poll        time.Duration // How often to check for due retries
+	Retention   time.Duration // How long delivered notifications are kept
+
+	deliverers map[string]Deliverer
+	wake       chan struct{}
+}
+
+// Share of the lease a deliverer may take, leaving the rest to record the
+// outcome before the delivery can be claimed again and sent twice
+const DELIVER_SHARE = 0.75
+
+func NewQueue(store Store) *Queue {
+	return &Queue{
+		Store:       store,
+		Workers:     4,
+		MaxAttempts: 8,
+		BaseDelay:   time.Second * 5,
+		MaxDelay:    time.Hour,
+		Lease:       time.Minute,
+		Poll:        time.Second,
+		Retention:   time.Hour * 24 * 7,
+		deliverers:  make(map[string]Deliverer),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the deliverer for notifications of the given kind. It
+// must be called before Run.
+func (q *Queue) Handle(kind string, fn Deliverer) {
+	q.deliverers[kind] = fn
+}
+
+// Enqueue stores n for delivery.
+func (q *Queue) Enqueue(ctx context.Context, n Notification) error {
+	if err := q.EnqueueIn(ctx, q.Store, n); err != nil {
+		return err
+	}
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// EnqueueIn stores n in store rather than the queue's, such as a DBStore in
+// a transaction. Workers find it on their next poll once committed.
+func (q *Queue) EnqueueIn(ctx context.Context, store Store, n Notification) error {
+	payload, err := json.Marshal(n.Payload)
+	if err != nil {
+		return err
+	}
+	return store.Enqueue(ctx, n, payload)
+}
+
+// Run delivers notifications until ctx is cancelled. Deliveries in progress
+// are allowed to finish; pending ones stay in the store for the next run.
+func (q *Queue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		q.prune(ctx)
+	}()
+	for i := 0; i < q.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := q.Store.Claim(ctx, 1, q.Lease)
+		if err != nil && ctx.Err() == nil {
+			log.Println("failed to claim notifications:", err)
+		}
+		if len(deliveries) == 0 {
+			select {
+			case <-q.wake:
+			case <-time.After(q.Poll):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		for _, d := range deliveries {
+			// Finish the delivery even if shutting down
+			dctx, cancel := context.WithTimeout(context.Background(), q.Lease)
+			q.deliver(dctx, d)
+			cancel()
+		}
+	}
+}
+
+func (q *Queue) prune(ctx context.Context) {
+	for {
+		if err := q.Store.Prune(ctx, q.Retention); err != nil && ctx.Err() == nil {
+			log.Println("failed to prune delivered notifications:", err)
+		}
+		select {
+		case <-time.After(time.Hour):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deliver attempts d once and records the outcome.
+func (q *Queue) deliver(ctx context.Context, d Delivery) {
+	fn, ok := q.deliverers[d.Kind]
+	if !ok {
+		q.record(q.Store.DeadLetter(ctx, d.ID, fmt.Sprintf("no deliverer for %q notifications", d.Kind)))
+		return
+	}
+
+	fn_ctx, cancel := context.WithTimeout(ctx, time.Duration(float64(q.Lease)*DELIVER_SHARE))
+	err := fn(fn_ctx, d)
+	cancel()
+	if err == nil {
+		q.record(q.Store.Delivered(ctx, d.ID))
+		return
+	}
+
+	var permanent PermanentError
+	if errors.As(err, &permanent) || d.Attempts >= q.MaxAttempts {
+		log.Printf("dead-lettering %s notification %d after %d attempts: %v", d.Kind, d.ID, d.Attempts, err)
+		q.record(q.Store.DeadLetter(ctx, d.ID, err.Error()))
+		return
+	}
+	delay := q.backoff(d.Attempts)
+	log.Printf("%s notification %d failed (attempt %d), retrying in %v: %v", d.Kind, d.ID, d.Attempts, delay, err)
+	q.record(q.Store.Retry(ctx, d.ID, delay, err.Error()))
+}
+
+func (q *Queue) record(err error) {
+	if err != nil {
+		log.Println("failed to record notification delivery:", err)
+	}
+}
+
+// backoff returns the delay after the given failed attempt.
+func (q *Queue) backoff(attempt int) time.Duration {
+	delay := q.BaseDelay
+	for i := 1; i < attempt && delay < q.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > q.MaxDelay {
+		delay = q.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,208 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory Store that ignores retry delays.
+type memStore struct {
+	mu      sync.Mutex
+	next_id int64
+	rows    map[int64]*memRow
+}
+
+type memRow struct {
+	Delivery
+	status string
+	reason string
+	delay  time.Duration
+}
+
+func newMemStore() *memStore {
+	return &memStore{rows: make(map[int64]*memRow)}
+}
+
+func (s *memStore) Enqueue(ctx context.Context, n Notification, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next_id++
+	s.rows[s.next_id] = &memRow{
+		Delivery: Delivery{ID: s.next_id, Kind: n.Kind, Target: n.Target, Payload: payload},
+		status:   "pending",
+	}
+	return nil
+}
+
+func (s *memStore) Claim(ctx context.Context, max int, lease time.Duration) ([]Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var claimed []Delivery
+	for _, row := range s.rows {
+		if len(claimed) == max {
+			break
+		}
+		if row.status == "pending" {
+			row.status = "claimed"
+			row.Attempts++
+			claimed = append(claimed, row.Delivery)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *memStore) set(id int64, status, reason string, delay time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rows[id].status = status
+	s.rows[id].reason = reason
+	s.rows[id].delay = delay
+	return nil
+}
+
+func (s *memStore) Delivered(ctx context.Context, id int64) error {
+	return s.set(id, "delivered", "", 0)
+}
+
+func (s *memStore) Retry(ctx context.Context, id int64, delay time.Duration, reason string) error {
+	return s.set(id, "pending", reason, delay)
+}
+
+func (s *memStore) DeadLetter(ctx context.Context, id int64, reason string) error {
+	return s.set(id, "dead", reason, 0)
+}
+
+func (s *memStore) Prune(ctx context.Context, age time.Duration) error {
+	return nil
+}
+
+func (s *memStore) row(id int64) memRow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.rows[id]
+}
+
+func TestQueue(t *testing.T) {
+	store := newMemStore()
+	q := NewQueue(store)
+	q.MaxAttempts = 3
+	q.Poll = time.Millisecond
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	q.Handle("test", func(ctx context.Context, d Delivery) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[d.Target]++
+		switch d.Target {
+		case "flaky":
+			if attempts[d.Target] < 2 {
+				return errors.New("timeout")
+			}
+		case "down":
+			return errors.New("connection refused")
+		case "gone":
+			return Permanent(errors.New("unknown webhook"))
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(done)
+	}()
+
+	for _, target := range []string{"ok", "flaky", "down", "gone"} {
+		if err := q.Enqueue(ctx, Notification{Kind: "test", Target: target, Payload: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	q.Enqueue(ctx, Notification{Kind: "unknown", Target: "x"})
+
+	expect := map[int64]struct {
+		status   string
+		attempts int
+	}{
+		1: {"delivered", 1},
+		2: {"delivered", 2},
+		3: {"dead", 3},
+		4: {"dead", 1},
+		5: {"dead", 1},
+	}
+	deadline := time.Now().Add(time.Second)
+	for id, e := range expect {
+		for {
+			row := store.row(id)
+			if row.status == e.status {
+				if row.Attempts != e.attempts {
+					t.Errorf("%d: got %d attempts expect %d", id, row.Attempts, e.attempts)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d: got status %q expect %q", id, row.status, e.status)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if got := store.row(3).reason; got != "connection refused" {
+		t.Errorf("got dead-letter reason %q", got)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after cancel")
+	}
+}
+
+func TestDeliverTimeout(t *testing.T) {
+	store := newMemStore()
+	q := NewQueue(store)
+	var left time.Duration
+	q.Handle("test", func(ctx context.Context, d Delivery) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return Permanent(errors.New("no deadline"))
+		}
+		left = time.Until(deadline)
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), q.Lease)
+	defer cancel()
+	if err := q.Enqueue(ctx, Notification{Kind: "test", Target: "ok", Payload: "ok"}); err != nil {
+		t.Fatal(err)
+	}
+	q.deliver(ctx, store.row(1).Delivery)
+	// Time is left to record the outcome before the lease runs out
+	if left <= 0 || left > time.Duration(float64(q.Lease)*DELIVER_SHARE) {
+		t.Errorf("got %v to deliver with a lease of %v", left, q.Lease)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q := NewQueue(nil)
+	q.BaseDelay = time.Second
+	q.MaxDelay = time.Second * 10
+	expect := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 8, time.Second * 10, time.Second * 10}
+	for i, e := range expect {
+		if got := q.backoff(i + 1); got != e {
+			t.Errorf("attempt %d: got %v expect %v", i+1, got, e)
+		}
+	}
+}
+
+func TestIsPermanent(t *testing.T) {
+	cases := map[int]bool{400: true, 401: true, 404: true, 408: false, 429: false, 500: false, 502: false}
+	for status, expect := range cases {
+		if got := is_permanent(status); got != expect {
+			t.Errorf("%d: got %t expect %t", status, got, expect)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package delivery
+
+import (
+	"context"
+	"database/sql"
+	"mechfeed/users"
+	"time"
+)
+
+// DBStore keeps the queue in the notification_outbox table.
+type DBStore struct {
+	Queries *users.Queries
+}
+
+func (s DBStore) Enqueue(ctx context.Context, n Notification, payload []byte) error {
+	_, err := s.Queries.EnqueueNotification(ctx, users.EnqueueNotificationParams{
+		UserID:    sql.NullString{String: n.UserID, Valid: n.UserID != ""},
+		AlertID:   sql.NullInt32{Int32: n.AlertID, Valid: n.AlertID != 0},
+		Kind:      n.Kind,
+		Target:    n.Target,
+		Payload:   payload,
+		HistoryID: sql.NullInt64{Int64: n.HistoryID, Valid: n.HistoryID != 0},
+	})
+	return err
+}
+
+func (s DBStore) Claim(ctx context.Context, max int, lease time.Duration) ([]Delivery, error) {
+	rows, err := s.Queries.ClaimNotifications(ctx, users.ClaimNotificationsParams{
+		LeaseSeconds: lease.Seconds(),
+		MaxClaimed:   int32(max),
+	})
+	if err != nil {
+		return nil, err
+	}
+	deliveries := make([]Delivery, len(rows))
+	for i, row := range rows {
+		deliveries[i] = Delivery{
+			ID:       row.ID,
+			Kind:     row.Kind,
+			Target:   row.Target,
+			Payload:  row.Payload,
+			Attempts: int(row.Attempts),
+		}
+	}
+	return deliveries, nil
+}
+
+func (s DBStore) Delivered(ctx context.Context, id int64) error {
+	if err := s.Queries.MarkNotificationDelivered(ctx, id); err != nil {
+		return err
+	}
+	return s.history(ctx, id, "delivered")
+}
+
+func (s DBStore) Retry(ctx context.Context, id int64, delay time.Duration, reason string) error {
+	return s.Queries.RetryNotification(ctx, users.RetryNotificationParams{
+		DelaySeconds: delay.Seconds(),
+		LastError:    sql.NullString{String: reason, Valid: true},
+		ID:           id,
+	})
+}
+
+func (s DBStore) DeadLetter(ctx context.Context, id int64, reason string) error {
+	err := s.Queries.DeadLetterNotification(ctx, users.DeadLetterNotificationParams{
+		ID:        id,
+		LastError: sql.NullString{String: reason, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	return s.history(ctx, id, "failed")
+}
+
+// Records the outcome of a delivery in its notification_history row
+func (s DBStore) history(ctx context.Context, id int64, status string) error {
+	return s.Queries.UpdateNotificationHistory(ctx, users.UpdateNotificationHistoryParams{
+		ID:     id,
+		Status: status,
+	})
+}
+
+func (s DBStore) Prune(ctx context.Context, age time.Duration) error {
+	return s.Queries.DeleteDeliveredNotifications(ctx, age.Seconds())
+}
@@ -0,0 +1,24 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mechfeed/fetch-errors"
+	"mechfeed/notifications"
+)
+
+// Telegram sends the payload to a chat through the Telegram bot.
+func Telegram(ctx context.Context, d Delivery) error {
+	var n notifications.TelegramNoti
+	if err := json.Unmarshal(d.Payload, &n); err != nil {
+		return Permanent(err)
+	}
+	err := notifications.DefaultTelegramClient.Send(ctx, d.Target, n)
+	var fetch_err fetcherrors.FetchError
+	if errors.Is(err, notifications.ErrTelegramNotConfigured) || (errors.As(err, &fetch_err) && is_permanent(fetch_err.Code)) {
+		// Bot blocked, chat gone or message rejected
+		return Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,286 @@
+package digest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mechfeed/notifications"
+	"mechfeed/users"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Delivery modes of users.delivery_mode
+const (
+	Instant = "instant"
+	Hourly  = "hourly"
+	Daily   = "daily"
+)
+
+// Discord limits for the embeds of a single message
+const (
+	MAX_EMBEDS            = 10
+	MAX_MESSAGE_CHARS     = 6000
+	MAX_DESCRIPTION_CHARS = 4096
+)
+
+var clock_time = regexp.MustCompile(`^([01]?\d|2[0-3]):([0-5]\d)$`)
+
+// ParseClock parses a 24-hour time such as "9:00" or "21:30" into minutes
+// after midnight.
+func ParseClock(s string) (int, error) {
+	m := clock_time.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("invalid time %q, use 24-hour HH:MM such as 09:00 or 21:30", s)
+	}
+	hours, _ := strconv.Atoi(m[1])
+	minutes, _ := strconv.Atoi(m[2])
+	return hours*60 + minutes, nil
+}
+
+// FormatClock formats minutes after midnight as HH:MM.
+func FormatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// Location loads a user's timezone, falling back to UTC.
+func Location(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Due reports whether a digest should be sent at now, given when the last one
+// was sent. Hourly digests go out at the top of every hour and daily ones at
+// at, in minutes after midnight in loc.
+func Due(mode string, at int, loc *time.Location, last, now time.Time) bool {
+	var scheduled time.Time
+	switch mode {
+	case Hourly:
+		scheduled = now.Truncate(time.Hour)
+	case Daily:
+		scheduled = QuietEnded(at, loc, now)
+	default:
+		return false
+	}
+	return last.Before(scheduled)
+}
+
+// UserDue reports whether user's digest should be sent at now. Digests wait
+// for quiet hours to end. Instant users are sent the notifications held
+// during quiet hours once they end, digest users get them in their digest.
+// Instant users without a digest time, such as those who just switched from
+// a digest mode, are sent what is pending right away.
+func UserDue(user users.User, now time.Time) bool {
+	if InQuietHours(user, now) {
+		return false
+	}
+	last := time.Time{}
+	if user.DigestSent.Valid {
+		last = user.DigestSent.Time
+	}
+	loc := Location(user.Timezone)
+	if user.DeliveryMode != "" && user.DeliveryMode != Instant {
+		return Due(user.DeliveryMode, int(user.DigestAt), loc, last, now)
+	}
+	if !user.DigestSent.Valid {
+		return true
+	}
+	return user.QuietEnd.Valid && last.Before(QuietEnded(int(user.QuietEnd.Int16), loc, now))
+}
+
+// Store is where digest items are kept until sent.
+type Store interface {
+	Pending(ctx context.Context, user string) ([]users.DigestItem, error)
+	// Send calls queue to queue the digest of items, then removes them and
+	// records the digest time. Nothing changes if queue or the store fails,
+	// so the items are kept for the next try.
+	Send(ctx context.Context, user string, items []users.DigestItem, queue func(q *users.Queries) error) error
+}
+
+// Scheduler sends the digests of every user that is due one.
+type Scheduler struct {
+	Store Store
+	Users func() []users.User
+	// Send queues the digest of the items held for one of the user's
+	// destinations, or their DMs when destination is 0, with the queries of
+	// Store.Send
+	Send     func(ctx context.Context, q *users.Queries, user users.User, destination int32, heading string, items []users.DigestItem) error
+	Interval time.Duration // How often users are checked
+}
+
+// Run checks for due digests every Interval until ctx is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		s.Tick(ctx, time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Tick sends the digests due at now.
+func (s *Scheduler) Tick(ctx context.Context, now time.Time) {
+	for _, user := range s.Users() {
+		if !UserDue(user, now) {
+			continue
+		}
+		if err := s.send(ctx, user); err != nil {
+			log.Printf("failed to send %s digest to %s: %v", user.DeliveryMode, user.Username, err)
+		}
+	}
+}
+
+func (s *Scheduler) send(ctx context.Context, user users.User) error {
+	items, err := s.Store.Pending(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	heading := "While you were away"
+	if user.DeliveryMode == Hourly || user.DeliveryMode == Daily {
+		heading = fmt.Sprintf("Your %s digest", user.DeliveryMode)
+	}
+
+	// One digest per destination, in the order of their first item
+	var destinations []int32
+	by_destination := make(map[int32][]users.DigestItem)
+	for _, item := range items {
+		id := item.DestinationID.Int32
+		if _, ok := by_destination[id]; !ok {
+			destinations = append(destinations, id)
+		}
+		by_destination[id] = append(by_destination[id], item)
+	}
+	return s.Store.Send(ctx, user.ID, items, func(q *users.Queries) error {
+		for _, id := range destinations {
+			if err := s.Send(ctx, q, user, id, heading, by_destination[id]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Render lays out digest items as messages of embeds, one embed per alert,
+// split to stay within Discord's embed limits.
+func Render(heading string, items []users.DigestItem) [][]*discordgo.MessageEmbed {
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DigestItemID < items[j].DigestItemID })
+
+	// Alerts in order of their first match
+	var keywords []string
+	by_keyword := make(map[string][]users.DigestItem)
+	for _, item := range items {
+		if _, ok := by_keyword[item.Keyword]; !ok {
+			keywords = append(keywords, item.Keyword)
+		}
+		by_keyword[item.Keyword] = append(by_keyword[item.Keyword], item)
+	}
+
+	var embeds []*discordgo.MessageEmbed
+	for _, keyword := range keywords {
+		title := notifications.Truncate(fmt.Sprintf("`%s` (%d)", keyword, len(by_keyword[keyword])), 256)
+		var sb strings.Builder
+		for _, item := range by_keyword[keyword] {
+			line := digest_line(item)
+			// Continue in a new embed when the description is full
+			if sb.Len()+len(line) > MAX_DESCRIPTION_CHARS/2 && sb.Len() > 0 {
+				embeds = append(embeds, digest_embed(title, sb.String()))
+				title = notifications.Truncate(fmt.Sprintf("`%s` (continued)", keyword), 256)
+				sb.Reset()
+			}
+			sb.WriteString(line)
+		}
+		embeds = append(embeds, digest_embed(title, sb.String()))
+	}
+
+	header := digest_header(heading, len(items))
+
+	var messages [][]*discordgo.MessageEmbed
+	var message []*discordgo.MessageEmbed
+	chars := len(header)
+	for _, embed := range embeds {
+		size := len(embed.Title) + len(embed.Description)
+		if len(message) == MAX_EMBEDS || (chars+size > MAX_MESSAGE_CHARS && len(message) > 0) {
+			messages = append(messages, message)
+			message, chars = nil, 0
+		}
+		message = append(message, embed)
+		chars += size
+	}
+	if len(message) > 0 {
+		messages = append(messages, message)
+	}
+
+	// Header on the first embed
+	if len(messages) > 0 {
+		messages[0][0].Author = &discordgo.MessageEmbedAuthor{Name: header}
+	}
+	return messages
+}
+
+// Text lays out digest items for destinations without embeds, in the order
+// they were matched.
+func Text(heading string, items []users.DigestItem, loc *time.Location) notifications.Digest {
+	sort.SliceStable(items, func(i, j int) bool { return items[i].DigestItemID < items[j].DigestItemID })
+	d := notifications.Digest{Heading: digest_header(heading, len(items)), Location: loc}
+	for _, item := range items {
+		d.Matches = append(d.Matches, notifications.DigestMatch{
+			Alert:   item.Keyword,
+			Title:   item.Title,
+			URL:     item.Url,
+			Place:   item.Place,
+			Price:   item.Price,
+			Matched: item.Created,
+		})
+	}
+	return d
+}
+
+// e.g. "Your daily digest: 3 matches"
+func digest_header(heading string, n int) string {
+	matches := "matches"
+	if n == 1 {
+		matches = "match"
+	}
+	return fmt.Sprintf("%s: %d %s", heading, n, matches)
+}
+
+func digest_embed(title, description string) *discordgo.MessageEmbed {
+	return &discordgo.MessageEmbed{
+		Color:       0xe671dc,
+		Title:       title,
+		Description: description,
+	}
+}
+
+// One line per match, e.g. "• [[US-CA][H] GMK Olivia](url) · r/mechmarket · $150 · <t:..:R>"
+func digest_line(item users.DigestItem) string {
+	title := notifications.Truncate(strings.ReplaceAll(item.Title, "\n", " "), 80)
+	if title == "" {
+		title = "Message"
+	}
+	title = strings.NewReplacer("[", "(", "]", ")").Replace(title)
+	parts := []string{title}
+	if item.Url != "" {
+		parts[0] = "[" + title + "](" + item.Url + ")"
+	}
+	if item.Place != "" {
+		parts = append(parts, item.Place)
+	}
+	if item.Price != "" {
+		parts = append(parts, item.Price)
+	}
+	parts = append(parts, fmt.Sprintf("<t:%d:R>", item.Created.Unix()))
+	return "• " + strings.Join(parts, " · ") + "\n"
+}
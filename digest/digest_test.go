@@ -0,0 +1,290 @@
+package digest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mechfeed/users"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClock(t *testing.T) {
+	cases := map[string]int{"00:00": 0, "9:00": 540, "09:30": 570, "23:59": 1439}
+	for s, expect := range cases {
+		got, err := ParseClock(s)
+		if err != nil || got != expect {
+			t.Errorf("ParseClock(%q) = %d, %v expect %d", s, got, err, expect)
+		}
+	}
+	for _, s := range []string{"24:00", "9", "09:60", "9am", ""} {
+		if _, err := ParseClock(s); err == nil {
+			t.Errorf("ParseClock(%q) expected error", s)
+		}
+	}
+	if got := FormatClock(570); got != "09:30" {
+		t.Errorf("FormatClock(570) = %q", got)
+	}
+}
+
+func TestDue(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 07:30 UTC is 09:30 in Berlin
+	now := time.Date(2024, 6, 1, 7, 30, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		mode string
+		at   int
+		loc  *time.Location
+		last time.Time
+		due  bool
+	}{
+		{"instant", Instant, 0, time.UTC, time.Time{}, false},
+		{"hourly never sent", Hourly, 0, time.UTC, time.Time{}, true},
+		{"hourly sent this hour", Hourly, 0, time.UTC, now.Add(-time.Minute * 20), false},
+		{"hourly sent last hour", Hourly, 0, time.UTC, now.Add(-time.Minute * 40), true},
+		{"daily before time", Daily, 600, berlin, now.Add(-time.Hour * 20), false},
+		{"daily after time", Daily, 540, berlin, now.Add(-time.Hour * 20), true},
+		{"daily already sent", Daily, 540, berlin, now.Add(-time.Minute * 10), false},
+		{"daily in utc", Daily, 540, time.UTC, now.Add(-time.Hour * 20), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := Due(c.mode, c.at, c.loc, c.last, now); got != c.due {
+				t.Errorf("got due %t expect %t", got, c.due)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	var items []users.DigestItem
+	for i := 0; i < 300; i++ {
+		items = append(items, users.DigestItem{
+			DigestItemID: int64(i),
+			Keyword:      fmt.Sprintf("alert %d", i%12),
+			Title:        "[US-CA][H] GMK Olivia++ base kit, opened but never mounted [W] PayPal",
+			Url:          fmt.Sprintf("https://reddit.com/r/mechmarket/comments/%d", i),
+			Place:        "r/mechmarket",
+			Price:        "$150",
+			Created:      time.Now(),
+		})
+	}
+
+	messages := Render("Your daily digest", items)
+	if len(messages) < 2 {
+		t.Fatalf("got %d messages expect the digest to be split", len(messages))
+	}
+	lines := 0
+	for _, message := range messages {
+		if len(message) > MAX_EMBEDS {
+			t.Errorf("got %d embeds in a message", len(message))
+		}
+		chars := 0
+		for _, embed := range message {
+			chars += len(embed.Title) + len(embed.Description)
+			if embed.Author != nil {
+				chars += len(embed.Author.Name)
+			}
+			if len(embed.Description) > MAX_DESCRIPTION_CHARS {
+				t.Errorf("got description of %d chars", len(embed.Description))
+			}
+			lines += strings.Count(embed.Description, "\n")
+		}
+		if chars > MAX_MESSAGE_CHARS {
+			t.Errorf("got %d chars in a message", chars)
+		}
+	}
+	if lines != len(items) {
+		t.Errorf("got %d lines expect %d", lines, len(items))
+	}
+	if header := messages[0][0].Author; header == nil || header.Name != "Your daily digest: 300 matches" {
+		t.Errorf("got header %+v", header)
+	}
+}
+
+func TestQuiet(t *testing.T) {
+	start, end, err := ParseQuietHours("23:00-07:00")
+	if err != nil || start != 1380 || end != 420 {
+		t.Fatalf("got %d-%d, %v", start, end, err)
+	}
+	for _, s := range []string{"23:00", "23:00-23:00", "11pm-7am"} {
+		if _, _, err := ParseQuietHours(s); err == nil {
+			t.Errorf("ParseQuietHours(%q) expected error", s)
+		}
+	}
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		start, end int
+		hour       int // UTC
+		quiet      bool
+	}{
+		{1380, 420, 23, true},
+		{1380, 420, 3, true},
+		{1380, 420, 7, false},
+		{1380, 420, 12, false},
+		{60, 360, 3, true},
+		{60, 360, 0, false},
+	}
+	for _, c := range cases {
+		now := time.Date(2024, 6, 1, c.hour, 0, 0, 0, time.UTC)
+		if got := Quiet(c.start, c.end, time.UTC, now); got != c.quiet {
+			t.Errorf("Quiet(%d, %d) at %02d:00 = %t", c.start, c.end, c.hour, got)
+		}
+	}
+	// 15:00 UTC is midnight in Tokyo
+	if !Quiet(1380, 420, tokyo, time.Date(2024, 6, 1, 15, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected quiet at midnight in Tokyo")
+	}
+}
+
+func TestUserDue(t *testing.T) {
+	now := time.Date(2024, 6, 1, 7, 5, 0, 0, time.UTC)
+	quiet := func(start, end int16) users.User {
+		return users.User{
+			DeliveryMode: Instant,
+			Timezone:     "UTC",
+			DigestSent:   sql.NullTime{Time: now.Add(-time.Hour * 9), Valid: true},
+			QuietStart:   sql.NullInt16{Int16: start, Valid: true},
+			QuietEnd:     sql.NullInt16{Int16: end, Valid: true},
+		}
+	}
+	if !UserDue(quiet(1380, 420), now) {
+		t.Errorf("expected held notifications to be released after quiet hours")
+	}
+	if UserDue(quiet(1380, 480), now) {
+		t.Errorf("expected notifications to be held during quiet hours")
+	}
+	released := quiet(1380, 420)
+	released.DigestSent.Time = now.Add(-time.Minute)
+	if UserDue(released, now) {
+		t.Errorf("expected held notifications to be released once")
+	}
+
+	// Switching from a digest mode clears the digest time
+	switched := quiet(1380, 420)
+	switched.DigestSent.Valid = false
+	if !UserDue(switched, now) {
+		t.Errorf("expected pending digest to be sent after switching to instant")
+	}
+	switched.QuietStart.Valid, switched.QuietEnd.Valid = false, false
+	if !UserDue(switched, now) {
+		t.Errorf("expected pending digest to be sent without quiet hours")
+	}
+
+	hourly := quiet(1380, 480)
+	hourly.DeliveryMode = Hourly
+	if UserDue(hourly, now) {
+		t.Errorf("expected hourly digest to wait for quiet hours to end")
+	}
+	hourly.QuietStart.Valid, hourly.QuietEnd.Valid = false, false
+	if !UserDue(hourly, now) {
+		t.Errorf("expected hourly digest without quiet hours")
+	}
+
+	// Held notifications are in the next daily digest, not a digest of
+	// their own when quiet hours end
+	daily := quiet(1380, 420)
+	daily.DeliveryMode = Daily
+	daily.DigestAt = 540
+	daily.DigestSent.Time = time.Date(2024, 5, 31, 9, 0, 0, 0, time.UTC)
+	if UserDue(daily, now) {
+		t.Errorf("expected daily digest to wait for 09:00 after quiet hours")
+	}
+	if !UserDue(daily, now.Add(time.Hour*2)) {
+		t.Errorf("expected daily digest at 09:00")
+	}
+}
+
+// Store in memory, items are only removed by a successful Send
+type memory_store struct {
+	items map[string][]users.DigestItem
+	sent  map[string]int
+}
+
+func (m *memory_store) Pending(ctx context.Context, user string) ([]users.DigestItem, error) {
+	return m.items[user], nil
+}
+
+func (m *memory_store) Send(ctx context.Context, user string, items []users.DigestItem, queue func(q *users.Queries) error) error {
+	if err := queue(nil); err != nil {
+		return err
+	}
+	m.items[user] = m.items[user][len(items):]
+	m.sent[user]++
+	return nil
+}
+
+func TestSchedulerKeepsItemsOnFailure(t *testing.T) {
+	store := &memory_store{
+		items: map[string][]users.DigestItem{"1": {{DigestItemID: 1, Keyword: "olivia", Title: "GMK Olivia"}}},
+		sent:  make(map[string]int),
+	}
+	fail := true
+	queued := 0
+	s := &Scheduler{
+		Store: store,
+		Users: func() []users.User {
+			return []users.User{{ID: "1", DeliveryMode: Hourly, Timezone: "UTC"}}
+		},
+		Send: func(ctx context.Context, q *users.Queries, user users.User, destination int32, heading string, items []users.DigestItem) error {
+			if fail {
+				return errors.New("outbox is down")
+			}
+			queued++
+			return nil
+		},
+	}
+
+	now := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	s.Tick(context.Background(), now)
+	if len(store.items["1"]) != 1 || store.sent["1"] != 0 {
+		t.Fatalf("got %d items left and %d digests after a failure", len(store.items["1"]), store.sent["1"])
+	}
+
+	fail = false
+	s.Tick(context.Background(), now)
+	if len(store.items["1"]) != 0 || store.sent["1"] != 1 || queued != 1 {
+		t.Errorf("got %d items left, %d digests and %d messages", len(store.items["1"]), store.sent["1"], queued)
+	}
+}
+
+func TestSchedulerSendsDigestPerDestination(t *testing.T) {
+	ntfy := sql.NullInt32{Int32: 7, Valid: true}
+	store := &memory_store{
+		items: map[string][]users.DigestItem{"1": {
+			{DigestItemID: 1, Keyword: "olivia", Title: "GMK Olivia"},
+			{DigestItemID: 2, Keyword: "olivia", Title: "GMK Olivia", DestinationID: ntfy},
+			{DigestItemID: 3, Keyword: "kaze", Title: "Kaze Rhapsody", DestinationID: ntfy},
+		}},
+		sent: make(map[string]int),
+	}
+	queued := make(map[int32]int)
+	s := &Scheduler{
+		Store: store,
+		Users: func() []users.User {
+			return []users.User{{ID: "1", QuietStart: sql.NullInt16{Int16: 23 * 60, Valid: true}, QuietEnd: sql.NullInt16{Int16: 7 * 60, Valid: true}, Timezone: "UTC"}}
+		},
+		Send: func(ctx context.Context, q *users.Queries, user users.User, destination int32, heading string, items []users.DigestItem) error {
+			if heading != "While you were away" {
+				t.Errorf("got heading %q", heading)
+			}
+			queued[destination] += len(items)
+			return nil
+		},
+	}
+
+	s.Tick(context.Background(), time.Date(2024, 6, 1, 7, 1, 0, 0, time.UTC))
+	if len(queued) != 2 || queued[0] != 1 || queued[7] != 2 {
+		t.Errorf("got items queued per destination %v expect DMs 1 and destination 7 2", queued)
+	}
+}
@@ -0,0 +1,59 @@
+package digest
+
+import (
+	"fmt"
+	"mechfeed/users"
+	"strings"
+	"time"
+)
+
+// ParseQuietHours parses a window such as "23:00-07:00" into its start and
+// end in minutes after midnight.
+func ParseQuietHours(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid quiet hours %q, use HH:MM-HH:MM such as 23:00-07:00", s)
+	}
+	start, err := ParseClock(strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := ParseClock(strings.TrimSpace(to))
+	if err != nil {
+		return 0, 0, err
+	}
+	if start == end {
+		return 0, 0, fmt.Errorf("quiet hours %q start and end at the same time", s)
+	}
+	return start, end, nil
+}
+
+// Quiet reports whether now is within the window from start to end, in
+// minutes after midnight in loc. Windows past midnight such as 23:00-07:00
+// wrap around.
+func Quiet(start, end int, loc *time.Location, now time.Time) bool {
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
+
+// QuietEnded returns the last time the window ended at or before now.
+func QuietEnded(end int, loc *time.Location, now time.Time) time.Time {
+	local := now.In(loc)
+	ended := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, loc)
+	if ended.After(now) {
+		ended = ended.AddDate(0, 0, -1)
+	}
+	return ended
+}
+
+// InQuietHours reports whether notifications to user are held at now.
+func InQuietHours(user users.User, now time.Time) bool {
+	if !user.QuietStart.Valid || !user.QuietEnd.Valid {
+		return false
+	}
+	return Quiet(int(user.QuietStart.Int16), int(user.QuietEnd.Int16), Location(user.Timezone), now)
+}
@@ -0,0 +1,57 @@
+package digest
+
+import (
+	"context"
+	"database/sql"
+	"mechfeed/users"
+)
+
+// DBStore keeps digest items in the digest_items table.
+type DBStore struct {
+	DB      *sql.DB // Digests are sent in transactions on DB
+	Queries *users.Queries
+}
+
+func (s DBStore) Add(ctx context.Context, item users.AddDigestItemParams) error {
+	return s.Queries.AddDigestItem(ctx, item)
+}
+
+func (s DBStore) Pending(ctx context.Context, user string) ([]users.DigestItem, error) {
+	return s.Queries.GetDigestItems(ctx, user)
+}
+
+// Send queues the digest, deletes its items, marks their history as
+// delivered and records the digest time in one transaction.
+func (s DBStore) Send(ctx context.Context, user string, items []users.DigestItem, queue func(q *users.Queries) error) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	q := s.Queries.WithTx(tx)
+
+	if err := queue(q); err != nil {
+		return err
+	}
+	var item_ids, history_ids []int64
+	for _, item := range items {
+		item_ids = append(item_ids, item.DigestItemID)
+		if item.HistoryID.Valid {
+			history_ids = append(history_ids, item.HistoryID.Int64)
+		}
+	}
+	if len(item_ids) > 0 {
+		if err := q.DeleteDigestItems(ctx, item_ids); err != nil {
+			return err
+		}
+	}
+	if len(history_ids) > 0 {
+		if err := q.MarkHistoryDelivered(ctx, history_ids); err != nil {
+			return err
+		}
+	}
+	if err := q.MarkDigestSent(ctx, user); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -1,4 +1,4 @@
-package main
+package discordportal
 
 // Discord Config
 
@@ -61,4 +61,19 @@ var ServerList = []Server{
 	// 	Name:     "Mechfeed",
 	// 	Channels: []Channel{{"mechfeed", "968791988465983518"}},
 	// },
-}
\ No newline at end of file
+}
+
+// Monitored channels indexed by channel ID
+var (
+	monitored_channels = make(map[string]Channel)
+	monitored_servers  = make(map[string]Server)
+)
+
+func init() {
+	for _, server := range ServerList {
+		for _, channel := range server.Channels {
+			monitored_channels[channel.ID] = channel
+			monitored_servers[channel.ID] = server
+		}
+	}
+}
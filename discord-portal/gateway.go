@@ -1,21 +1,31 @@
 package discordportal
 
-import "github.com/gorilla/websocket"
+import (
+	"context"
+	"mechfeed/channels"
+	"sync"
+	"sync/atomic"
 
-const (
-	GATEWAY_URL          = "wss://gateway.discord.gg/?v=9&encoding=json"
-	GUILD_MESSAGE_INTENT = 33280 // GUILD_MESSAGES + MESSAGE_CONTENT
+	"github.com/gorilla/websocket"
 )
 
+const GUILD_MESSAGE_INTENT = 33280 // GUILD_MESSAGES + MESSAGE_CONTENT
+
+// Replaced by tests with a local stand-in
+var GATEWAY_URL = "wss://gateway.discord.gg/?v=9&encoding=json"
+
 type GatewayConnection struct {
 	token              string
-	is_connected       bool
+	is_connected       *atomic.Bool // Read by the heartbeat, cleared on shutdown
 	is_identified      bool
 	heartbeat_interval int
 	conn               *websocket.Conn
+	write_mu           *sync.Mutex // conn allows one writer at a time
 	resume_gateway_url string
 	session_id         string
 	sequence           int
+	ctx                context.Context
+	out                chan<- channels.Event
 }
 
 type GatewayEvent struct {
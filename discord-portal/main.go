@@ -1,18 +1,24 @@
 package discordportal
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"mechfeed/channels"
+	"mechfeed/sources"
 
 	"github.com/gorilla/websocket"
 )
 
+const SOURCE_NAME = "discordportal"
+
 var DEBUG bool
 var (
     sending_heartbeat   bool
@@ -35,20 +41,48 @@ func initApp() (GatewayConnection, error) {
 
 	return GatewayConnection{
 		token:        discordToken,
-		is_connected: false,
+		is_connected: &atomic.Bool{},
 		conn:         c,
+		write_mu:     &sync.Mutex{},
 	}, nil
 }
 
-// Connect to discord gateway websocket server and pipe messages through channel
-func Listen() {
+// Source streams messages from the Discord gateway
+type Source struct{}
+
+func init() {
+	sources.Register(Source{})
+}
+
+func (Source) Name() string {
+	return SOURCE_NAME
+}
+
+// Connect to discord gateway websocket server and pipe messages to out
+func (Source) Start(ctx context.Context, out chan<- channels.Event) error {
 	gateway, err := initApp()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 	defer gateway.conn.Close()
-	gateway.on_message()
-	panic("gateway listener execution ended")
+	gateway.ctx = ctx
+	gateway.out = out
+
+	// Unblock the read loop on shutdown
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			gateway.is_connected.Store(false)
+			// WriteControl and Close are safe alongside the heartbeat's writes
+			close_msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			gateway.conn.WriteControl(websocket.CloseMessage, close_msg, time.Now().Add(time.Second))
+			gateway.conn.Close()
+		case <-stopped:
+		}
+	}()
+	return gateway.on_message()
 }
 
 func (g *GatewayConnection) on_message() error {
@@ -57,6 +91,9 @@ func (g *GatewayConnection) on_message() error {
 		_, json_msg, err := g.conn.ReadMessage()
 
 		if err != nil {
+			if g.ctx.Err() != nil {
+				return g.ctx.Err()
+			}
 			log.Println(string(json_msg), err)
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("unexpected error: %v", err)
@@ -89,7 +126,7 @@ func (g *GatewayConnection) on_message() error {
 		if event.OP == OP_HELLO {
 			var payload GatewayHelloPayload
 			unmarshalJSON(json_msg, &payload)
-			g.is_connected = true
+			g.is_connected.Store(true)
 			g.heartbeat_interval = payload.Data.HeartbeatInterval
 			go g.send_heartbeat()
 		}
@@ -111,23 +148,43 @@ func (g *GatewayConnection) on_message() error {
 		if event.Name == MESSAGE_CREATE {
 			var payload GatewayMessageCreatePayload
 			unmarshalJSON(json_msg, &payload)
-			channels.DiscordChannel <- channels.DiscordMessage{
-				ID:        payload.Data.ID,
-				Content:   payload.Data.Content,
-				GuildID:   payload.Data.GuildID,
-				ChannelID: payload.Data.ChannelID,
-				Timestamp: payload.Data.Timestamp,
-				Author: channels.DiscordMessageAuthor{
-					Username:      payload.Data.Author.Username,
-					GlobalName:    payload.Data.Author.GlobalName,
-					Discriminator: payload.Data.Author.Discriminator,
-					ID:            payload.Data.Author.ID,
-				},
+			if ev, ok := to_event(payload.Data); ok {
+				if err := sources.Emit(g.ctx, g.out, ev); err != nil {
+					return err
+				}
 			}
 		}
 	}
 }
 
+// Normalizes messages from monitored channels
+func to_event(msg GatewayMessageCreateData) (channels.Event, bool) {
+	channel, ok := monitored_channels[msg.ChannelID]
+	if !ok {
+		return channels.Event{}, false // Channel not being monitored
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, msg.Timestamp)
+	if err != nil {
+		timestamp = time.Now()
+	}
+
+	return channels.Event{
+		Source: SOURCE_NAME,
+		ID:     msg.ID,
+		Body:   msg.Content,
+		Author: channels.Author{
+			ID:          msg.Author.ID,
+			Username:    msg.Author.Username,
+			DisplayName: msg.Author.GlobalName,
+			ContactURL:  "https://discord.com/users/" + msg.Author.ID,
+		},
+		URL:       fmt.Sprintf("https://discord.com/channels/%s/%s/%s", msg.GuildID, msg.ChannelID, msg.ID),
+		Timestamp: timestamp,
+		Server:    monitored_servers[msg.ChannelID].Name,
+		Channel:   channel.Name,
+	}, true
+}
+
 func (g *GatewayConnection) send_heartbeat() {
 	sending_heartbeat_mu.Lock()
 	start := !sending_heartbeat
@@ -139,24 +196,30 @@ func (g *GatewayConnection) send_heartbeat() {
 	}
 	go func(){
 		log.Println("Started heartbeat")
-		for g.is_connected {
+		defer func() {
+			sending_heartbeat_mu.Lock()
+			sending_heartbeat = false
+			sending_heartbeat_mu.Unlock()
+			log.Println("Stopped heartbeat")
+		}()
+		for g.is_connected.Load() {
 			heartbeat_payload := GatewayHeartbeat{
 				GatewayEvent: GatewayEvent{OP: OP_HEARTBEAT},
 				Sequence:     g.sequence,
 			}
-			err := g.conn.WriteJSON(heartbeat_payload)
+			err := g.write_json(heartbeat_payload)
 			if err != nil {
 				log.Println("failed to send heartbeat to discord gateway, closing connnection")
 				g.conn.Close()
 				return
 			}
 			log.Println("Sent heartbeat")
-			time.Sleep(time.Duration(g.heartbeat_interval) * time.Millisecond)
+			select {
+			case <-time.After(time.Duration(g.heartbeat_interval) * time.Millisecond):
+			case <-g.ctx.Done():
+				return
+			}
 		}
-		sending_heartbeat_mu.Lock()
-		sending_heartbeat = false
-		sending_heartbeat_mu.Unlock()
-		log.Println("Stopped heartbeat")
 	}()
 }
 
@@ -173,16 +236,23 @@ func (g GatewayConnection) send_identify() error {
 			},
 		},
 	}
-	err := g.conn.WriteJSON(identify_payload)
+	err := g.write_json(identify_payload)
 	if err != nil {
 		return errors.New("failed to send gateway identify")
 	}
 	return nil
 }
 
+// Writes v as JSON, serialized with the other writes to conn
+func (g *GatewayConnection) write_json(v interface{}) error {
+	g.write_mu.Lock()
+	defer g.write_mu.Unlock()
+	return g.conn.WriteJSON(v)
+}
+
 func (g *GatewayConnection) resume_connection() error {
 	g.conn.Close()
-	g.is_connected = false
+	g.is_connected.Store(false)
 	c, _, err := websocket.DefaultDialer.Dial(g.resume_gateway_url, nil)
 	if err != nil {
 		panic("failed to resume connection")
@@ -196,12 +266,12 @@ func (g *GatewayConnection) resume_connection() error {
 			Sequence:  g.sequence,
 		},
 	}
-	err = g.conn.WriteJSON(resume_payload)
+	err = g.write_json(resume_payload)
 	if err != nil {
 		panic("failed to send gateway resume connection")
 	}
 	g.conn = c
-	g.is_connected = true
+	g.is_connected.Store(true)
 	g.is_identified = true
 	log.Println("resumed gateway connection")
 	return nil
@@ -0,0 +1,98 @@
+package discordportal
+
+import (
+	"context"
+	"errors"
+	"mechfeed/channels"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Stand-in for the gateway, greeting with a short heartbeat interval and
+// acknowledging heartbeats. Identifies are reported on identified.
+func gateway_server(t *testing.T, identified chan<- struct{}) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		if err := conn.WriteJSON(map[string]interface{}{"op": OP_HELLO, "d": map[string]int{"heartbeat_interval": 10}}); err != nil {
+			return
+		}
+		for {
+			var event GatewayEvent
+			if err := conn.ReadJSON(&event); err != nil {
+				return
+			}
+			switch event.OP {
+			case OP_HEARTBEAT:
+				conn.WriteJSON(map[string]int{"op": OP_HEARTBEAT_ACK})
+			case OP_IDENTIFY:
+				select {
+				case identified <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}))
+}
+
+func TestStartStopsOnCancel(t *testing.T) {
+	identified := make(chan struct{}, 1)
+	server := gateway_server(t, identified)
+	defer server.Close()
+	url := GATEWAY_URL
+	GATEWAY_URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	defer func() { GATEWAY_URL = url }()
+	t.Setenv("DISCORD_TOKEN", "token")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Source{}.Start(ctx, make(chan channels.Event))
+	}()
+
+	select {
+	case <-identified:
+	case err := <-done:
+		t.Fatalf("source stopped before identifying: %v", err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for identify")
+	}
+	// Let a few heartbeats go out
+	time.Sleep(time.Millisecond * 50)
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got error %v expect %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for source to stop")
+	}
+
+	// The heartbeat stops with the source
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		sending_heartbeat_mu.Lock()
+		sending := sending_heartbeat
+		sending_heartbeat_mu.Unlock()
+		if !sending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("heartbeat still running after the source stopped")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
@@ -0,0 +1,90 @@
+package filter
+
+// automaton is an Aho-Corasick matcher over a fixed set of byte patterns.
+type automaton struct {
+	nodes []acNode
+}
+
+type acNode struct {
+	next  map[byte]int32
+	fail  int32
+	out   int32 // index of the pattern ending at this node, -1 if none
+	dict  int32 // nearest node on the fail chain with out >= 0, -1 if none
+	depth int32
+}
+
+func newAutomaton(patterns []string) *automaton {
+	a := &automaton{nodes: []acNode{{out: -1, dict: -1}}}
+
+	for i, p := range patterns {
+		n := int32(0)
+		for j := 0; j < len(p); j++ {
+			child, ok := a.nodes[n].next[p[j]]
+			if !ok {
+				child = int32(len(a.nodes))
+				a.nodes = append(a.nodes, acNode{out: -1, dict: -1, depth: a.nodes[n].depth + 1})
+				if a.nodes[n].next == nil {
+					a.nodes[n].next = make(map[byte]int32)
+				}
+				a.nodes[n].next[p[j]] = child
+			}
+			n = child
+		}
+		a.nodes[n].out = int32(i)
+	}
+
+	// Breadth-first construction of fail and dictionary links
+	queue := make([]int32, 0, len(a.nodes))
+	for _, child := range a.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for c, child := range a.nodes[n].next {
+			f := a.nodes[n].fail
+			for {
+				if next, ok := a.nodes[f].next[c]; ok {
+					a.nodes[child].fail = next
+					break
+				}
+				if f == 0 {
+					break
+				}
+				f = a.nodes[f].fail
+			}
+			fail := a.nodes[child].fail
+			if a.nodes[fail].out >= 0 {
+				a.nodes[child].dict = fail
+			} else {
+				a.nodes[child].dict = a.nodes[fail].dict
+			}
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+// scan calls fn with the pattern index and byte span of every occurrence,
+// including overlapping ones, of a pattern in text.
+func (a *automaton) scan(text string, fn func(pattern, start, end int)) {
+	n := int32(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		for {
+			if next, ok := a.nodes[n].next[c]; ok {
+				n = next
+				break
+			}
+			if n == 0 {
+				break
+			}
+			n = a.nodes[n].fail
+		}
+		for m := n; m >= 0; m = a.nodes[m].dict {
+			if a.nodes[m].out >= 0 {
+				fn(int(a.nodes[m].out), i+1-int(a.nodes[m].depth), i+1)
+			}
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package filter
+
+import (
+	"mechfeed/listing"
+	"mechfeed/price"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Query is a parsed alert: a keyword expression and the constraints a
+// listing must also satisfy.
+type Query struct {
+	Expr      Expr
+	Locations []listing.Location // Listing must be in one of these, any location if empty
+	Prices    []PriceBound       // Price nearest to the keywords must satisfy all of these
+}
+
+// PriceBound limits the price of a listing, e.g. "price<200" or
+// "price<150eur".
+type PriceBound struct {
+	Op       string // One of <, <=, >, >=
+	Amount   float64
+	Currency string // ISO 4217 code, prices in other currencies don't match
+}
+
+func (b PriceBound) Match(amount float64) bool {
+	switch b.Op {
+	case "<":
+		return amount < b.Amount
+	case "<=":
+		return amount <= b.Amount
+	case ">":
+		return amount > b.Amount
+	case ">=":
+		return amount >= b.Amount
+	}
+	return false
+}
+
+func (b PriceBound) String() string {
+	s := "price" + b.Op + strconv.FormatFloat(b.Amount, 'f', -1, 64)
+	if b.Currency != "USD" {
+		s += strings.ToLower(b.Currency)
+	}
+	return s
+}
+
+// MatchLocation reports whether a listing at l satisfies the location
+// constraints. Listings without a known location only satisfy queries
+// without location constraints.
+func (q *Query) MatchLocation(l listing.Location) bool {
+	if len(q.Locations) == 0 {
+		return true
+	}
+	for _, loc := range q.Locations {
+		if loc.Contains(l) {
+			return true
+		}
+	}
+	return false
+}
+
+// Price returns the price in content nearest to the keywords of the query.
+func (q *Query) Price(content string) (price.Price, bool) {
+	prices := price.Extract(content)
+	if len(prices) == 0 {
+		return price.Price{}, false
+	}
+	var spans [][]int
+	for _, t := range positiveTerms(q.Expr) {
+		if t.Field == FieldContent {
+			spans = append(spans, t.re.FindAllStringIndex(content, -1)...)
+		}
+	}
+	return price.Nearest(content, prices, spans)
+}
+
+// MatchPrice reports whether a listing priced at p satisfies the price
+// bounds. Listings without a price only satisfy queries without bounds.
+func (q *Query) MatchPrice(p price.Price, found bool) bool {
+	if len(q.Prices) == 0 {
+		return true
+	}
+	if !found {
+		return false
+	}
+	for _, b := range q.Prices {
+		// Amounts aren't converted, so only the same currency compares
+		if p.Currency != b.Currency || !b.Match(p.Amount) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchedTerms returns the distinct terms of the query that m matched and
+// that aren't negated, i.e. the keywords a message was notified for.
+func (q *Query) MatchedTerms(m Matcher) []string {
+	var matched []string
+	seen := make(map[string]bool)
+	for _, t := range positiveTerms(q.Expr) {
+		if s := t.String(); !seen[s] && m.MatchTerm(t) {
+			seen[s] = true
+			matched = append(matched, s)
+		}
+	}
+	return matched
+}
+
+func (q *Query) String() string {
+	var parts []string
+	if s := q.Expr.String(); s != "" {
+		parts = append(parts, s)
+	}
+	if len(q.Locations) > 0 {
+		locations := make([]string, len(q.Locations))
+		for i, l := range q.Locations {
+			locations[i] = l.String()
+		}
+		parts = append(parts, FieldLocation+":"+strings.Join(locations, ","))
+	}
+	for _, b := range q.Prices {
+		parts = append(parts, b.String())
+	}
+	return strings.Join(parts, " & ")
+}
+
+// Expr is a node of a parsed alert expression.
+type Expr interface {
+	Eval(m Matcher) bool
+	String() string
+}
+
+// Matcher reports whether a single term occurs in the message being filtered.
+type Matcher interface {
+	MatchTerm(t *Term) bool
+}
+
+// Text matches unqualified terms against a single piece of message content.
+type Text string
+
+func (c Text) MatchTerm(t *Term) bool {
+	return t.Field == FieldContent && t.re.MatchString(string(c))
+}
+
+// Searchable parts of a message that terms can be scoped to, e.g. "title:olivia".
+const (
+	FieldContent = "" // Unqualified terms
+	FieldTitle   = "title"
+	FieldAuthor  = "author"
+	FieldFlair   = "flair"
+	FieldServer  = "server"
+	FieldChannel = "channel"
+	FieldHave    = "have" // [H] side of a mechmarket title
+	FieldWant    = "want" // [W] side of a mechmarket title
+
+	// Constrains the listing location instead of matching text, e.g. loc:CA,US
+	FieldLocation = "loc"
+)
+
+func isField(name string) bool {
+	switch name {
+	case FieldTitle, FieldAuthor, FieldFlair, FieldServer, FieldChannel, FieldHave, FieldWant, FieldLocation:
+		return true
+	}
+	return false
+}
+
+// Fields matches terms against the searchable parts of a message, keyed by
+// field name. Terms scoped to a missing field never match.
+type Fields map[string]string
+
+func (f Fields) MatchTerm(t *Term) bool {
+	return t.re.MatchString(f[t.Field])
+}
+
+// Term is a keyword or quoted phrase matched case-insensitively on word
+// boundaries, optionally scoped to a field.
+type Term struct {
+	Field  string
+	Value  string
+	Phrase bool
+	text   string // lowercased words joined by single spaces
+	key    string // field and text, identifies the term in an Index
+	re     *regexp.Regexp
+}
+
+func newTerm(field, value string, phrase bool) *Term {
+	words := strings.Fields(value)
+	for i, w := range words {
+		words[i] = regexp.QuoteMeta(w)
+	}
+	text := strings.ToLower(strings.Join(strings.Fields(value), " "))
+	return &Term{
+		Field:  field,
+		Value:  value,
+		Phrase: phrase,
+		text:   text,
+		key:    termKey(field, text),
+		re:     regexp.MustCompile(`(?i)\b` + strings.Join(words, `\s+`) + `\b`),
+	}
+}
+
+func termKey(field, text string) string {
+	return field + "\x00" + text
+}
+
+// Terms returns every term referenced by x.
+func Terms(x Expr) []*Term {
+	var terms []*Term
+	walk(x, func(x Expr) {
+		if t, ok := x.(*Term); ok {
+			terms = append(terms, t)
+		}
+	})
+	return terms
+}
+
+// positiveTerms returns the terms of x that aren't negated.
+func positiveTerms(x Expr) []*Term {
+	var terms []*Term
+	var visit func(x Expr, negated bool)
+	visit = func(x Expr, negated bool) {
+		switch x := x.(type) {
+		case *Term:
+			if !negated {
+				terms = append(terms, x)
+			}
+		case Not:
+			visit(x.X, !negated)
+		case And:
+			for _, y := range x {
+				visit(y, negated)
+			}
+		case Or:
+			for _, y := range x {
+				visit(y, negated)
+			}
+		}
+	}
+	visit(x, false)
+	return terms
+}
+
+func walk(x Expr, fn func(Expr)) {
+	fn(x)
+	switch x := x.(type) {
+	case Not:
+		walk(x.X, fn)
+	case And:
+		for _, y := range x {
+			walk(y, fn)
+		}
+	case Or:
+		for _, y := range x {
+			walk(y, fn)
+		}
+	}
+}
+
+func (t *Term) Eval(m Matcher) bool {
+	return m.MatchTerm(t)
+}
+
+func (t *Term) String() string {
+	value := t.Value
+	if t.Phrase {
+		value = `"` + t.Value + `"`
+	}
+	if t.Field != FieldContent {
+		return t.Field + ":" + value
+	}
+	return value
+}
+
+// Not negates its operand.
+type Not struct {
+	X Expr
+}
+
+func (n Not) Eval(m Matcher) bool {
+	return !n.X.Eval(m)
+}
+
+func (n Not) String() string {
+	return "-" + group(n.X)
+}
+
+// And matches when every operand matches.
+type And []Expr
+
+func (a And) Eval(m Matcher) bool {
+	for _, x := range a {
+		if !x.Eval(m) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a And) String() string {
+	parts := make([]string, len(a))
+	for i, x := range a {
+		if _, ok := x.(Or); ok {
+			parts[i] = group(x)
+		} else {
+			parts[i] = x.String()
+		}
+	}
+	return strings.Join(parts, " & ")
+}
+
+// Or matches when any operand matches.
+type Or []Expr
+
+func (o Or) Eval(m Matcher) bool {
+	for _, x := range o {
+		if x.Eval(m) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o Or) String() string {
+	parts := make([]string, len(o))
+	for i, x := range o {
+		parts[i] = x.String()
+	}
+	return strings.Join(parts, " | ")
+}
+
+func group(x Expr) string {
+	switch x.(type) {
+	case And, Or:
+		return "(" + x.String() + ")"
+	}
+	return x.String()
+}
+
+// constraint is a condition on the listing rather than its text, such as
+// "loc:US" or "price<200". Constraints are moved out of the expression into
+// the Query while parsing.
+type constraint interface {
+	Expr
+	apply(q *Query)
+	position() int
+	describe() string
+}
+
+type locationConstraint struct {
+	locations []listing.Location
+	pos       int
+}
+
+func (l *locationConstraint) Eval(m Matcher) bool {
+	return true
+}
+
+func (l *locationConstraint) String() string {
+	return (&Query{Expr: And{}, Locations: l.locations}).String()
+}
+
+func (l *locationConstraint) apply(q *Query) {
+	q.Locations = append(q.Locations, l.locations...)
+}
+
+func (l *locationConstraint) position() int {
+	return l.pos
+}
+
+func (l *locationConstraint) describe() string {
+	return `"` + FieldLocation + `:"`
+}
+
+type priceConstraint struct {
+	bound PriceBound
+	pos   int
+}
+
+func (c *priceConstraint) Eval(m Matcher) bool {
+	return true
+}
+
+func (c *priceConstraint) String() string {
+	return c.bound.String()
+}
+
+func (c *priceConstraint) apply(q *Query) {
+	q.Prices = append(q.Prices, c.bound)
+}
+
+func (c *priceConstraint) position() int {
+	return c.pos
+}
+
+func (c *priceConstraint) describe() string {
+	return `"` + c.bound.String() + `"`
+}
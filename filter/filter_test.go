@@ -53,5 +53,56 @@ func TestFilter(t *testing.T) {
 			t.Errorf("got %t expect %t", got, expect)
 		}
 	})
+
+	t.Run("or and grouping", func(t *testing.T) {
+		const content = "WTS SA Olivia Dark keycaps, kit only"
+		var Keywords = `(gmk | sa) & "olivia dark" & -keycap-only`
+		got := FilterKeywords(content, Keywords)
+		expect := true
+
+		if got != expect {
+			t.Errorf("got %t expect %t", got, expect)
+		}
+	})
+	t.Run("negated hyphenated keyword", func(t *testing.T) {
+		const content = "WTS GMK Olivia Dark, keycap-only listing"
+		var Keywords = `(gmk | sa) & "olivia dark" & -keycap-only`
+		got := FilterKeywords(content, Keywords)
+		expect := false
+
+		if got != expect {
+			t.Errorf("got %t expect %t", got, expect)
+		}
+	})
+	t.Run("phrase requires adjacent words", func(t *testing.T) {
+		const content = "gmk dark and gmk olivia"
+		var Keywords = `"olivia dark"`
+		got := FilterKeywords(content, Keywords)
+		expect := false
+
+		if got != expect {
+			t.Errorf("got %t expect %t", got, expect)
+		}
+	})
+	t.Run("phrase spans extra whitespace", func(t *testing.T) {
+		const content = "gmk olivia\n  dark base kit"
+		var Keywords = `"gmk olivia dark"`
+		got := FilterKeywords(content, Keywords)
+		expect := true
+
+		if got != expect {
+			t.Errorf("got %t expect %t", got, expect)
+		}
+	})
+	t.Run("malformed expression doesn't match", func(t *testing.T) {
+		const content = "gmk olivia"
+		var Keywords = "(gmk | olivia"
+		got := FilterKeywords(content, Keywords)
+		expect := false
+
+		if got != expect {
+			t.Errorf("got %t expect %t", got, expect)
+		}
+	})
 	
 }
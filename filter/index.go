@@ -0,0 +1,262 @@
+package filter
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Index holds the parsed expressions of every alert and matches a message
+// against all of them with a single scan of each of its fields. It is safe
+// for concurrent use.
+type Index struct {
+	mu       sync.RWMutex
+	alerts   map[int32]*indexedAlert
+	byTerm   map[string]map[int32]struct{} // term key -> alerts referencing it
+	always   map[int32]struct{}            // alerts that match messages without any terms, e.g. "-red"
+	patterns []string                      // distinct term texts across all fields
+	ac       *automaton
+}
+
+type indexedAlert struct {
+	keyword string
+	query   *Query // nil if the keyword failed to parse
+	keys    []string
+}
+
+func NewIndex() *Index {
+	return &Index{
+		alerts: make(map[int32]*indexedAlert),
+		byTerm: make(map[string]map[int32]struct{}),
+		always: make(map[int32]struct{}),
+		ac:     newAutomaton(nil),
+	}
+}
+
+// Len returns the number of indexed alerts.
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.alerts)
+}
+
+// Query returns the parsed alert with the given ID.
+func (ix *Index) Query(id int32) (*Query, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	a, ok := ix.alerts[id]
+	if !ok || a.query == nil {
+		return nil, false
+	}
+	return a.query, true
+}
+
+// Set adds or replaces the alert with the given ID. Alerts that fail to parse
+// are kept in the index but never match.
+func (ix *Index) Set(id int32, keyword string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	changed, err := ix.set(id, keyword)
+	if changed {
+		ix.rebuild()
+	}
+	return err
+}
+
+// Remove drops the alert with the given ID from the index.
+func (ix *Index) Remove(id int32) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if ix.remove(id) {
+		ix.rebuild()
+	}
+}
+
+// Sync brings the index in line with keywords, a map of alert ID to keyword.
+// Only alerts that were added, removed or edited are re-parsed.
+func (ix *Index) Sync(keywords map[int32]string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	changed := false
+	for id := range ix.alerts {
+		if _, ok := keywords[id]; !ok {
+			changed = ix.remove(id) || changed
+		}
+	}
+	for id, keyword := range keywords {
+		c, _ := ix.set(id, keyword)
+		changed = c || changed
+	}
+	if changed {
+		ix.rebuild()
+	}
+}
+
+// Match returns the IDs of every alert matching the message fields, in
+// ascending order.
+func (ix *Index) Match(fields Fields) []int32 {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	hits := ix.scan(fields)
+
+	// Alerts without a hit term evaluate the same as on empty content, so only
+	// alerts referencing a hit term or matching empty content need evaluating.
+	candidates := make(map[int32]struct{}, len(ix.always))
+	for id := range ix.always {
+		candidates[id] = struct{}{}
+	}
+	for key := range hits {
+		for id := range ix.byTerm[key] {
+			candidates[id] = struct{}{}
+		}
+	}
+
+	var matched []int32
+	for id := range candidates {
+		if ix.alerts[id].query.Expr.Eval(hits) {
+			matched = append(matched, id)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i] < matched[j] })
+	return matched
+}
+
+// set reports whether the set of indexed terms may have changed.
+func (ix *Index) set(id int32, keyword string) (bool, error) {
+	if a, ok := ix.alerts[id]; ok {
+		if a.keyword == keyword {
+			return false, nil
+		}
+		ix.remove(id)
+	}
+
+	a := &indexedAlert{keyword: keyword}
+	ix.alerts[id] = a
+	q, err := Parse(keyword)
+	if err != nil {
+		return true, err
+	}
+	a.query = q
+
+	seen := make(map[string]bool)
+	for _, t := range Terms(q.Expr) {
+		if seen[t.key] {
+			continue
+		}
+		seen[t.key] = true
+		a.keys = append(a.keys, t.key)
+		if ix.byTerm[t.key] == nil {
+			ix.byTerm[t.key] = make(map[int32]struct{})
+		}
+		ix.byTerm[t.key][id] = struct{}{}
+	}
+	if q.Expr.Eval(termHits(nil)) {
+		ix.always[id] = struct{}{}
+	}
+	return true, nil
+}
+
+func (ix *Index) remove(id int32) bool {
+	a, ok := ix.alerts[id]
+	if !ok {
+		return false
+	}
+	for _, key := range a.keys {
+		delete(ix.byTerm[key], id)
+		if len(ix.byTerm[key]) == 0 {
+			delete(ix.byTerm, key)
+		}
+	}
+	delete(ix.always, id)
+	delete(ix.alerts, id)
+	return true
+}
+
+// rebuild recompiles the automaton if the set of indexed term texts changed.
+func (ix *Index) rebuild() {
+	texts := make(map[string]struct{}, len(ix.byTerm))
+	for key := range ix.byTerm {
+		texts[key[strings.IndexByte(key, 0)+1:]] = struct{}{}
+	}
+	if len(texts) == len(ix.patterns) {
+		same := true
+		for _, p := range ix.patterns {
+			if _, ok := texts[p]; !ok {
+				same = false
+				break
+			}
+		}
+		if same {
+			return
+		}
+	}
+	ix.patterns = ix.patterns[:0]
+	for text := range texts {
+		ix.patterns = append(ix.patterns, text)
+	}
+	ix.ac = newAutomaton(ix.patterns)
+}
+
+// scan returns the keys of every indexed term occurring on word boundaries
+// in the field it is scoped to.
+func (ix *Index) scan(fields Fields) termHits {
+	hits := make(termHits)
+	for field, value := range fields {
+		text := normalize(value)
+		ix.ac.scan(text, func(pattern, start, end int) {
+			key := termKey(field, ix.patterns[pattern])
+			if hits[key] {
+				return
+			}
+			if _, ok := ix.byTerm[key]; !ok {
+				return
+			}
+			if isBoundary(text, start) && isBoundary(text, end) {
+				hits[key] = true
+			}
+		})
+	}
+	return hits
+}
+
+// termHits is a Matcher over the term keys found in a message.
+type termHits map[string]bool
+
+func (h termHits) MatchTerm(t *Term) bool {
+	return h[t.key]
+}
+
+// normalize lowercases s and collapses runs of whitespace into single spaces
+// so that phrases match across line breaks, like the `\s+` in Term's regexp.
+func normalize(s string) string {
+	s = strings.ToLower(s)
+	var sb strings.Builder
+	sb.Grow(len(s))
+	space := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t', '\n', '\f', '\r':
+			if !space {
+				sb.WriteByte(' ')
+			}
+			space = true
+		default:
+			sb.WriteByte(s[i])
+			space = false
+		}
+	}
+	return sb.String()
+}
+
+// isBoundary reports whether i is an ASCII word boundary in s, matching `\b`.
+func isBoundary(s string, i int) bool {
+	before := i > 0 && isWordByte(s[i-1])
+	after := i < len(s) && isWordByte(s[i])
+	return before != after
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,144 @@
+package filter
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var indexKeywords = map[int32]string{
+	1: "WTB,Kaze,-Red",
+	2: `(gmk | sa) & "olivia dark" & -keycap-only`,
+	3: "-red",
+	4: "buy",
+	5: "gmk,",
+	6: `"olivia dark"`,
+	7: "kaze | -wtb",
+}
+
+var indexContents = []string{
+	"HAHhAHAH omg WTB Kaze for free",
+	"HAHhAHAH omg WTB a red Kaze for free",
+	"buying a set here so I don'rt have to wait for shipping",
+	"WTS SA Olivia\n  Dark keycaps, kit only",
+	"WTS GMK Olivia Dark, keycap-only listing",
+	"",
+}
+
+func TestIndex(t *testing.T) {
+	t.Run("agrees with FilterKeywords", func(t *testing.T) {
+		ix := NewIndex()
+		ix.Sync(indexKeywords)
+		for _, content := range indexContents {
+			var expect []int32
+			for id := int32(1); id <= int32(len(indexKeywords)); id++ {
+				if FilterKeywords(content, indexKeywords[id]) {
+					expect = append(expect, id)
+				}
+			}
+			got := ix.Match(Fields{FieldContent: content})
+			if !reflect.DeepEqual(got, expect) {
+				t.Errorf("%q: got %v expect %v", content, got, expect)
+			}
+		}
+	})
+	t.Run("sync applies edits and removals", func(t *testing.T) {
+		ix := NewIndex()
+		ix.Sync(indexKeywords)
+		ix.Sync(map[int32]string{4: "kaze", 6: `"olivia dark"`})
+		got := ix.Match(Fields{FieldContent: "WTB Kaze in Olivia Dark"})
+		expect := []int32{4, 6}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("got %v expect %v", got, expect)
+		}
+		if ix.Len() != 2 {
+			t.Errorf("got %d alerts expect 2", ix.Len())
+		}
+	})
+	t.Run("field scoped terms", func(t *testing.T) {
+		ix := NewIndex()
+		ix.Sync(map[int32]string{
+			1: "title:olivia",
+			2: "olivia",
+			3: `server:"top clack" & channel:(buying | selling)`,
+			4: "author:someuser & -flair:buying",
+		})
+		got := ix.Match(Fields{
+			FieldTitle:   "[US-CA][H] GMK Olivia [W] PayPal",
+			FieldContent: "Timestamps in comments",
+			FieldAuthor:  "someuser",
+			FieldFlair:   "Selling",
+		})
+		expect := []int32{1, 4}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("got %v expect %v", got, expect)
+		}
+
+		got = ix.Match(Fields{
+			FieldContent: "WTB olivia",
+			FieldServer:  "Top Clack",
+			FieldChannel: "mechmarket-buying",
+		})
+		expect = []int32{2, 3}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("got %v expect %v", got, expect)
+		}
+	})
+	t.Run("set and remove", func(t *testing.T) {
+		ix := NewIndex()
+		if err := ix.Set(1, "gmk,"); err == nil {
+			t.Errorf("expected parse error")
+		}
+		ix.Set(2, "gmk")
+		ix.Remove(2)
+		if got := ix.Match(Fields{FieldContent: "gmk olivia"}); len(got) != 0 {
+			t.Errorf("got %v expect no matches", got)
+		}
+	})
+}
+
+var benchContent = "[US-CA][H] GMK Olivia Dark base, SA Bliss novelties, Kaze keyboard " +
+	"in e-white with alu plate [W] PayPal, local cash. Timestamps and prices in comments, " +
+	"will ship conus only, no trades please, keycap-only sets come with original boxes."
+
+func benchKeywords(n int) map[int32]string {
+	keywords := make(map[int32]string, n)
+	for i := 0; i < n; i++ {
+		switch i % 4 {
+		case 0:
+			keywords[int32(i)] = fmt.Sprintf("gmk,set%d,-red", i)
+		case 1:
+			keywords[int32(i)] = fmt.Sprintf(`(gmk | sa) & "olivia dark" & -keycap%d`, i)
+		case 2:
+			keywords[int32(i)] = fmt.Sprintf("kaze%d | board%d", i, i)
+		default:
+			keywords[int32(i)] = fmt.Sprintf(`"artisan %d",-wtb`, i)
+		}
+	}
+	return keywords
+}
+
+func BenchmarkFilterKeywords(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		keywords := benchKeywords(n)
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, k := range keywords {
+					FilterKeywords(benchContent, k)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkIndexMatch(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		ix := NewIndex()
+		ix.Sync(benchKeywords(n))
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ix.Match(Fields{FieldContent: benchContent})
+			}
+		})
+	}
+}
@@ -0,0 +1,474 @@
+package filter
+
+import (
+	"fmt"
+	"mechfeed/listing"
+	"mechfeed/price"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Alert expression grammar:
+//
+//	expr    = and { "|" and }
+//	and     = unary { ( "&" | "," | <space> ) unary }
+//	unary   = "-" unary | primary
+//	primary = [ field ":" ] ( word | '"' phrase '"' | "(" expr ")" ) | constraint
+//	constraint = "loc:" locations | "price" ( "<" | "<=" | ">" | ">=" ) amount
+//
+// The legacy comma syntax ("gmk,dandy,-daisy") is a subset of this grammar.
+// Constraints ("loc:CA,US", "price<200") may only be joined to the rest of
+// the alert with AND.
+
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokPhrase
+	tokAnd
+	tokOr
+	tokNot
+	tokLParen
+	tokRParen
+	tokField
+	tokEOF
+)
+
+type token struct {
+	kind  tokenKind
+	text  string
+	pos   int  // byte offset into the source expression
+	space bool // preceded by whitespace
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of alert"
+	case tokField:
+		return `"` + t.text + `:"`
+	default:
+		return `"` + t.text + `"`
+	}
+}
+
+// ParseError describes a malformed alert expression.
+type ParseError struct {
+	Expr string
+	Pos  int // byte offset of the offending token
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	if e.Pos >= len(e.Expr) {
+		return fmt.Sprintf("%s at end of alert", e.Msg)
+	}
+	col := utf8.RuneCountInString(e.Expr[:e.Pos]) + 1
+	return fmt.Sprintf("%s at position %d", e.Msg, col)
+}
+
+func isWordRune(r rune) bool {
+	switch r {
+	case '(', ')', '|', '&', ',', '"':
+		return false
+	}
+	return !unicode.IsSpace(r)
+}
+
+func lex(src string) ([]token, error) {
+	var tokens []token
+	space := false
+	for i := 0; i < len(src); {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		if unicode.IsSpace(r) {
+			space = true
+			i += size
+			continue
+		}
+
+		tok := token{pos: i, space: space}
+		space = false
+		switch r {
+		case '(':
+			tok.kind, tok.text = tokLParen, "("
+			i++
+		case ')':
+			tok.kind, tok.text = tokRParen, ")"
+			i++
+		case '|':
+			tok.kind, tok.text = tokOr, "|"
+			i++
+		case '&', ',':
+			tok.kind, tok.text = tokAnd, string(r)
+			i++
+		case '-':
+			tok.kind, tok.text = tokNot, "-"
+			i++
+		case '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end < 0 {
+				return nil, &ParseError{Expr: src, Pos: i, Msg: "unterminated quote"}
+			}
+			phrase := strings.Join(strings.Fields(src[i+1:i+1+end]), " ")
+			if phrase == "" {
+				return nil, &ParseError{Expr: src, Pos: i, Msg: "empty quoted phrase"}
+			}
+			tok.kind, tok.text = tokPhrase, phrase
+			i += end + 2
+		default:
+			j := i
+			for j < len(src) {
+				r, size := utf8.DecodeRuneInString(src[j:])
+				if !isWordRune(r) {
+					break
+				}
+				j += size
+			}
+			tok.kind, tok.text = tokWord, src[i:j]
+			if k := strings.IndexByte(tok.text, ':'); k > 0 && isField(strings.ToLower(tok.text[:k])) {
+				// Qualifier, lex its value separately
+				tok.kind, tok.text = tokField, strings.ToLower(tok.text[:k])
+				j = i + k + 1
+				if tok.text == FieldLocation {
+					// Locations are a comma separated list, e.g. loc:CA,US-NY
+					tokens = append(tokens, tok)
+					tok = token{kind: tokWord, pos: j}
+					for j < len(src) && isLocationByte(src[j]) {
+						j++
+					}
+					tok.text = src[tok.pos:j]
+				}
+			}
+			i = j
+		}
+		tokens = append(tokens, tok)
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(src), space: space}), nil
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+	field  string // qualifier applied to terms being parsed
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &ParseError{Expr: p.src, Pos: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// startsOperand reports whether t can begin an operand, which makes
+// juxtaposition an implicit AND.
+func startsOperand(t token) bool {
+	switch t.kind {
+	case tokWord, tokPhrase, tokNot, tokLParen, tokField:
+		return true
+	}
+	return false
+}
+
+// startsConstraint reports whether t starts a constraint such as loc:US or
+// price<200.
+func startsConstraint(t token) bool {
+	return t.kind == tokField && t.text == FieldLocation || t.kind == tokWord && price_pattern.MatchString(t.text)
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	terms := []Expr{x}
+	for p.peek().kind == tokOr {
+		op := p.next()
+		if !startsOperand(p.peek()) {
+			return nil, p.errorf(p.peek(), "expected a keyword after %q, found %s", op.text, p.peek().describe())
+		}
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, y)
+	}
+	if len(terms) == 1 {
+		return x, nil
+	}
+	return Or(terms), nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	terms := []Expr{x}
+	for {
+		t := p.peek()
+		if t.kind == tokAnd {
+			p.next()
+			if !startsOperand(p.peek()) {
+				return nil, p.errorf(p.peek(), "expected a keyword after %q, found %s", t.text, p.peek().describe())
+			}
+		} else if !startsOperand(t) {
+			break
+		}
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, y)
+	}
+	if len(terms) == 1 {
+		return x, nil
+	}
+	return And(terms), nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.peek().kind == tokNot {
+		op := p.next()
+		if !startsOperand(p.peek()) {
+			return nil, p.errorf(p.peek(), "expected a keyword after %q, found %s", op.text, p.peek().describe())
+		}
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{X: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokWord:
+		if m := price_pattern.FindStringSubmatch(t.text); m != nil && p.field == "" {
+			amount, err := strconv.ParseFloat(m[3], 64)
+			if err != nil {
+				return nil, p.errorf(t, "invalid price %q", m[3])
+			}
+			currency := "USD"
+			if m[2] != "" {
+				currency, _ = price.ParseCurrency(m[2])
+			}
+			if m[4] != "" {
+				code, _ := price.ParseCurrency(m[4])
+				if m[2] != "" && code != currency {
+					return nil, p.errorf(t, "%s has two currencies", t.describe())
+				}
+				currency = code
+			}
+			return &priceConstraint{bound: PriceBound{Op: m[1], Amount: amount, Currency: currency}, pos: t.pos}, nil
+		}
+		return newTerm(p.field, t.text, false), nil
+	case tokPhrase:
+		return newTerm(p.field, t.text, true), nil
+	case tokField:
+		if t.text == FieldLocation {
+			return p.parseLocations(t)
+		}
+		if p.field != "" {
+			return nil, p.errorf(t, "%s can't be used inside \"%s:\"", t.describe(), p.field)
+		}
+		switch p.peek().kind {
+		case tokWord, tokPhrase, tokLParen:
+		default:
+			return nil, p.errorf(p.peek(), "expected a keyword after %s, found %s", t.describe(), p.peek().describe())
+		}
+		p.field = t.text
+		x, err := p.parsePrimary()
+		p.field = ""
+		return x, err
+	case tokLParen:
+		if p.peek().kind == tokRParen {
+			return nil, p.errorf(t, "empty parentheses")
+		}
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokRParen {
+			return nil, p.errorf(t, "missing \")\" to close \"(\"")
+		}
+		p.next()
+		return x, nil
+	case tokEOF:
+		return nil, p.errorf(t, "expected a keyword")
+	default:
+		return nil, p.errorf(t, "unexpected %s", t.describe())
+	}
+}
+
+func (p *parser) parseLocations(t token) (Expr, error) {
+	if p.field != "" {
+		return nil, p.errorf(t, "%s can't be used inside \"%s:\"", t.describe(), p.field)
+	}
+	value := p.peek()
+	if value.kind != tokWord || value.text == "" {
+		return nil, p.errorf(value, "expected a location after %s such as loc:US or loc:CA,US-NY", t.describe())
+	}
+	p.next()
+	locations, err := listing.ParseLocations(value.text)
+	if err != nil {
+		return nil, p.errorf(value, "%v in %s", err, t.describe())
+	}
+	return &locationConstraint{locations: locations, pos: t.pos}, nil
+}
+
+// price<200, price<=€90 or price>150cad. Prices without a currency are USD.
+var price_pattern = regexp.MustCompile(`^(?i)price(<=|>=|<|>)(\$|€|£)?(\d+(?:\.\d+)?)(usd|cad|eur|gbp|aud)?$`)
+
+func isLocationByte(c byte) bool {
+	return c == ',' || c == '-' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// Parse parses an alert such as `(gmk | sa) & "olivia dark" & -keycap-only & loc:US`.
+func Parse(alert string) (*Query, error) {
+	tokens, err := lex(alert)
+	if err != nil {
+		return nil, err
+	}
+	if tokens[0].kind == tokEOF {
+		return nil, &ParseError{Expr: alert, Pos: len(alert), Msg: "empty alert"}
+	}
+	p := &parser{src: alert, tokens: tokens}
+	x, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t.describe())
+	}
+	return p.query(x)
+}
+
+// query separates the top level constraints of x from its keyword expression.
+func (p *parser) query(x Expr) (*Query, error) {
+	q := &Query{Expr: x}
+	var conjuncts []Expr
+	switch x := x.(type) {
+	case constraint:
+		conjuncts = []Expr{x}
+	case And:
+		conjuncts = x
+	default:
+		return q, p.checkConstraints(x)
+	}
+
+	var rest And
+	for _, c := range conjuncts {
+		if c, ok := c.(constraint); ok {
+			c.apply(q)
+			continue
+		}
+		if err := p.checkConstraints(c); err != nil {
+			return nil, err
+		}
+		rest = append(rest, c)
+	}
+	switch len(rest) {
+	case 0:
+		// Constraints alone would match every listing
+		return nil, &ParseError{Expr: p.src, Pos: conjuncts[0].(constraint).position(), Msg: "alert needs a keyword besides its constraints"}
+	case 1:
+		q.Expr = rest[0]
+	default:
+		q.Expr = rest
+	}
+	return q, nil
+}
+
+// checkConstraints rejects constraints that aren't joined to the alert with AND.
+func (p *parser) checkConstraints(x Expr) error {
+	var err error
+	walk(x, func(x Expr) {
+		if c, ok := x.(constraint); ok && err == nil {
+			err = &ParseError{Expr: p.src, Pos: c.position(), Msg: c.describe() + ` can only be combined with "&"`}
+		}
+	})
+	return err
+}
+
+// SplitAlerts splits user input into separate alert expressions. Alerts are
+// separated by whitespace between two complete expressions at the top level,
+// so "gmk,dandy kaze" yields two alerts while `(gmk | sa) & "olivia dark"`
+// yields one. Input of several alerts with constraints is rejected, as
+// "gmk olivia price<200" would limit only "olivia" to the price. Every alert
+// is validated with Parse.
+func SplitAlerts(input string) ([]string, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	var alerts []string
+	depth, start := 0, 0
+	keyword := false     // Whether the current alert has more than constraints
+	constrained := false // Whether any alert has constraints
+	for i, t := range tokens {
+		if i > 0 && depth == 0 && t.space && startsOperand(t) && keyword && !startsConstraint(t) {
+			switch tokens[i-1].kind {
+			case tokWord, tokPhrase, tokRParen:
+				alerts = append(alerts, strings.TrimSpace(input[start:t.pos]))
+				start = t.pos
+				keyword = false
+			}
+		}
+		location := i > 0 && tokens[i-1].kind == tokField && tokens[i-1].text == FieldLocation
+		if (t.kind == tokWord || t.kind == tokPhrase) && !location && !startsConstraint(t) {
+			keyword = true
+		}
+		if startsConstraint(t) {
+			constrained = true
+		}
+		switch t.kind {
+		case tokLParen:
+			depth++
+		case tokRParen:
+			if depth > 0 {
+				depth--
+			}
+		case tokEOF:
+			if rest := strings.TrimSpace(input[start:]); rest != "" {
+				alerts = append(alerts, rest)
+			}
+		}
+	}
+
+	for _, alert := range alerts {
+		if _, err := Parse(alert); err != nil {
+			return nil, fmt.Errorf("%q: %w", alert, err)
+		}
+	}
+	if constrained && len(alerts) > 1 {
+		return nil, fmt.Errorf("%q is %d alerts, %s, so its constraints would only apply to the one next to them. "+
+			`For one alert join the keywords with "&", e.g. %q, or add the alerts one at a time`,
+			input, len(alerts), quote_all(alerts), strings.Join(alerts, " & "))
+	}
+	return alerts, nil
+}
+
+// e.g. `"gmk" and "olivia price<200"`
+func quote_all(s []string) string {
+	quoted := make([]string, len(s))
+	for i, x := range s {
+		quoted[i] = strconv.Quote(x)
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " and " + quoted[len(quoted)-1]
+}
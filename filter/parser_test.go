@@ -0,0 +1,190 @@
+package filter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	t.Run("canonical form", func(t *testing.T) {
+		cases := map[string]string{
+			"gmk,dandy,-daisy":                           "gmk & dandy & -daisy",
+			"gmk, dandy , -daisy":                        "gmk & dandy & -daisy",
+			`(gmk | sa) & "olivia  dark" & -keycap-only`: `(gmk | sa) & "olivia dark" & -keycap-only`,
+			"gmk | sa & olivia":                          "gmk | sa & olivia",
+			"-(wts | wtt)":                               "-(wts | wtt)",
+			`Title:olivia & server:"Top Clack"`:          `title:olivia & server:"Top Clack"`,
+			"flair:(selling | trading) & -author:bot":    "(flair:selling | flair:trading) & -author:bot",
+			"have:olivia & -want:olivia":                 "have:olivia & -want:olivia",
+			"http://imgur.com & 10:30":                   "http://imgur.com & 10:30",
+			"loc:us-ca,EU & gmk, loc:CAN":                "gmk & loc:US-CA,EU,CA",
+			"loc:usa & gmk":                              "gmk & loc:US",
+			`"olivia dark" price<200 Price>=50.5`:        `"olivia dark" & price<200 & price>=50.5`,
+			"price | prices":                             "price | prices",
+			"olivia price<=€90":                          "olivia & price<=90eur",
+			"kaze & price>150CAD & price<$300":           "kaze & price>150cad & price<300",
+		}
+		for input, expect := range cases {
+			q, err := Parse(input)
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", input, err)
+				continue
+			}
+			if got := q.String(); got != expect {
+				t.Errorf("%q: got %q expect %q", input, got, expect)
+			}
+		}
+	})
+	t.Run("malformed expressions", func(t *testing.T) {
+		cases := map[string]string{
+			"":                   "empty alert at end of alert",
+			"gmk,":               `expected a keyword after ",", found end of alert at end of alert`,
+			"gmk,,dandy":         `expected a keyword after ",", found "," at position 5`,
+			"(gmk | sa":          `missing ")" to close "(" at position 1`,
+			"gmk | sa)":          `unexpected ")" at position 9`,
+			`"olivia dark`:       "unterminated quote at position 1",
+			"gmk & ()":           "empty parentheses at position 7",
+			"| gmk":              `unexpected "|" at position 1`,
+			"gmk & -":            `expected a keyword after "-", found end of alert at end of alert`,
+			"title:":             `expected a keyword after "title:", found end of alert at end of alert`,
+			"title:-gmk":         `expected a keyword after "title:", found "-" at position 7`,
+			"title:(author:x)":   `"author:" can't be used inside "title:" at position 8`,
+			"gmk | loc:US":       `"loc:" can only be combined with "&" at position 7`,
+			"-loc:US":            `"loc:" can only be combined with "&" at position 2`,
+			"loc:US,gmk":         `unknown country "GMK" in "loc:" at position 5`,
+			"gmk | price<200":    `"price<200" can only be combined with "&" at position 7`,
+			"loc: US":            `expected a location after "loc:" such as loc:US or loc:CA,US-NY at position 5`,
+			"price<200":          "alert needs a keyword besides its constraints at position 1",
+			"gmk & price<€90usd": `"price<€90usd" has two currencies at position 7`,
+			"loc:US & price>5":   "alert needs a keyword besides its constraints at position 1",
+		}
+		for input, expect := range cases {
+			_, err := Parse(input)
+			if err == nil {
+				t.Errorf("%q: expected error", input)
+				continue
+			}
+			if got := err.Error(); got != expect {
+				t.Errorf("%q: got %q expect %q", input, got, expect)
+			}
+		}
+	})
+}
+
+func TestSplitAlerts(t *testing.T) {
+	t.Run("space separated alerts", func(t *testing.T) {
+		got, err := SplitAlerts(`gmk,dandy,-daisy kaze (gmk | sa) & "olivia dark"`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expect := []string{"gmk,dandy,-daisy", "kaze", `(gmk | sa) & "olivia dark"`}
+		if !reflect.DeepEqual(got, expect) {
+			t.Errorf("got %q expect %q", got, expect)
+		}
+	})
+	t.Run("constraints stay with their alert", func(t *testing.T) {
+		cases := map[string][]string{
+			"gmk & olivia price<200":   {"gmk & olivia price<200"},
+			`"gmk olivia" price<200`:   {`"gmk olivia" price<200`},
+			"kaze loc:US,CA price>=50": {"kaze loc:US,CA price>=50"},
+		}
+		for input, expect := range cases {
+			got, err := SplitAlerts(input)
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", input, err)
+				continue
+			}
+			if !reflect.DeepEqual(got, expect) {
+				t.Errorf("%q: got %q expect %q", input, got, expect)
+			}
+		}
+		if _, err := SplitAlerts("price<200"); err == nil {
+			t.Errorf("expected error for an alert of only constraints")
+		}
+	})
+	t.Run("rejects constraints of several alerts", func(t *testing.T) {
+		for _, input := range []string{"gmk olivia price<200", "price<200 loc:US gmk olivia"} {
+			_, err := SplitAlerts(input)
+			if err == nil {
+				t.Errorf("%q: expected error", input)
+				continue
+			}
+			if !strings.Contains(err.Error(), `join the keywords with "&"`) {
+				t.Errorf("%q: error doesn't explain how to join keywords: %v", input, err)
+			}
+		}
+		_, err := SplitAlerts("gmk olivia price<200")
+		if err == nil || !strings.Contains(err.Error(), `"gmk & olivia price<200"`) {
+			t.Errorf("got error %v expect suggestion of \"gmk & olivia price<200\"", err)
+		}
+		q, err := Parse("gmk & olivia price<200")
+		if err != nil || len(q.Prices) != 1 {
+			t.Errorf("suggested alert doesn't parse with its price bound: %v", err)
+		}
+	})
+	t.Run("rejects malformed alert", func(t *testing.T) {
+		_, err := SplitAlerts("kaze (gmk | sa")
+		if err == nil {
+			t.Errorf("expected error")
+		}
+	})
+}
+
+func TestMatchedTerms(t *testing.T) {
+	q, err := Parse(`(gmk | sa) & olivia & -red & title:"olivia"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := Fields{FieldContent: "GMK Olivia++ base", FieldTitle: "[US-CA][H] GMK Olivia"}
+	expect := []string{"gmk", "olivia", `title:"olivia"`}
+	if got := q.MatchedTerms(fields); !reflect.DeepEqual(got, expect) {
+		t.Errorf("got %q expect %q", got, expect)
+	}
+}
+
+func TestQueryPrice(t *testing.T) {
+	const content = "GMK Olivia $220 shipped\nKaze keyboard 150 USD\nRed Olivia deskmat $30"
+	cases := map[string]bool{
+		"kaze & price<200":          true,
+		"olivia & -red & price<200": false,
+		"olivia & price>=200":       true,
+		"deskmat & price<=30":       true,
+		"gmk & price<10":            false,
+	}
+	for alert, expect := range cases {
+		q, err := Parse(alert)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, found := q.Price(content)
+		if got := q.MatchPrice(p, found); got != expect {
+			t.Errorf("%q: got %t (price %q) expect %t", alert, got, p.Text, expect)
+		}
+	}
+	t.Run("currencies", func(t *testing.T) {
+		const content = "GMK Olivia €150\nKaze keyboard 180 CAD"
+		cases := map[string]bool{
+			"olivia & price<200":    false, // Not converted from EUR
+			"olivia & price<200eur": true,
+			"kaze & price<200cad":   true,
+			"kaze & price<€200":     false,
+		}
+		for alert, expect := range cases {
+			q, err := Parse(alert)
+			if err != nil {
+				t.Errorf("%q: %v", alert, err)
+				continue
+			}
+			if got := q.MatchPrice(q.Price(content)); got != expect {
+				t.Errorf("%q: got %t expect %t", alert, got, expect)
+			}
+		}
+	})
+	t.Run("no price found", func(t *testing.T) {
+		q, _ := Parse("gmk & price<200")
+		if q.MatchPrice(q.Price("GMK Olivia, offers")) {
+			t.Errorf("expected no match without a price")
+		}
+	})
+}
@@ -0,0 +1,84 @@
+package listing
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Listing is the structured form of an r/mechmarket title, which follows the
+// "[LOC][H] what they have [W] what they want" convention.
+type Listing struct {
+	Location string // Location tag as written, upper-cased, e.g. "USA-CA"
+	Country  string // Normalized country, e.g. "US"
+	Region   string // Normalized region, e.g. "CA", empty when only a country is given
+	Have     string
+	Want     string
+}
+
+// Place returns the normalized location of the listing.
+func (l Listing) Place() Location {
+	return Location{Country: l.Country, Region: l.Region}
+}
+
+// IsTrade reports whether the title followed the [H]/[W] convention.
+func (l Listing) IsTrade() bool {
+	return l.Have != "" || l.Want != ""
+}
+
+var tag_pattern = regexp.MustCompile(`\[([^\[\]]*)\]`)
+
+// ParseTitle extracts the location and the have and want sides of a title.
+// Missing parts are left empty. Titles without [H] or [W] tags, such as
+// "[IC]" or "[Vendor]" posts, have no location either.
+func ParseTitle(title string) Listing {
+	var l Listing
+	tags := tag_pattern.FindAllStringSubmatchIndex(title, -1)
+	location := ""
+
+	for i, tag := range tags {
+		name := tag_name(title, tag)
+		if name != "H" && name != "W" {
+			if location == "" && !l.IsTrade() && is_location(name) {
+				location = name
+			}
+			continue
+		}
+
+		// A side runs until the next [H]/[W] tag, so other tags like
+		// "[Sealed]" stay part of it
+		end := len(title)
+		for _, next := range tags[i+1:] {
+			if n := tag_name(title, next); n == "H" || n == "W" {
+				end = next[0]
+				break
+			}
+		}
+		side := strings.TrimSpace(title[tag[1]:end])
+		if name == "H" && l.Have == "" {
+			l.Have = side
+		} else if name == "W" && l.Want == "" {
+			l.Want = side
+		}
+	}
+
+	if l.IsTrade() && location != "" {
+		l.Location = location
+		if place, err := ParseLocation(location); err == nil {
+			l.Country, l.Region = place.Country, place.Region
+		} else {
+			l.Country, l.Region, _ = strings.Cut(location, "-")
+		}
+	}
+	return l
+}
+
+func tag_name(title string, tag []int) string {
+	return strings.ToUpper(strings.ReplaceAll(title[tag[2]:tag[3]], " ", ""))
+}
+
+var location_pattern = regexp.MustCompile(`^[A-Z]{2,3}(-[A-Z0-9]{1,3})?$`)
+
+// Location tags look like "US", "US-CA" or "EU-DE"
+func is_location(tag string) bool {
+	return location_pattern.MatchString(tag)
+}
@@ -0,0 +1,78 @@
+package listing
+
+import "testing"
+
+func TestParseTitle(t *testing.T) {
+	cases := map[string]Listing{
+		"[US-CA][H] GMK Olivia [W] PayPal": {
+			Location: "US-CA", Country: "US", Region: "CA", Have: "GMK Olivia", Want: "PayPal",
+		},
+		"[eu-de] [h] Kaze, SA Bliss [Sealed] [w] PayPal, Local Cash": {
+			Location: "EU-DE", Country: "DE", Have: "Kaze, SA Bliss [Sealed]", Want: "PayPal, Local Cash",
+		},
+		"[UK][W] GMK Dandy [H] PayPal": {
+			Location: "UK", Country: "GB", Have: "PayPal", Want: "GMK Dandy",
+		},
+		"[US - NY][H] Paypal": {
+			Location: "US-NY", Country: "US", Region: "NY", Have: "Paypal",
+		},
+		"[USA-TX][H] Paypal [W] Keyboard": {
+			Location: "USA-TX", Country: "US", Region: "TX", Have: "Paypal", Want: "Keyboard",
+		},
+		"[IC] GMK Olivia Dark":         {},
+		"[Vendor] Restock of switches": {},
+		"Selling my keyboards":         {},
+	}
+	for title, expect := range cases {
+		got := ParseTitle(title)
+		if got != expect {
+			t.Errorf("%q: got %+v expect %+v", title, got, expect)
+		}
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	t.Run("normalizes aliases", func(t *testing.T) {
+		cases := map[string]Location{
+			"us-ca":  {Country: "US", Region: "CA"},
+			"USA":    {Country: "US"},
+			"EU-DE":  {Country: "DE"},
+			"UK":     {Country: "GB"},
+			"CAN-ON": {Country: "CA", Region: "ON"},
+			"EU":     {Country: "EU"},
+		}
+		for tag, expect := range cases {
+			got, err := ParseLocation(tag)
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", tag, err)
+			} else if got != expect {
+				t.Errorf("%q: got %+v expect %+v", tag, got, expect)
+			}
+		}
+	})
+	t.Run("rejects unknown locations", func(t *testing.T) {
+		for _, tag := range []string{"XX", "US-ZZ", "EU-US", "GMK"} {
+			if _, err := ParseLocation(tag); err == nil {
+				t.Errorf("%q: expected error", tag)
+			}
+		}
+	})
+	t.Run("contains", func(t *testing.T) {
+		cases := []struct {
+			filter, listing Location
+			expect          bool
+		}{
+			{Location{Country: "US"}, Location{Country: "US", Region: "CA"}, true},
+			{Location{Country: "US", Region: "CA"}, Location{Country: "US", Region: "NY"}, false},
+			{Location{Country: "US", Region: "CA"}, Location{Country: "US"}, false},
+			{Location{Country: "EU"}, Location{Country: "DE"}, true},
+			{Location{Country: "EU"}, Location{Country: "CA"}, false},
+			{Location{Country: "CA"}, Location{}, false},
+		}
+		for _, c := range cases {
+			if got := c.filter.Contains(c.listing); got != c.expect {
+				t.Errorf("%v contains %v: got %t expect %t", c.filter, c.listing, got, c.expect)
+			}
+		}
+	})
+}
@@ -0,0 +1,148 @@
+package listing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Location is a normalized country code and optional region, e.g. US-CA.
+type Location struct {
+	Country string // ISO 3166 alpha-2 code, or "EU" for Europe as a whole
+	Region  string // State or province code, empty when unknown
+}
+
+func (l Location) String() string {
+	if l.Region == "" {
+		return l.Country
+	}
+	return l.Country + "-" + l.Region
+}
+
+// IsZero reports whether the location is unknown.
+func (l Location) IsZero() bool {
+	return l.Country == ""
+}
+
+// Contains reports whether o lies within l. "EU" contains every European
+// country and a country contains all of its regions.
+func (l Location) Contains(o Location) bool {
+	if o.IsZero() {
+		return false
+	}
+	if l.Country == "EU" {
+		return o.Country == "EU" || europe[o.Country]
+	}
+	return l.Country == o.Country && (l.Region == "" || l.Region == o.Region)
+}
+
+// ParseLocation normalizes a location tag such as "USA-CA", "EU-DE" or "UK".
+func ParseLocation(tag string) (Location, error) {
+	tag = strings.ToUpper(strings.ReplaceAll(tag, " ", ""))
+	country, region, _ := strings.Cut(tag, "-")
+
+	if alias, ok := country_aliases[country]; ok {
+		country = alias
+	}
+	if !countries[country] {
+		return Location{}, fmt.Errorf("unknown country %q", country)
+	}
+
+	// "EU-DE" is used on mechmarket for a country within Europe
+	if country == "EU" && region != "" {
+		inner, err := ParseLocation(region)
+		if err != nil || !europe[inner.Country] {
+			return Location{}, fmt.Errorf("unknown European country %q", region)
+		}
+		return inner, nil
+	}
+
+	if known, ok := regions[country]; ok && region != "" && !known[region] {
+		return Location{}, fmt.Errorf("unknown region %q for %s", region, country)
+	}
+	return Location{Country: country, Region: region}, nil
+}
+
+// ParseLocations parses a comma separated list of locations, e.g. "CA,US-NY".
+func ParseLocations(list string) ([]Location, error) {
+	var locations []Location
+	for _, tag := range strings.Split(list, ",") {
+		if strings.TrimSpace(tag) == "" {
+			return nil, fmt.Errorf("empty location in %q", list)
+		}
+		l, err := ParseLocation(tag)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, l)
+	}
+	return locations, nil
+}
+
+var country_aliases = map[string]string{
+	"USA": "US",
+	"CAN": "CA",
+	"UK":  "GB",
+	"ENG": "GB",
+	"GBR": "GB",
+	"AUS": "AU",
+	"NZL": "NZ",
+	"GER": "DE",
+	"DEU": "DE",
+	"FRA": "FR",
+	"NLD": "NL",
+	"SWE": "SE",
+	"ESP": "ES",
+	"ITA": "IT",
+	"POL": "PL",
+	"MEX": "MX",
+	"SGP": "SG",
+	"JPN": "JP",
+	"KOR": "KR",
+	"PHL": "PH",
+	"MYS": "MY",
+}
+
+var europe = map[string]bool{
+	"AT": true, "BE": true, "BG": true, "CH": true, "CY": true, "CZ": true,
+	"DE": true, "DK": true, "EE": true, "ES": true, "FI": true, "FR": true,
+	"GB": true, "GR": true, "HR": true, "HU": true, "IE": true, "IS": true,
+	"IT": true, "LT": true, "LU": true, "LV": true, "MT": true, "NL": true,
+	"NO": true, "PL": true, "PT": true, "RO": true, "RS": true, "SE": true,
+	"SI": true, "SK": true, "UA": true,
+}
+
+var countries = func() map[string]bool {
+	m := map[string]bool{
+		"EU": true,
+		"US": true, "CA": true, "MX": true, "BR": true, "AR": true, "CL": true,
+		"CO": true, "PE": true, "AU": true, "NZ": true, "JP": true, "KR": true,
+		"CN": true, "HK": true, "TW": true, "SG": true, "MY": true, "PH": true,
+		"TH": true, "VN": true, "ID": true, "IN": true, "AE": true, "IL": true,
+		"TR": true, "RU": true, "ZA": true,
+	}
+	for c := range europe {
+		m[c] = true
+	}
+	return m
+}()
+
+var regions = map[string]map[string]bool{
+	"US": set(
+		"AL", "AK", "AZ", "AR", "CA", "CO", "CT", "DE", "DC", "FL", "GA", "HI",
+		"ID", "IL", "IN", "IA", "KS", "KY", "LA", "ME", "MD", "MA", "MI", "MN",
+		"MS", "MO", "MT", "NE", "NV", "NH", "NJ", "NM", "NY", "NC", "ND", "OH",
+		"OK", "OR", "PA", "PR", "RI", "SC", "SD", "TN", "TX", "UT", "VT", "VA",
+		"WA", "WV", "WI", "WY",
+	),
+	"CA": set(
+		"AB", "BC", "MB", "NB", "NL", "NS", "NT", "NU", "ON", "PE", "QC", "SK", "YT",
+	),
+}
+
+func set(codes ...string) map[string]bool {
+	m := make(map[string]bool, len(codes))
+	for _, c := range codes {
+		m[c] = true
+	}
+	return m
+}
@@ -1,28 +1,67 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"mechfeed/channels"
-	"mechfeed/discord-portal"
+	"mechfeed/delivery"
+	"mechfeed/digest"
 	"mechfeed/filter"
 	"mechfeed/notifications"
-	"mechfeed/reddit-portal"
+	"mechfeed/pipeline"
+	"mechfeed/sources"
 	"mechfeed/users"
 	"mechfeed/bot"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	// Timezones of digest users, even without a system zoneinfo
+	_ "time/tzdata"
 
+	// Sources register themselves on import
+	_ "mechfeed/discord-portal"
+	redditportal "mechfeed/reddit-portal"
+
+	"github.com/bwmarrin/discordgo"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 var (
-	DISCORD_CHANNELS    = make(map[string]Channel) // Discord channels indexed by channel ID
-	DISCORD_SERVERS     = make(map[string]Server)  // Discord servers indexed by channel ID
-	DISCORD_WEBHOOK_URL string
+	DISCORD_WEBHOOK_URL           string
 	PUBLIC_MECHMARKET_WEBHOOK_URL string
+	HTTP_ADDR                     string              // Serves unsubscribe links when set
+	ALERT_INDEX                   = filter.NewIndex() // Compiled user alerts
+	QUEUE                         *delivery.Queue     // Outgoing notifications
+	DIGESTS                       digest.DBStore      // Notifications waiting for a digest
+)
+
+// Events from a source are only re-notified to a user after this long
+const DEDUPE_TTL = time.Hour * 24
+
+// Crossposts of a listing within CROSSPOST_WINDOW are notified once. Listings
+// of sellers who crosspost wait up to CROSSPOST_HOLD for the crosspost.
+const (
+	CROSSPOST_WINDOW = time.Hour * 12
+	CROSSPOST_HOLD   = time.Second * 90
 )
 
+// How often users are checked for due digests
+const DIGEST_INTERVAL = time.Minute
+
+// How often webhook rate limit stats are logged
+const STATS_INTERVAL = time.Hour
+
+// How long shutdown waits for sources to stop and notifications to be sent
+const SHUTDOWN_TIMEOUT = time.Second * 10
+
 func load_config() error {
 	godotenv.Load()
 	DISCORD_WEBHOOK_URL = os.Getenv("DISCORD_WEBHOOK")
@@ -35,165 +74,467 @@ func load_config() error {
 		log.Println("no webhook for mechmarket channel found")
 	}
 
-	for _, server := range ServerList {
-		for _, channel := range server.Channels {
-			DISCORD_CHANNELS[channel.ID] = channel
-			DISCORD_SERVERS[channel.ID] = server
-		}
+	notifications.Email = notifications.EmailConfig{
+		Addr:              os.Getenv("SMTP_ADDR"),
+		From:              os.Getenv("SMTP_FROM"),
+		Username:          os.Getenv("SMTP_USERNAME"),
+		Password:          os.Getenv("SMTP_PASSWORD"),
+		StartTLS:          os.Getenv("SMTP_STARTTLS"),
+		UnsubscribeURL:    os.Getenv("UNSUBSCRIBE_URL"),
+		UnsubscribeSecret: []byte(os.Getenv("UNSUBSCRIBE_SECRET")),
+	}
+	switch notifications.Email.StartTLS {
+	case "", notifications.StartTLSAuto, notifications.StartTLSRequire, notifications.StartTLSOff:
+	default:
+		return fmt.Errorf("SMTP_STARTTLS must be %s, %s or %s", notifications.StartTLSAuto, notifications.StartTLSRequire, notifications.StartTLSOff)
+	}
+	if notifications.Email.Addr == "" {
+		log.Println("no SMTP server found, email notifications are disabled")
+	} else if notifications.Email.UnsubscribeURL == "" || len(notifications.Email.UnsubscribeSecret) == 0 {
+		log.Println("no UNSUBSCRIBE_URL or UNSUBSCRIBE_SECRET found, emails won't have unsubscribe links")
+	}
+
+	HTTP_ADDR = os.Getenv("HTTP_ADDR")
+
+	notifications.DefaultTelegramClient.Token = os.Getenv("TELEGRAM_TOKEN")
+	if notifications.DefaultTelegramClient.Token == "" {
+		log.Println("no telegram token found, telegram notifications are disabled")
 	}
 	return nil
 }
 
 func main() {
+	os.Exit(run())
+}
+
+// Runs mechfeed until SIGINT or SIGTERM and returns the exit status
+func run() int {
 	// Load env
 	if err := load_config(); err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return 1
 	}
 
+	// Cancelled on the first SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Get user DB connection
 	repo, err := users.DBConnection()
 	if err != nil {
-		log.Fatal(err)
+		log.Println(err)
+		return 1
 	}
-	defer repo.Db.Close()
+	defer repo.Close()
 
-	// Mechfeed client discord bot
-	go bot.MechfeedBot()
+	// Keep alerts in memory and the alert index in sync with them
+	cache := users.NewAlertCache(repo)
+	cache.OnChange(func(snap users.Snapshot) {
+		sync_alert_index(cache, snap)
+	})
+	if err := cache.Start(ctx); err != nil {
+		log.Println(err)
+		return 1
+	}
 
-	// Wrapped goroutines for Discord & Reddit monitors 
-	go portal_supervisor(discordportal.Listen, "discordportal", time.Millisecond*300)
-	go portal_supervisor(redditportal.Monitor, "redditportal", time.Millisecond*300)
+	// Deliver queued notifications, including those left over from the last run
+	QUEUE = delivery.NewQueue(delivery.DBStore{Queries: repo.Queries})
+	QUEUE.Handle(delivery.KindDM, delivery.DM)
+	QUEUE.Handle(delivery.KindWebhook, delivery.Webhook)
+	QUEUE.Handle(delivery.KindDigest, delivery.Digest)
+	QUEUE.Handle(delivery.KindSlack, delivery.Webhook)
+	QUEUE.Handle(delivery.KindJSON, delivery.Webhook)
+	QUEUE.Handle(delivery.KindNtfy, delivery.Webhook)
+	QUEUE.Handle(delivery.KindEmail, delivery.Email)
+	QUEUE.Handle(delivery.KindTelegram, delivery.Telegram)
+	queue_ctx, stop_queue := context.WithCancel(context.Background())
+	queue_done := make(chan struct{})
+	go func() {
+		QUEUE.Run(queue_ctx)
+		close(queue_done)
+	}()
 
-	for {
-		select {
-		case discord_msg := <-channels.DiscordChannel:
-			go discord_handler(repo, discord_msg)
+	go log_webhook_stats(ctx)
 
-		case reddit_msg := <-channels.RedditChannel:
-			go reddit_handler(repo, reddit_msg)
-		}
+	// Queue digests of hourly and daily users as they become due
+	DIGESTS = digest.DBStore{DB: repo.Db, Queries: repo.Queries}
+	scheduler := &digest.Scheduler{
+		Store: DIGESTS,
+		Users: cache.Users,
+		Send: func(ctx context.Context, q *users.Queries, user users.User, destination int32, heading string, items []users.DigestItem) error {
+			return queue_digest(ctx, q, user, cache.Destinations(user.ID), destination, heading, items)
+		},
+		Interval: DIGEST_INTERVAL,
 	}
-}
+	go scheduler.Run(ctx)
 
-func portal_supervisor(portal func(), name string, restartDelay time.Duration) {
-	for {
-		func() {
-			defer func() {
-				if r := recover(); r != nil {
-					log.Printf("[ %s ] Crashed with error: %v. Restarting...\n", name, r)
-					time.Sleep(restartDelay)
-				}
-			}()
-			portal()
+	// Mechfeed client discord bot, kept open until notifications are drained
+	bot_ctx, stop_bot := context.WithCancel(context.Background())
+	bot_done := make(chan struct{})
+	go func() {
+		bot.MechfeedBot(bot_ctx)
+		close(bot_done)
+	}()
+
+	// Telegram chat linking and mute buttons
+	go bot.RunTelegram(ctx)
+
+	// Email unsubscribe links, UNSUBSCRIBE_URL is the public URL of
+	// /unsubscribe
+	if HTTP_ADDR != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/unsubscribe", bot.Unsubscribe)
+		server := &http.Server{Addr: HTTP_ADDR, Handler: mux, ReadHeaderTimeout: time.Second * 10}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Println("http server:", err)
+			}
 		}()
+		defer server.Close()
 	}
-}
 
-func discord_handler(r *users.Repository, msg channels.DiscordMessage) {
-	_, ok := DISCORD_CHANNELS[msg.ChannelID]
-	if !ok {
-		return // Channel not being monitored
+	// Every event goes through match -> filter -> dedupe -> notify
+	p := &pipeline.Pipeline{
+		Matcher: &pipeline.IndexMatcher{
+			Index: ALERT_INDEX,
+			Alert: cache.Alert,
+			User: func(id string) (users.User, error) {
+				return get_user(repo, cache, id)
+			},
+		},
+		Filters:  []pipeline.Filter{pipeline.PauseFilter{}, pipeline.ConstraintFilter{}, pipeline.IgnoreFilter{Ignored: cache.IgnoredAuthors}},
+		Dedupe:   pipeline.NewDedupe(DEDUPE_TTL),
+		Collapse: pipeline.NewCollapser(CROSSPOST_WINDOW, CROSSPOST_HOLD),
+		Notifiers: []pipeline.Notifier{pipeline.DestinationNotifier{
+			Destinations: cache.Destinations,
+			Send:         notify_destination,
+			Hold:         digest_notification,
+		}},
 	}
 
-	alerts, err := r.Queries.GetAlerts(r.Ctx)
-	if err != nil {
-		log.Println(err)
-		return
+	// Supervised goroutines for every registered source
+	var portals sync.WaitGroup
+	events := make(chan channels.Event)
+	for _, source := range sources.All() {
+		portals.Add(1)
+		go func(source sources.Source) {
+			defer portals.Done()
+			sources.Supervise(ctx, source, events, time.Millisecond*300)
+		}(source)
 	}
-	
-	for _, alert := range alerts {
-		// Notify user if alert matches
-		if filter.FilterKeywords(msg.Content, alert.Keyword) {
-			go discord_notify(r, msg, alert)
+
+	var inflight sync.WaitGroup
+	for ctx.Err() == nil {
+		select {
+		case event := <-events:
+			inflight.Add(1)
+			go func() {
+				defer inflight.Done()
+				event_handler(p, event)
+			}()
+		case <-ctx.Done():
 		}
-		
 	}
-}
 
-func reddit_handler(r *users.Repository, msg channels.RedditMessage) {
-	// Notify public mechmarket channel
-	notifications.SendWebhook(PUBLIC_MECHMARKET_WEBHOOK_URL, notifications.CreateNotificationReddit(msg))
+	// Restore default signal handling so a second signal exits immediately
+	stop()
+	log.Printf("Shutting down, waiting up to %v for notifications to be sent...", SHUTDOWN_TIMEOUT)
 
-	// User alerts
-	alerts, err := r.Queries.GetAlerts(r.Ctx)
-	if err != nil {
-		log.Println(err)
-		return
+	status := 0
+	deadline := time.Now().Add(SHUTDOWN_TIMEOUT)
+	if !wait(&portals, time.Until(deadline)) {
+		log.Println("timed out waiting for sources to stop")
+		status = 1
+	}
+	if !wait(&inflight, time.Until(deadline)) {
+		log.Println("timed out waiting for events to be handled")
+		status = 1
+	}
+	// Stop waiting for crossposts
+	p.Flush()
+
+	// Notifications not yet delivered stay queued for the next run
+	stop_queue()
+	select {
+	case <-queue_done:
+	case <-time.After(time.Until(deadline)):
+		log.Println("timed out waiting for notifications to be sent")
+		status = 1
 	}
 
-	for _, alert := range alerts {
-		// Notify user if alert matches
-		if filter.FilterKeywords(msg.Content, alert.Keyword) {
-			go reddit_notify(r, msg, alert)
+	stop_bot()
+	select {
+	case <-bot_done:
+	case <-time.After(time.Until(deadline)):
+		log.Println("timed out waiting for bot to close")
+		status = 1
+	}
+	log.Println("Webhooks:", notifications.DefaultWebhookClient.Stats())
+	log.Println("Other webhooks:", notifications.DefaultHookClient.Stats())
+	log.Println("Shutdown complete")
+	return status
+}
+
+func log_webhook_stats(ctx context.Context) {
+	ticker := time.NewTicker(STATS_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			log.Println("Webhooks:", notifications.DefaultWebhookClient.Stats())
+			log.Println("Other webhooks:", notifications.DefaultHookClient.Stats())
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-func discord_notify(r *users.Repository, msg channels.DiscordMessage, alert users.UserAlert) {
-	msg_server := DISCORD_SERVERS[msg.ChannelID]
-	msg_channel := DISCORD_CHANNELS[msg.ChannelID]
+// Reports whether wg finished within timeout
+func wait(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
 
-	// Get user that set alert
-	user, err := r.Queries.GetUser(r.Ctx, alert.ID)
-	if err != nil {
-		log.Println("failed to fetch user: ", alert.ID, " , error: ", err)
-		return
+func event_handler(p *pipeline.Pipeline, event channels.Event) {
+	// Notify public mechmarket channel
+	if event.Source == redditportal.SOURCE_NAME && PUBLIC_MECHMARKET_WEBHOOK_URL != "" {
+		err := QUEUE.Enqueue(context.Background(), delivery.Notification{
+			Kind:    delivery.KindWebhook,
+			Target:  PUBLIC_MECHMARKET_WEBHOOK_URL,
+			Payload: notifications.CreateWebhookNotification(PUBLIC_MECHMARKET_WEBHOOK_URL, event, "", ""),
+		})
+		if err != nil {
+			log.Println("failed to queue mechmarket webhook:", err)
+		}
 	}
+	p.Handle(event)
+}
 
-	// Skip alert if message author is ignored
-	for _, u := range alert.Ignored {
-		if u == msg.Author.Username {
-			log.Printf("Skipping alert... '%s' is ignored by %s", u, user.Username)
-			return
+// Re-syncs the alert index when the next alert pause runs out
+var resume struct {
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// Indexes the alerts that aren't paused
+func sync_alert_index(cache *users.AlertCache, snap users.Snapshot) {
+	now := time.Now()
+	var next time.Time
+	keywords := make(map[int32]string, len(snap.Alerts))
+	for _, alert := range snap.Alerts {
+		if alert.PausedAt(now) {
+			if !alert.Paused && (next.IsZero() || alert.PausedUntil.Time.Before(next)) {
+				next = alert.PausedUntil.Time
+			}
+			continue
 		}
+		keywords[alert.AlertID] = alert.Keyword
 	}
+	ALERT_INDEX.Sync(keywords)
 
-	// Send DM notification
-	log.Println("Sending Discord notification via DM to user:", user.Username, "Keyword:", alert.Keyword, "Message:", msg)
-	bot.IsolatedSendEmbedDM(
-		user.ID, 
-		notifications.CreateDiscordNotificationMessageEmbed(msg_server.Name, msg_channel.Name, alert.Keyword, msg),
-	)
+	resume.mu.Lock()
+	defer resume.mu.Unlock()
+	if resume.timer != nil {
+		resume.timer.Stop()
+	}
+	if !next.IsZero() {
+		resume.timer = time.AfterFunc(time.Until(next), func() {
+			sync_alert_index(cache, cache.Snapshot())
+		})
+	}
+}
 
-	// Send webhook notification if user opted in
-	if user.WebhookUrl.Valid {
-		log.Println("Notifying user through webhook:", user.WebhookUrl)
-		notifications.SendWebhook(
-			user.WebhookUrl.String, 
-			notifications.CreateNotificationDiscord(
-				msg_server.Name, msg_channel.Name, alert.Keyword, msg,
-			),
-		)
+// Cached user, falling back to the DB for users the cache hasn't seen yet
+func get_user(r *users.Repository, cache *users.AlertCache, id string) (users.User, error) {
+	if user, ok := cache.User(id); ok {
+		return user, nil
 	}
+	return r.Queries.GetUser(r.Ctx, id)
 }
 
+// Queues the notification for the destination, see pipeline.DestinationNotifier
+// for those held for digests and quiet hours
+func notify_destination(ev channels.Event, m pipeline.Match, d users.UserDestination) error {
+	alert, price := m.Alert.Keyword, m.Price.Text
+	switch d.Kind {
+	case users.DestinationDM:
+		return dm_notify(ev, m)
+	case users.DestinationDiscord, users.DestinationSlack:
+		// Slack webhooks get Slack's format whatever their kind, such as
+		// those saved as users.webhook_url before destinations
+		if d.Kind == users.DestinationSlack || notifications.WebhookFormat(d.Target) == notifications.FormatSlack {
+			return queue_notification(ev, m, delivery.KindSlack, d.Target, notifications.CreateSlackNotification(ev, alert, price))
+		}
+		return queue_notification(ev, m, delivery.KindWebhook, d.Target, notifications.CreateNotification(ev, alert, price))
+	case users.DestinationJSON:
+		return queue_notification(ev, m, delivery.KindJSON, d.Target, notifications.CreateJSONNotification(ev, alert, price))
+	case users.DestinationNtfy:
+		server, topic, err := notifications.ParseNtfyTarget(d.Target)
+		if err != nil {
+			return err
+		}
+		return queue_notification(ev, m, delivery.KindNtfy, server, notifications.CreateNtfyNotification(ev, topic, alert, price))
+	case users.DestinationEmail:
+		return queue_notification(ev, m, delivery.KindEmail, d.Target, notifications.CreateEmailNotification(ev, alert, price, notifications.UnsubscribeLink(m.Alert.AlertID, d.DestinationID)))
+	case users.DestinationTelegram:
+		return queue_notification(ev, m, delivery.KindTelegram, d.Target, notifications.CreateTelegramNotification(ev, alert, price, m.Alert.AlertID))
+	}
+	return errors.New("unknown destination kind")
+}
 
-func reddit_notify(r *users.Repository, msg channels.RedditMessage, alert users.UserAlert) {
-	// Get user that set alert
-	user, err := r.Queries.GetUser(r.Ctx, alert.ID)
+// Queue DM notification
+func dm_notify(ev channels.Event, m pipeline.Match) error {
+	log.Println("Queueing DM notification to user:", m.User.Username, "Keyword:", m.Alert.Keyword, "Source:", ev.Source, "ID:", ev.ID)
+	author_id := ev.Author.ID
+	if author_id == "" {
+		author_id = ev.Author.Username
+	}
+	return queue_notification(ev, m, delivery.KindDM, m.User.ID, bot.DMNotification{
+		Embed:      notifications.CreateNotificationMessageEmbed(ev, m.Alert.Keyword, m.Price.Text),
+		AlertID:    m.Alert.AlertID,
+		Source:     ev.Source,
+		AuthorID:   author_id,
+		AuthorName: ev.Author.Username,
+		URL:        ev.URL,
+	})
+}
+
+// Records the notification in the user's history and queues it for delivery
+func queue_notification(ev channels.Event, m pipeline.Match, kind, target string, payload interface{}) error {
+	repo, err := users.DBConnection()
+	if err != nil {
+		return err
+	}
+	history_id, err := record_history(repo, ev, m, kind)
 	if err != nil {
-		log.Println("failed to fetch user: ", alert.ID, " , error: ", err)
-		return
+		return err
 	}
+	return QUEUE.Enqueue(repo.Ctx, delivery.Notification{
+		UserID:    m.User.ID,
+		AlertID:   m.Alert.AlertID,
+		Kind:      kind,
+		Target:    target,
+		Payload:   payload,
+		HistoryID: history_id,
+	})
+}
 
-	// Skip alert if message author is ignored
-	for _, u := range alert.Ignored {
-		if u == msg.Author {
-			log.Printf("Skipping alert... '%s' is ignored by %s", u, user.Username)
-			return
-		}
+// Records the notification in the user's history and saves it for the
+// destination's part of their next digest
+func digest_notification(ev channels.Event, m pipeline.Match, d users.UserDestination) error {
+	log.Println("Saving digest notification for user:", m.User.Username, "Destination:", d.Name, "Keyword:", m.Alert.Keyword, "Source:", ev.Source, "ID:", ev.ID)
+	repo, err := users.DBConnection()
+	if err != nil {
+		return err
 	}
+	history_id, err := record_history(repo, ev, m, delivery.KindDigest)
+	if err != nil {
+		return err
+	}
+
+	title := ev.Title
+	if title == "" {
+		title, _, _ = strings.Cut(strings.TrimSpace(ev.Body), "\n")
+	}
+	place := ev.Server
+	if ev.Channel != "" {
+		place += " #" + ev.Channel
+	}
+	return DIGESTS.Add(repo.Ctx, users.AddDigestItemParams{
+		ID:            m.User.ID,
+		AlertID:       sql.NullInt32{Int32: m.Alert.AlertID, Valid: true},
+		HistoryID:     sql.NullInt64{Int64: history_id, Valid: true},
+		Keyword:       m.Alert.Keyword,
+		Source:        ev.Source,
+		Title:         title,
+		Url:           ev.URL,
+		Place:         place,
+		Price:         m.Price.Text,
+		DestinationID: sql.NullInt32{Int32: d.DestinationID, Valid: true},
+	})
+}
 
-	// Send DM notification
-	log.Println("Sending Reddit notification via DM to user:", user.Username, "Keyword:", alert.Keyword, "Message:", msg)
-	bot.IsolatedSendEmbedDM(
-		user.ID, 
-		notifications.CreateRedditNotificationMessageEmbed(msg, alert.Keyword),
-	)
+// Queues the digest of items held for a destination in the transaction of q,
+// see digest.DBStore. Items of destinations since removed or disabled are
+// dropped.
+func queue_digest(ctx context.Context, q *users.Queries, user users.User, destinations []users.UserDestination, destination int32, heading string, items []users.DigestItem) error {
+	// Items held before destinations are for DMs
+	d := users.UserDestination{Kind: users.DestinationDM, Target: user.ID, Enabled: true}
+	if destination != 0 {
+		d = users.UserDestination{}
+		for _, dest := range destinations {
+			if dest.DestinationID == destination {
+				d = dest
+			}
+		}
+	}
+	if !d.Enabled {
+		log.Println("Dropping digest of", len(items), "items for removed or disabled destination", destination, "of user:", user.Username)
+		return nil
+	}
 
-	// Send webhook notification if user opted in
-	if user.WebhookUrl.Valid {
-		log.Println("Notifying user through webhook: ", user.WebhookUrl)
-		notifications.SendWebhook(user.WebhookUrl.String, notifications.CreateNotificationReddit(msg))
+	enqueue := func(kind, target string, payload interface{}) error {
+		return QUEUE.EnqueueIn(ctx, delivery.DBStore{Queries: q}, delivery.Notification{
+			UserID:  user.ID,
+			Kind:    kind,
+			Target:  target,
+			Payload: payload,
+		})
 	}
-}
\ No newline at end of file
+	text := digest.Text(heading, items, digest.Location(user.Timezone))
+	switch d.Kind {
+	case users.DestinationDM:
+		for _, embeds := range digest.Render(heading, items) {
+			if err := enqueue(delivery.KindDigest, user.ID, embeds); err != nil {
+				return err
+			}
+		}
+		return nil
+	case users.DestinationDiscord, users.DestinationSlack:
+		if d.Kind == users.DestinationSlack || notifications.WebhookFormat(d.Target) == notifications.FormatSlack {
+			return enqueue(delivery.KindSlack, d.Target, notifications.CreateSlackDigest(text))
+		}
+		for _, embeds := range digest.Render(heading, items) {
+			if err := enqueue(delivery.KindWebhook, d.Target, discordgo.WebhookParams{Username: "mechfeed", Embeds: embeds}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case users.DestinationJSON:
+		return enqueue(delivery.KindJSON, d.Target, notifications.CreateJSONDigest(text))
+	case users.DestinationNtfy:
+		server, topic, err := notifications.ParseNtfyTarget(d.Target)
+		if err != nil {
+			return err
+		}
+		return enqueue(delivery.KindNtfy, server, notifications.CreateNtfyDigest(text, topic))
+	case users.DestinationEmail:
+		return enqueue(delivery.KindEmail, d.Target, notifications.CreateEmailDigest(text))
+	case users.DestinationTelegram:
+		return enqueue(delivery.KindTelegram, d.Target, notifications.CreateTelegramDigest(text))
+	}
+	return errors.New("unknown destination kind")
+}
+
+func record_history(repo *users.Repository, ev channels.Event, m pipeline.Match, kind string) (int64, error) {
+	return repo.Queries.CreateHistory(repo.Ctx, users.CreateHistoryParams{
+		ID:           m.User.ID,
+		AlertID:      sql.NullInt32{Int32: m.Alert.AlertID, Valid: true},
+		Keyword:      m.Alert.Keyword,
+		Source:       ev.Source,
+		MessageID:    ev.ID,
+		Title:        ev.Title,
+		Url:          ev.URL,
+		MatchedTerms: m.Terms,
+		Delivery:     kind,
+	})
+}
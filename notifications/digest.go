@@ -0,0 +1,214 @@
+package notifications
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// ntfy turns longer messages into attachments
+const MAX_NTFY_MESSAGE = 4096
+
+// Slack allows 50 blocks per message
+const MAX_SLACK_BLOCKS = 50
+
+// Digest is a batch of matches held for a user's digest or quiet hours, for
+// destinations other than Discord, which get the embeds of digest.Render.
+type Digest struct {
+	Heading  string // e.g. "Your daily digest: 3 matches"
+	Matches  []DigestMatch
+	Location *time.Location // Of the user, for the times of matches
+}
+
+// DigestMatch is one match of a Digest.
+type DigestMatch struct {
+	Alert   string
+	Title   string
+	URL     string
+	Place   string // e.g. "r/mechmarket" or a Discord server and channel
+	Price   string
+	Matched time.Time
+}
+
+// One line per match, e.g. "• GMK Olivia · olivia · r/mechmarket · $150 · Jun 1 09:12",
+// with the title escaped and linked by escape and link
+func (d Digest) lines(escape func(string) string, link func(text, url string) string) []string {
+	loc := d.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	var lines []string
+	for _, m := range d.Matches {
+		title := Truncate(strings.ReplaceAll(m.Title, "\n", " "), 80)
+		if title == "" {
+			title = "Message"
+		}
+		if m.URL != "" {
+			title = link(title, m.URL)
+		} else {
+			title = escape(title)
+		}
+		parts := []string{m.Alert}
+		if m.Place != "" {
+			parts = append(parts, m.Place)
+		}
+		if m.Price != "" {
+			parts = append(parts, m.Price)
+		}
+		parts = append(parts, m.Matched.In(loc).Format("Jan 2 15:04"))
+		lines = append(lines, "• "+title+" · "+escape(strings.Join(parts, " · ")))
+	}
+	return lines
+}
+
+// Joins as many whole lines as fit in max bytes, as escapes and links can't
+// be cut in half, noting how many were left out
+func fit_lines(lines []string, max int) string {
+	var sb strings.Builder
+	for i, line := range lines {
+		more := ""
+		if left := len(lines) - i - 1; left > 0 {
+			more = fmt.Sprintf("\n… and %d more", left)
+		}
+		if sb.Len()+1+len(line)+len(more) > max {
+			fmt.Fprintf(&sb, "\n… and %d more", len(lines)-i)
+			break
+		}
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(line)
+	}
+	return strings.TrimPrefix(sb.String(), "\n")
+}
+
+// Splits lines into chunks of whole lines within max bytes
+func chunk_lines(lines []string, max int) []string {
+	var chunks []string
+	chunk := ""
+	for _, line := range lines {
+		if chunk != "" && len(chunk)+1+len(line) > max {
+			chunks = append(chunks, chunk)
+			chunk = ""
+		}
+		if chunk != "" {
+			chunk += "\n"
+		}
+		chunk += line
+	}
+	if chunk != "" {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+func CreateSlackDigest(d Digest) SlackNoti {
+	blocks := []SlackBlock{slack_section("*" + slack_escaper.Replace(d.Heading) + "*")}
+	lines := d.lines(slack_escaper.Replace, slack_link)
+	sent := 0
+	for _, chunk := range chunk_lines(lines, MAX_SLACK_TEXT_CHARS) {
+		n := strings.Count(chunk, "\n") + 1
+		// Last block notes what's left when more chunks don't fit
+		if len(blocks) == MAX_SLACK_BLOCKS-1 && sent+n < len(lines) {
+			blocks = append(blocks, slack_section(fmt.Sprintf("… and %d more", len(lines)-sent)))
+			break
+		}
+		blocks = append(blocks, slack_section(chunk))
+		sent += n
+	}
+	return SlackNoti{Text: d.Heading, Blocks: blocks}
+}
+
+func CreateNtfyDigest(d Digest, topic string) NtfyNoti {
+	return NtfyNoti{
+		Topic:   topic,
+		Title:   d.Heading,
+		Message: fit_lines(d.lines(plain_text, plain_link), MAX_NTFY_MESSAGE),
+		Tags:    []string{"keyboard"},
+	}
+}
+
+func CreateTelegramDigest(d Digest) TelegramNoti {
+	heading := "*" + EscapeMarkdownV2(d.Heading) + "*"
+	lines := d.lines(EscapeMarkdownV2, telegram_link)
+	return TelegramNoti{Text: heading + "\n" + fit_lines(lines, MAX_TELEGRAM_TEXT-len(heading)-1)}
+}
+
+var digest_template = template.Must(template.New("digest").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>{{.Heading}}</title>
+</head>
+<body style="margin:0;padding:16px;background:#f2f3f5;font-family:Helvetica,Arial,sans-serif;color:#2e3338">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" style="max-width:600px;margin:0 auto;background:#ffffff;border-left:4px solid #e671dc">
+<tr><td style="padding:16px">
+<h2 style="margin:0 0 12px;font-size:18px">{{.Heading}}</h2>
+<ul style="margin:0;padding:0 0 0 16px;font-size:14px">
+{{- range .Lines}}
+<li style="padding:2px 0">{{.}}</li>
+{{- end}}
+</ul>
+</td></tr>
+</table>
+<p style="max-width:600px;margin:12px auto;font-size:12px;color:#747f8d">mechfeed</p>
+</body>
+</html>
+`))
+
+// CreateEmailDigest renders a digest as text and HTML. Digests span several
+// alerts, so they have no unsubscribe link.
+func CreateEmailDigest(d Digest) EmailNoti {
+	lines := append([]string{d.Heading, ""}, d.lines(plain_text, plain_link)...)
+	lines = append(lines, "", "-- ", "mechfeed")
+
+	page := struct {
+		Heading string
+		Lines   []template.HTML
+	}{Heading: d.Heading}
+	for _, line := range d.lines(html.EscapeString, html_link) {
+		page.Lines = append(page.Lines, template.HTML(strings.TrimPrefix(line, "• ")))
+	}
+	var body strings.Builder
+	// Only fails on write errors, which strings.Builder doesn't have
+	digest_template.Execute(&body, page)
+
+	return EmailNoti{
+		Subject: d.Heading,
+		Text:    strings.Join(lines, "\r\n"),
+		HTML:    body.String(),
+	}
+}
+
+// JSONDigest is a digest posted to generic JSON webhooks.
+type JSONDigest struct {
+	Digest  string            `json:"digest"`
+	Matches []JSONDigestMatch `json:"matches"`
+}
+
+type JSONDigestMatch struct {
+	Alert   string    `json:"alert"`
+	Title   string    `json:"title,omitempty"`
+	URL     string    `json:"url,omitempty"`
+	Place   string    `json:"place,omitempty"`
+	Price   string    `json:"price,omitempty"`
+	Matched time.Time `json:"matched"`
+}
+
+func CreateJSONDigest(d Digest) JSONDigest {
+	noti := JSONDigest{Digest: d.Heading, Matches: []JSONDigestMatch{}}
+	for _, m := range d.Matches {
+		noti.Matches = append(noti.Matches, JSONDigestMatch{
+			Alert:   m.Alert,
+			Title:   m.Title,
+			URL:     m.URL,
+			Place:   m.Place,
+			Price:   m.Price,
+			Matched: m.Matched.UTC(),
+		})
+	}
+	return noti
+}
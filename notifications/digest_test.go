@@ -0,0 +1,75 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func test_digest(n int) Digest {
+	d := Digest{Heading: fmt.Sprintf("While you were away: %d matches", n)}
+	matched := time.Date(2024, 6, 1, 3, 12, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		d.Matches = append(d.Matches, DigestMatch{
+			Alert:   "olivia",
+			Title:   fmt.Sprintf("[US-CA][H] GMK Olivia #%d [W] PayPal", i),
+			URL:     fmt.Sprintf("https://reddit.com/r/mechmarket/%d", i),
+			Place:   "r/mechmarket",
+			Price:   "$150",
+			Matched: matched,
+		})
+	}
+	return d
+}
+
+func TestDigestLines(t *testing.T) {
+	d := test_digest(1)
+	d.Location = time.FixedZone("PDT", -7*60*60)
+	got := d.lines(plain_text, plain_link)[0]
+	expect := "• [US-CA][H] GMK Olivia #0 [W] PayPal (https://reddit.com/r/mechmarket/0) · olivia · r/mechmarket · $150 · May 31 20:12"
+	if got != expect {
+		t.Errorf("got %q expect %q", got, expect)
+	}
+}
+
+func TestFitLines(t *testing.T) {
+	lines := []string{"aaaa", "bbbb", "cccc", "dddd"}
+	if got := fit_lines(lines, 100); got != "aaaa\nbbbb\ncccc\ndddd" {
+		t.Errorf("got %q for lines that fit", got)
+	}
+	got := fit_lines(lines, 20)
+	if got != "aaaa\n… and 3 more" || len(got) > 20 {
+		t.Errorf("got %q for lines that don't fit", got)
+	}
+}
+
+func TestTelegramDigest(t *testing.T) {
+	n := CreateTelegramDigest(test_digest(200))
+	if len(n.Text) > MAX_TELEGRAM_TEXT {
+		t.Errorf("got %d characters expect at most %d", len(n.Text), MAX_TELEGRAM_TEXT)
+	}
+	if !strings.HasPrefix(n.Text, `*While you were away: 200 matches*`+"\n• [\\[US\\-CA\\]\\[H\\] GMK Olivia \\#0") {
+		t.Errorf("heading or first match not escaped: %q", n.Text[:80])
+	}
+	if !strings.Contains(n.Text, " more") {
+		t.Errorf("expected a count of the matches left out")
+	}
+}
+
+func TestSlackDigest(t *testing.T) {
+	n := CreateSlackDigest(test_digest(2000))
+	if len(n.Blocks) != MAX_SLACK_BLOCKS {
+		t.Fatalf("got %d blocks expect %d", len(n.Blocks), MAX_SLACK_BLOCKS)
+	}
+	shown := 0
+	for _, b := range n.Blocks[1 : len(n.Blocks)-1] {
+		if len(b.Text.Text) > MAX_SLACK_TEXT_CHARS {
+			t.Errorf("got block of %d characters", len(b.Text.Text))
+		}
+		shown += strings.Count(b.Text.Text, "\n") + 1
+	}
+	if last := n.Blocks[len(n.Blocks)-1].Text.Text; last != fmt.Sprintf("… and %d more", 2000-shown) {
+		t.Errorf("got last block %q with %d matches shown", last, shown)
+	}
+}
@@ -0,0 +1,394 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"mechfeed/channels"
+)
+
+// When emails are upgraded to TLS with STARTTLS
+const (
+	StartTLSAuto    = "auto"    // If the server supports it, the default
+	StartTLSRequire = "require" // Emails fail unless the server supports it
+	StartTLSOff     = "off"
+)
+
+// How long sending an email may take, from connecting to QUIT
+const EMAIL_TIMEOUT = time.Second * 30
+
+// EmailConfig is the SMTP server emails are sent through.
+type EmailConfig struct {
+	Addr     string // host:port, emails are disabled when empty
+	From     string
+	Username string // PLAIN auth when set, which needs TLS unless Addr is localhost
+	Password string
+	StartTLS string      // One of the StartTLS* modes
+	TLS      *tls.Config // Used by STARTTLS, verifies the Addr host when nil
+
+	// Notifications get one-click unsubscribe links when both are set
+	UnsubscribeURL    string // Public URL of bot.Unsubscribe
+	UnsubscribeSecret []byte // Signs the links
+}
+
+// Email is set by main from the SMTP_* and UNSUBSCRIBE_* env vars.
+var Email EmailConfig
+
+var (
+	ErrInvalidEmail           = errors.New("not an email address")
+	ErrEmailNotConfigured     = errors.New("email notifications aren't set up on this mechfeed")
+	ErrStartTLSUnsupported    = errors.New("SMTP server doesn't support STARTTLS")
+	ErrInvalidUnsubscribeLink = errors.New("invalid unsubscribe link")
+)
+
+// ValidateEmail checks that s is a bare email address, e.g. name@example.com.
+func ValidateEmail(s string) error {
+	addr, err := mail.ParseAddress(s)
+	if err != nil || addr.Address != s || addr.Name != "" {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// EmailNoti is an email with a plain text body, and an HTML alternative
+// unless HTML is empty.
+type EmailNoti struct {
+	Subject     string `json:"subject"`
+	Text        string `json:"text"`
+	HTML        string `json:"html,omitempty"`
+	Unsubscribe string `json:"unsubscribe,omitempty"` // One-click unsubscribe URL
+}
+
+type email_data struct {
+	Title       string
+	URL         string
+	Color       template.CSS
+	Fields      []email_field
+	Image       string
+	Unsubscribe string
+}
+
+type email_field struct {
+	Name  string
+	Value template.HTML
+}
+
+var email_template = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width">
+<title>{{.Title}}</title>
+</head>
+<body style="margin:0;padding:16px;background:#f2f3f5;font-family:Helvetica,Arial,sans-serif;color:#2e3338">
+<table role="presentation" width="100%" cellpadding="0" cellspacing="0" style="max-width:600px;margin:0 auto;background:#ffffff;border-left:4px solid {{.Color}}">
+<tr><td style="padding:16px">
+<h2 style="margin:0 0 12px;font-size:18px">{{if .URL}}<a href="{{.URL}}" style="color:#0068e0;text-decoration:none">{{.Title}}</a>{{else}}{{.Title}}{{end}}</h2>
+<table role="presentation" cellpadding="0" cellspacing="0" style="font-size:14px">
+{{- range .Fields}}
+<tr><td style="padding:2px 12px 2px 0;font-weight:bold;vertical-align:top;white-space:nowrap">{{.Name}}</td><td style="padding:2px 0;white-space:pre-wrap">{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- if .Image}}
+<p style="margin:12px 0 0"><img src="{{.Image}}" alt="" style="max-width:100%"></p>
+{{- end}}
+</td></tr>
+</table>
+<p style="max-width:600px;margin:12px auto;font-size:12px;color:#747f8d">mechfeed{{if .Unsubscribe}} &middot; <a href="{{.Unsubscribe}}" style="color:#747f8d">Unsubscribe from this alert</a>{{end}}</p>
+</body>
+</html>
+`))
+
+// CreateEmailNotification renders the fields of CreateNotificationMessageEmbed
+// as text and HTML. unsubscribe is the alert's UnsubscribeLink, if any.
+func CreateEmailNotification(data channels.Event, alert, price, unsubscribe string) EmailNoti {
+	subject := text_title(data)
+	if alert != "" {
+		subject = "[" + alert + "] " + subject
+	}
+
+	lines := []string{text_title(data)}
+	if data.URL != "" {
+		lines = append(lines, data.URL)
+	}
+	lines = append(lines, "")
+	lines = append(lines, text_fields(data, alert, price, plain_text, plain_link)...)
+	lines = append(lines, "", "-- ", "mechfeed")
+	if unsubscribe != "" {
+		lines = append(lines, "Unsubscribe from this alert: "+unsubscribe)
+	}
+
+	color, ok := SourceColors[data.Source]
+	if !ok {
+		color = DEFAULT_WEBHOOK_COLOR
+	}
+	page := email_data{
+		Title:       text_title(data),
+		URL:         data.URL,
+		Color:       template.CSS(fmt.Sprintf("#%06x", color)),
+		Unsubscribe: unsubscribe,
+	}
+	for _, f := range format_fields(data, alert, price, html.EscapeString, html_link) {
+		page.Fields = append(page.Fields, email_field{Name: f.Name, Value: template.HTML(f.Value)})
+	}
+	if len(data.Images) > 0 {
+		page.Image = data.Images[0]
+	}
+	var body strings.Builder
+	// Only fails on write errors, which strings.Builder doesn't have
+	email_template.Execute(&body, page)
+
+	return EmailNoti{
+		Subject:     subject,
+		Text:        strings.Join(lines, "\r\n"),
+		HTML:        body.String(),
+		Unsubscribe: unsubscribe,
+	}
+}
+
+// Link as an HTML anchor, or text if url isn't a web link
+func html_link(text, url string) string {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return html.EscapeString(text)
+	}
+	return `<a href="` + html.EscapeString(url) + `">` + html.EscapeString(text) + `</a>`
+}
+
+// UnsubscribeLink returns the link that stops alert_id from being sent to
+// the email destination destination_id, or "" if Email has no
+// UnsubscribeURL.
+func UnsubscribeLink(alert_id, destination_id int32) string {
+	if Email.UnsubscribeURL == "" || len(Email.UnsubscribeSecret) == 0 {
+		return ""
+	}
+	q := url.Values{}
+	q.Set("alert", strconv.Itoa(int(alert_id)))
+	q.Set("destination", strconv.Itoa(int(destination_id)))
+	q.Set("token", unsubscribe_token(alert_id, destination_id))
+	sep := "?"
+	if strings.Contains(Email.UnsubscribeURL, "?") {
+		sep = "&"
+	}
+	return Email.UnsubscribeURL + sep + q.Encode()
+}
+
+// ParseUnsubscribeLink returns the alert and destination of the query of a
+// link made by UnsubscribeLink.
+func ParseUnsubscribeLink(q url.Values) (alert_id, destination_id int32, err error) {
+	alert, err_alert := strconv.ParseInt(q.Get("alert"), 10, 32)
+	destination, err_destination := strconv.ParseInt(q.Get("destination"), 10, 32)
+	if err_alert != nil || err_destination != nil || len(Email.UnsubscribeSecret) == 0 {
+		return 0, 0, ErrInvalidUnsubscribeLink
+	}
+	expect := unsubscribe_token(int32(alert), int32(destination))
+	if !hmac.Equal([]byte(q.Get("token")), []byte(expect)) {
+		return 0, 0, ErrInvalidUnsubscribeLink
+	}
+	return int32(alert), int32(destination), nil
+}
+
+func unsubscribe_token(alert_id, destination_id int32) string {
+	mac := hmac.New(sha256.New, Email.UnsubscribeSecret)
+	fmt.Fprintf(mac, "unsubscribe:%d:%d", alert_id, destination_id)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// EmailConfigured reports whether there is an SMTP server to send emails with.
+func EmailConfigured() bool {
+	return Email.Addr != "" && Email.From != ""
+}
+
+// SendEmail sends n to the address to through the Email SMTP server, giving
+// up when ctx is done.
+func SendEmail(ctx context.Context, to string, n EmailNoti) error {
+	if !EmailConfigured() {
+		return ErrEmailNotConfigured
+	}
+	from, err := mail.ParseAddress(Email.From)
+	if err != nil {
+		return fmt.Errorf("SMTP_FROM: %w", err)
+	}
+	msg, err := email_message(from, to, n, time.Now())
+	if err != nil {
+		return err
+	}
+	return send_mail(ctx, Email, from.Address, to, msg)
+}
+
+// Formats n as a MIME message, multipart if it has HTML
+func email_message(from *mail.Address, to string, n EmailNoti, now time.Time) ([]byte, error) {
+	var msg bytes.Buffer
+	header := func(name, value string) {
+		msg.WriteString(name + ": " + value + "\r\n")
+	}
+	_, domain, _ := strings.Cut(from.Address, "@")
+	header("From", from.String())
+	header("To", to)
+	// Titles can span lines
+	header("Subject", mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(n.Subject), " ")))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", "<"+random_id()+"@"+domain+">")
+	header("MIME-Version", "1.0")
+	if n.Unsubscribe != "" {
+		// One-click unsubscribe, RFC 8058
+		header("List-Unsubscribe", "<"+n.Unsubscribe+">")
+		header("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+
+	if n.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		msg.WriteString("\r\n")
+		if err := write_quoted_printable(&msg, n.Text); err != nil {
+			return nil, err
+		}
+		return msg.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&msg)
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": parts.Boundary()}))
+	msg.WriteString("\r\n")
+	// Clients show the last part they support
+	for _, part := range []struct{ content_type, body string }{
+		{"text/plain; charset=utf-8", n.Text},
+		{"text/html; charset=utf-8", n.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.content_type},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := write_quoted_printable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+func write_quoted_printable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func random_id() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Sends msg through the server of cfg, upgrading to TLS and authenticating
+// as configured. The whole exchange is cut short when ctx is done.
+func send_mail(ctx context.Context, cfg EmailConfig, from, to string, msg []byte) (err error) {
+	host, _, err := net.SplitHostPort(cfg.Addr)
+	if err != nil {
+		return err
+	}
+	dialer := net.Dialer{Timeout: EMAIL_TIMEOUT}
+	conn, err := dialer.DialContext(ctx, "tcp", cfg.Addr)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(EMAIL_TIMEOUT)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	// Expire the connection when ctx is cancelled before its deadline
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+	defer func() {
+		if err == nil {
+			return
+		}
+		// The deadline of the connection can pass just before ctx notices
+		if ctx_err := ctx.Err(); ctx_err != nil {
+			err = fmt.Errorf("%w: %v", ctx_err, err)
+		} else if d, ok := ctx.Deadline(); ok && !time.Now().Before(d) {
+			err = fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if cfg.StartTLS != StartTLSOff {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			config := &tls.Config{ServerName: host}
+			if cfg.TLS != nil {
+				config = cfg.TLS.Clone()
+				if config.ServerName == "" {
+					config.ServerName = host
+				}
+			}
+			if err := c.StartTLS(config); err != nil {
+				return err
+			}
+		} else if cfg.StartTLS == StartTLSRequire {
+			return ErrStartTLSUnsupported
+		}
+	}
+	if cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,389 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mechfeed/channels"
+)
+
+func TestValidateEmail(t *testing.T) {
+	cases := []struct {
+		email string
+		valid bool
+	}{
+		{"keebs@example.com", true},
+		{"Keebs <keebs@example.com>", false},
+		{"keebs@example.com\r\nBcc: other@example.com", false},
+		{"keebs", false},
+	}
+	for _, c := range cases {
+		if err := ValidateEmail(c.email); (err == nil) != c.valid {
+			t.Errorf("%q: got %v expect valid %t", c.email, err, c.valid)
+		}
+	}
+}
+
+// Local SMTP server keeping what it's sent
+type smtp_sink struct {
+	addr string
+	tls  *tls.Config // Offers STARTTLS when set
+
+	mu     sync.Mutex
+	mails  []sink_mail
+	errors []error
+}
+
+type sink_mail struct {
+	tls  bool
+	auth string
+	from string
+	to   []string
+	data []byte
+}
+
+func new_smtp_sink(t *testing.T, starttls bool) *smtp_sink {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	sink := &smtp_sink{addr: l.Addr().String()}
+	if starttls {
+		// Borrow the certificate of httptest, valid for 127.0.0.1
+		server := httptest.NewTLSServer(http.NotFoundHandler())
+		sink.tls = server.TLS
+		server.Close()
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	return sink
+}
+
+func (sink *smtp_sink) serve(conn net.Conn) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	var mail sink_mail
+	text.PrintfLine("220 sink ESMTP")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			ext := []string{"250-sink", "250-8BITMIME"}
+			if sink.tls != nil && !mail.tls {
+				ext = append(ext, "250-STARTTLS")
+			}
+			if mail.tls {
+				ext = append(ext, "250-AUTH PLAIN")
+			}
+			text.PrintfLine("%s\r\n250 HELP", strings.Join(ext, "\r\n"))
+		case "STARTTLS":
+			text.PrintfLine("220 go ahead")
+			tls_conn := tls.Server(conn, sink.tls)
+			if err := tls_conn.Handshake(); err != nil {
+				sink.fail(err)
+				return
+			}
+			text = textproto.NewConn(tls_conn)
+			mail.tls = true
+		case "AUTH":
+			_, creds, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(creds)
+			mail.auth = string(decoded)
+			text.PrintfLine("235 accepted")
+		case "MAIL":
+			mail.from = strings.TrimSuffix(strings.TrimPrefix(arg, "FROM:<"), ">")
+			if i := strings.Index(mail.from, "> "); i >= 0 {
+				mail.from = mail.from[:i]
+			}
+			text.PrintfLine("250 ok")
+		case "RCPT":
+			mail.to = append(mail.to, strings.TrimSuffix(strings.TrimPrefix(arg, "TO:<"), ">"))
+			text.PrintfLine("250 ok")
+		case "DATA":
+			text.PrintfLine("354 go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				sink.fail(err)
+				return
+			}
+			mail.data = data
+			sink.mu.Lock()
+			sink.mails = append(sink.mails, mail)
+			sink.mu.Unlock()
+			text.PrintfLine("250 queued")
+		case "QUIT":
+			text.PrintfLine("221 bye")
+			return
+		default:
+			text.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (sink *smtp_sink) fail(err error) {
+	sink.mu.Lock()
+	sink.errors = append(sink.errors, err)
+	sink.mu.Unlock()
+}
+
+func (sink *smtp_sink) sent() []sink_mail {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	return sink.mails
+}
+
+// Sets Email for the duration of the test
+func with_email(t *testing.T, cfg EmailConfig) {
+	old := Email
+	Email = cfg
+	t.Cleanup(func() { Email = old })
+}
+
+func email_listing() channels.Event {
+	return channels.Event{
+		Source:   "redditportal",
+		ID:       "1abc",
+		Title:    "[US-CA][H] GMK <Olivia++> & Artisans [W] PayPal",
+		URL:      "https://reddit.com/r/mechmarket/comments/1abc",
+		Images:   []string{"https://i.imgur.com/AbCdEf1.jpg"},
+		Author:   channels.Author{Username: "keeb_seller", ContactURL: "https://reddit.com/message/compose/?to=keeb_seller"},
+		Category: "Selling",
+	}
+}
+
+func TestCreateEmailNotification(t *testing.T) {
+	n := CreateEmailNotification(email_listing(), "olivia", "$150", "https://mechfeed.example/unsubscribe?alert=7")
+	if n.Subject != "[olivia] [US-CA][H] GMK <Olivia++> & Artisans [W] PayPal" {
+		t.Errorf("got subject %q", n.Subject)
+	}
+	for _, line := range []string{
+		"https://reddit.com/r/mechmarket/comments/1abc",
+		"Posted by: u/keeb_seller",
+		"Send Message: PM (https://reddit.com/message/compose/?to=keeb_seller)",
+		"Price: $150",
+		"Unsubscribe from this alert: https://mechfeed.example/unsubscribe?alert=7",
+	} {
+		if !strings.Contains(n.Text, line) {
+			t.Errorf("missing %q in text\n%s", line, n.Text)
+		}
+	}
+	for _, s := range []string{
+		`<a href="https://reddit.com/r/mechmarket/comments/1abc" style="color:#0068e0;text-decoration:none">[US-CA][H] GMK &lt;Olivia&#43;&#43;&gt; &amp; Artisans [W] PayPal</a>`,
+		`<a href="https://reddit.com/message/compose/?to=keeb_seller">PM</a>`,
+		`<img src="https://i.imgur.com/AbCdEf1.jpg"`,
+		`border-left:4px solid #ff5858`,
+		`<a href="https://mechfeed.example/unsubscribe?alert=7"`,
+	} {
+		if !strings.Contains(n.HTML, s) {
+			t.Errorf("missing %q in HTML\n%s", s, n.HTML)
+		}
+	}
+	if strings.Contains(n.HTML, "<Olivia") {
+		t.Error("title isn't escaped in HTML")
+	}
+}
+
+func TestUnsubscribeLink(t *testing.T) {
+	with_email(t, EmailConfig{UnsubscribeURL: "https://mechfeed.example/unsubscribe", UnsubscribeSecret: []byte("secret")})
+	link := UnsubscribeLink(7, 3)
+	u, err := url.Parse(link)
+	if err != nil || !strings.HasPrefix(link, "https://mechfeed.example/unsubscribe?") {
+		t.Fatalf("got link %q", link)
+	}
+	alert_id, destination_id, err := ParseUnsubscribeLink(u.Query())
+	if err != nil || alert_id != 7 || destination_id != 3 {
+		t.Errorf("got %d %d %v", alert_id, destination_id, err)
+	}
+
+	// Links of other alerts can't be made from this one
+	q := u.Query()
+	q.Set("alert", "8")
+	if _, _, err := ParseUnsubscribeLink(q); !errors.Is(err, ErrInvalidUnsubscribeLink) {
+		t.Errorf("got %v for another alert", err)
+	}
+	Email.UnsubscribeSecret = []byte("other")
+	if _, _, err := ParseUnsubscribeLink(u.Query()); !errors.Is(err, ErrInvalidUnsubscribeLink) {
+		t.Errorf("got %v for another secret", err)
+	}
+
+	Email.UnsubscribeURL = ""
+	if link := UnsubscribeLink(7, 3); link != "" {
+		t.Errorf("got %q without UNSUBSCRIBE_URL", link)
+	}
+}
+
+func TestSendEmail(t *testing.T) {
+	sink := new_smtp_sink(t, true)
+	with_email(t, EmailConfig{
+		Addr:     sink.addr,
+		From:     "Mechfeed <mechfeed@example.com>",
+		Username: "mechfeed",
+		Password: "hunter2",
+		StartTLS: StartTLSRequire,
+		TLS:      &tls.Config{RootCAs: cert_pool(sink.tls)},
+	})
+	n := CreateEmailNotification(email_listing(), "olivia", "$150", "https://mechfeed.example/unsubscribe?alert=7&token=x")
+	n.Subject += "\r\nBcc: everyone@example.com"
+	if err := SendEmail(context.Background(), "keebs@example.com", n); err != nil {
+		t.Fatal(err, sink.errors)
+	}
+
+	sent := sink.sent()
+	if len(sent) != 1 {
+		t.Fatalf("got %d emails", len(sent))
+	}
+	got := sent[0]
+	if !got.tls || got.auth != "\x00mechfeed\x00hunter2" || got.from != "mechfeed@example.com" || strings.Join(got.to, ",") != "keebs@example.com" {
+		t.Errorf("got tls %t auth %q from %q to %q", got.tls, got.auth, got.from, got.to)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(got.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if !strings.HasPrefix(subject, "[olivia] [US-CA][H] GMK <Olivia++>") || msg.Header.Get("Bcc") != "" {
+		t.Errorf("got subject %q bcc %q", subject, msg.Header.Get("Bcc"))
+	}
+	if msg.Header.Get("List-Unsubscribe") != "<https://mechfeed.example/unsubscribe?alert=7&token=x>" || msg.Header.Get("List-Unsubscribe-Post") != "List-Unsubscribe=One-Click" {
+		t.Errorf("got unsubscribe headers %q %q", msg.Header.Get("List-Unsubscribe"), msg.Header.Get("List-Unsubscribe-Post"))
+	}
+
+	media_type, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || media_type != "multipart/alternative" {
+		t.Fatalf("got content type %q", msg.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var bodies []string
+	for {
+		part, err := parts.NextPart() // Decodes quoted-printable
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		bodies = append(bodies, part.Header.Get("Content-Type")+"\n"+string(body))
+	}
+	if len(bodies) != 2 {
+		t.Fatalf("got %d parts", len(bodies))
+	}
+	if expect := "text/plain; charset=utf-8\n" + strings.ReplaceAll(n.Text, "\r\n", "\n"); strings.ReplaceAll(bodies[0], "\r\n", "\n") != expect {
+		t.Errorf("got text part\n%s", bodies[0])
+	}
+	if expect := "text/html; charset=utf-8\n" + n.HTML; strings.ReplaceAll(bodies[1], "\r\n", "\n") != expect {
+		t.Errorf("got HTML part\n%s", bodies[1])
+	}
+}
+
+func TestSendEmailStartTLS(t *testing.T) {
+	sink := new_smtp_sink(t, false)
+	with_email(t, EmailConfig{Addr: sink.addr, From: "mechfeed@example.com", StartTLS: StartTLSRequire})
+	if err := SendEmail(context.Background(), "keebs@example.com", EmailNoti{Subject: "Mechfeed test", Text: "test"}); !errors.Is(err, ErrStartTLSUnsupported) {
+		t.Errorf("got %v expect %v", err, ErrStartTLSUnsupported)
+	}
+
+	// Plain text is fine when STARTTLS isn't required
+	Email.StartTLS = StartTLSAuto
+	if err := SendEmail(context.Background(), "keebs@example.com", EmailNoti{Subject: "Mechfeed test", Text: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	sent := sink.sent()
+	if len(sent) != 1 || sent[0].tls {
+		t.Fatalf("got %d emails", len(sent))
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(sent[0].data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("Content-Type") != "text/plain; charset=utf-8" || msg.Header.Get("List-Unsubscribe") != "" {
+		t.Errorf("got headers %v", msg.Header)
+	}
+
+	// Credentials aren't sent without TLS, except to localhost
+	Email.Username, Email.Password = "mechfeed", "hunter2"
+	Email.Addr = strings.Replace(sink.addr, "127.0.0.1", "localhost", 1)
+	if err := SendEmail(context.Background(), "keebs@example.com", EmailNoti{Subject: "Mechfeed test", Text: "test"}); err != nil {
+		t.Errorf("got %v sending to localhost", err)
+	}
+}
+
+func TestSendEmailNotConfigured(t *testing.T) {
+	with_email(t, EmailConfig{})
+	if err := SendEmail(context.Background(), "keebs@example.com", EmailNoti{}); !errors.Is(err, ErrEmailNotConfigured) {
+		t.Errorf("got %v", err)
+	}
+}
+
+func cert_pool(config *tls.Config) *x509.CertPool {
+	pool := x509.NewCertPool()
+	cert, _ := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+	pool.AddCert(cert)
+	return pool
+}
+
+func TestSendEmailContext(t *testing.T) {
+	// Server that accepts connections and never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	with_email(t, EmailConfig{Addr: l.Addr().String(), From: "mechfeed@example.com"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	if err := SendEmail(ctx, "keebs@example.com", EmailNoti{Subject: "Mechfeed test", Text: "test"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v expect %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second*5 {
+		t.Errorf("took %v to give up", elapsed)
+	}
+
+	// Cancelled without a deadline
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+	if err := SendEmail(ctx, "keebs@example.com", EmailNoti{Subject: "Mechfeed test", Text: "test"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v expect %v", err, context.Canceled)
+	}
+}
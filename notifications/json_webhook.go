@@ -0,0 +1,115 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"mechfeed/channels"
+)
+
+var ErrInvalidJSONURL = errors.New("webhook URL must be a public http(s) URL")
+
+// ValidateJSONURL checks that s is a URL that listings can be posted to,
+// refusing the addresses of mechfeed's own network.
+func ValidateJSONURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" || u.User != nil {
+		return ErrInvalidJSONURL
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return ErrInvalidJSONURL
+	}
+	if ip := net.ParseIP(host); ip != nil && !is_public(ip) {
+		return ErrInvalidJSONURL
+	}
+	return nil
+}
+
+// ValidateJSONURL can't know where a name resolves when the listing is sent,
+// so the hook client refuses to connect to private addresses as well
+func public_dial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !is_public(ip) {
+		return fmt.Errorf("refusing to connect to %s: %w", host, ErrInvalidJSONURL)
+	}
+	return nil
+}
+
+func is_public(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified())
+}
+
+// JSONNoti is the listing posted to generic JSON webhooks.
+type JSONNoti struct {
+	Alert      string         `json:"alert"`
+	Source     string         `json:"source"`
+	ID         string         `json:"id"`
+	Title      string         `json:"title,omitempty"`
+	Body       string         `json:"body,omitempty"`
+	URL        string         `json:"url,omitempty"`
+	Author     JSONAuthor     `json:"author"`
+	Server     string         `json:"server,omitempty"`
+	Channel    string         `json:"channel,omitempty"`
+	Category   string         `json:"category,omitempty"`
+	Location   string         `json:"location,omitempty"`
+	Price      string         `json:"price,omitempty"`
+	Images     []string       `json:"images,omitempty"`
+	Gallery    string         `json:"gallery,omitempty"`
+	Crossposts []JSONSighting `json:"crossposts,omitempty"`
+	Posted     *time.Time     `json:"posted,omitempty"`
+}
+
+type JSONAuthor struct {
+	ID         string `json:"id"`
+	Username   string `json:"username"`
+	Name       string `json:"name"`
+	ContactURL string `json:"contact_url,omitempty"`
+}
+
+type JSONSighting struct {
+	Source  string `json:"source"`
+	Server  string `json:"server,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	URL     string `json:"url,omitempty"`
+}
+
+func CreateJSONNotification(data channels.Event, alert, price string) JSONNoti {
+	noti := JSONNoti{
+		Alert:  alert,
+		Source: data.Source,
+		ID:     data.ID,
+		Title:  data.Title,
+		Body:   data.Body,
+		URL:    data.URL,
+		Author: JSONAuthor{
+			ID:         data.Author.ID,
+			Username:   data.Author.Username,
+			Name:       data.Author.Name(),
+			ContactURL: data.Author.ContactURL,
+		},
+		Server:   data.Server,
+		Channel:  data.Channel,
+		Category: data.Category,
+		Location: data.Listing.Location,
+		Price:    price,
+		Images:   data.Images,
+		Gallery:  data.Gallery,
+	}
+	for _, s := range data.Crossposts {
+		noti.Crossposts = append(noti.Crossposts, JSONSighting{Source: s.Source, Server: s.Server, Channel: s.Channel, URL: s.URL})
+	}
+	if !data.Timestamp.IsZero() {
+		posted := data.Timestamp.UTC()
+		noti.Posted = &posted
+	}
+	return noti
+}
@@ -1,157 +1,154 @@
 package notifications
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
 	"mechfeed/channels"
-	"net/http"
+	"strings"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 )
 
+// SendWebhook posts message as JSON to a webhook with DefaultWebhookClient.
 func SendWebhook(webhookURL string, message interface{}) error {
-	json_payload, err := json.Marshal(message)
-	if err != nil {
-		return err
-	}
+	return DefaultWebhookClient.Send(context.Background(), webhookURL, message)
+}
 
-	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(json_payload))
-	if err != nil {
-		return err
-	}
+// Webhook embed colors by source name
+var SourceColors = map[string]int{
+	"redditportal": 16734296,
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	client := http.Client{}
-	resp, err := client.Do(req)
+const DEFAULT_WEBHOOK_COLOR = 5727730
 
-	if err != nil {
-		return err
+func CreateNotification(data channels.Event, alert, price string) DiscordNoti {
+	color, ok := SourceColors[data.Source]
+	if !ok {
+		color = DEFAULT_WEBHOOK_COLOR
 	}
 
-	resp.Body.Close()
-	return nil
-}
+	var fields []Field
+	for _, f := range notification_fields(data, alert, price) {
+		fields = append(fields, Field{Name: f.Name, Value: f.Value, Inline: f.Inline})
+	}
+
+	var image Image
+	if len(data.Images) > 0 {
+		image.URL = data.Images[0]
+	}
 
-func CreateNotificationReddit(data channels.RedditMessage) DiscordNoti {
 	return DiscordNoti{
 		Content: nil,
 		Embeds: []Embed{
 			{
-				Title: data.Title,
-				URL:   data.URL,
-				Color: 16734296,
-				Fields: []Field{
-					{Name: "Posted by", Value: "u/" + data.Author + " [[PM]](https://www.reddit.com/message/compose/?to=" + data.Author + ")", Inline: true},
-					{Name: "Category", Value: data.Category, Inline: true},
-					{Name: "Imgur Link", Value: data.Imgur},
-				},
+				Title:     data.Title,
+				URL:       title_url(data),
+				Color:     color,
+				Fields:    fields,
 				Footer:    Footer{Text: "mechfeed"},
 				Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
-				Image:     Image{URL: data.Thumbnail},
+				Image:     image,
 			},
 		},
 		Username: "mechfeed",
 	}
 }
 
-func CreateNotificationDiscord(server, channel, alert string, data channels.DiscordMessage) DiscordNoti {
-	return DiscordNoti{
-		Content:  nil,
-		Embeds: []Embed{
-			{
-				Color: 5727730,
-				Fields: []Field{
-					{Name: "Server", Value: server, Inline: true},
-					{Name: "Channel", Value: "#" + channel, Inline: true},
-					{Name: "Sent by", Value: data.Author.GlobalName + " (" + data.Author.Username + ")", Inline: true},
-					{Name: "Jump to message", Value: fmt.Sprintf("https://discord.com/channels/%s/%s/%s", data.GuildID, data.ChannelID, data.ID)},
-					{Name: "Matched alert", Value: fmt.Sprintf("`%s`", alert), Inline: true},
-					{Name: "Message", Value: data.Content},
-				},
-				Footer:    Footer{Text: "mechfeed"},
-				Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
-			},
-		},
-		Username: "mechfeed",
+func CreateNotificationMessageEmbed(data channels.Event, alert, price string) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title:     data.Title,
+		URL:       title_url(data),
+		Color:     0xe671dc, // Color in decimal format
+		Fields:    notification_fields(data, alert, price),
+		Footer:    &discordgo.MessageEmbedFooter{Text: "mechfeed"},
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
 	}
+	if len(data.Images) > 0 {
+		embed.Image = &discordgo.MessageEmbedImage{URL: data.Images[0]}
+	}
+	return embed
 }
 
+// Embed URLs are only shown on titles
+func title_url(data channels.Event) string {
+	if data.Title == "" {
+		return ""
+	}
+	return data.URL
+}
 
-func CreateRedditNotificationMessageEmbed(data channels.RedditMessage, alert string) *discordgo.MessageEmbed {
-	return &discordgo.MessageEmbed{
-		Title:       data.Title,
-		URL:         data.URL,
-		Color:       0xe671dc, // Color in decimal format
-		Fields: []*discordgo.MessageEmbedField{
-			{
-				Name:   "Posted by",
-				Value:  "u/" + data.Author,
-				Inline: true,
-			},
-			{	
-				Name: "Send Message",
-				Value:  "[[PM]](https://www.reddit.com/message/compose/?to=" + data.Author + ")",
-				Inline: true,
-			},
-			{
-				Name:   "Category",
-				Value:  data.Category,
-				Inline: true,
-			},
-			{
-				Name:   "Imgur Link",
-				Value:  data.Imgur,
-			},
-			{
-				Name:   "Matched alert",
-				Value:  fmt.Sprintf("`%s`", alert),
-			},
-		},
-		Footer:    &discordgo.MessageEmbedFooter{Text: "mechfeed"},
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Image:     &discordgo.MessageEmbedImage{URL: data.Thumbnail},
+func notification_fields(data channels.Event, alert, price string) []*discordgo.MessageEmbedField {
+	var fields []*discordgo.MessageEmbedField
+	add := func(name, value string, inline bool) {
+		fields = append(fields, &discordgo.MessageEmbedField{Name: name, Value: value, Inline: inline})
+	}
+
+	if data.Server != "" && data.Channel != "" {
+		add("Server", data.Server, true)
+		add("Channel", "#"+data.Channel, true)
+	}
+	add("Posted by", author_name(data), true)
+	if data.Author.ContactURL != "" {
+		add("Send Message", "[[PM]]("+data.Author.ContactURL+")", true)
+	}
+	if data.Category != "" {
+		add("Category", data.Category, true)
+	}
+	if data.Listing.Location != "" {
+		add("Location", data.Listing.Location, true)
+	}
+	if price != "" {
+		add("Price", price, true)
+	}
+	if data.Gallery != "" {
+		add("Imgur Link", data.Gallery, false)
 	}
+	if data.Title == "" && data.URL != "" {
+		add("Jump to message", data.URL, false)
+	}
+	if len(data.Crossposts) > 0 {
+		add("Also posted in", crossposts(data.Crossposts), false)
+	}
+	if alert != "" {
+		add("Matched alert", fmt.Sprintf("`%s`", alert), false)
+	}
+	// Titled posts link to their body instead
+	if data.Title == "" && data.Body != "" {
+		add("Message", Truncate(data.Body, 1024), false)
+	}
+	return fields
 }
 
-func CreateDiscordNotificationMessageEmbed(server, channel, alert string, data channels.DiscordMessage) *discordgo.MessageEmbed {
-	if len(data.Content) > 1024 {
-		data.Content = data.Content[:1021] + "..."
+// Lists where else a listing was posted, one link per line
+func crossposts(sightings []channels.Sighting) string {
+	var sb strings.Builder
+	for _, s := range sightings {
+		place := s.Server
+		if s.Channel != "" {
+			place += " #" + s.Channel
+		}
+		if place == "" {
+			place = s.Source
+		}
+		line := place
+		if s.URL != "" {
+			line = "[" + place + "](" + s.URL + ")"
+		}
+		if sb.Len()+len(line)+1 > 1024 {
+			break
+		}
+		sb.WriteString(line + "\n")
 	}
-	return &discordgo.MessageEmbed{
-		Color: 0xe671dc, // Color in decimal format
-		Fields: []*discordgo.MessageEmbedField{
-			{
-				Name:   "Server",
-				Value:  server,
-				Inline: true,
-			},
-			{
-				Name:   "Channel",
-				Value:  "#" + channel,
-				Inline: true,
-			},
-			{
-				Name:   "Sent by",
-				Value:  data.Author.Username,
-				Inline: true,
-			},
-			{
-				Name:   "Jump to message",
-				Value:  fmt.Sprintf("https://discord.com/channels/%s/%s/%s", data.GuildID, data.ChannelID, data.ID),
-			},
-			{
-				Name:   "Matched alert",
-				Value:  fmt.Sprintf("`%s`", alert),
-				Inline: true,
-			},
-			{
-				Name:   "Message",
-				Value:  data.Content,
-			},
-		},
-		Footer:    &discordgo.MessageEmbedFooter{Text: "mechfeed"},
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+func author_name(data channels.Event) string {
+	if data.Source == "redditportal" {
+		return "u/" + data.Author.Username
+	}
+	if data.Author.DisplayName != "" && data.Author.DisplayName != data.Author.Username {
+		return data.Author.DisplayName + " (" + data.Author.Username + ")"
 	}
-}
\ No newline at end of file
+	return data.Author.Username
+}
@@ -0,0 +1,68 @@
+package notifications
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"mechfeed/channels"
+)
+
+// Server of ntfy topics given without a URL
+const NTFY_SERVER = "https://ntfy.sh"
+
+var ntfy_topic = regexp.MustCompile(`^[-_A-Za-z0-9]{1,64}$`)
+
+var ErrInvalidNtfyTopic = errors.New("not an ntfy topic, use a topic name such as mechfeed_x7k2 or a URL such as https://ntfy.example.com/mechfeed")
+
+// ParseNtfyTarget splits an ntfy topic, either a name on NTFY_SERVER or the
+// URL of a topic on another server, into its server and topic name.
+func ParseNtfyTarget(s string) (server, topic string, err error) {
+	if ntfy_topic.MatchString(s) {
+		return NTFY_SERVER, s, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.User != nil || u.RawQuery != "" {
+		return "", "", ErrInvalidNtfyTopic
+	}
+	topic = strings.Trim(u.Path, "/")
+	if !ntfy_topic.MatchString(topic) {
+		return "", "", ErrInvalidNtfyTopic
+	}
+	return u.Scheme + "://" + u.Host, topic, nil
+}
+
+// NtfyNoti is a message published as JSON to the root URL of an ntfy server.
+type NtfyNoti struct {
+	Topic   string       `json:"topic"`
+	Title   string       `json:"title"`
+	Message string       `json:"message"`
+	Tags    []string     `json:"tags,omitempty"`
+	Click   string       `json:"click,omitempty"`
+	Attach  string       `json:"attach,omitempty"`
+	Actions []NtfyAction `json:"actions,omitempty"`
+}
+
+type NtfyAction struct {
+	Action string `json:"action"`
+	Label  string `json:"label"`
+	URL    string `json:"url"`
+}
+
+func CreateNtfyNotification(data channels.Event, topic, alert, price string) NtfyNoti {
+	noti := NtfyNoti{
+		Topic:   topic,
+		Title:   text_title(data),
+		Message: strings.Join(text_fields(data, alert, price, plain_text, plain_link), "\n"),
+		Tags:    []string{"keyboard"},
+		Click:   data.URL,
+	}
+	if len(data.Images) > 0 {
+		noti.Attach = data.Images[0]
+	}
+	if data.Author.ContactURL != "" {
+		noti.Actions = append(noti.Actions, NtfyAction{Action: "view", Label: "Message seller", URL: data.Author.ContactURL})
+	}
+	return noti
+}
@@ -0,0 +1,47 @@
+package notifications
+
+import (
+	"testing"
+
+	"mechfeed/channels"
+)
+
+func TestParseNtfyTarget(t *testing.T) {
+	cases := []struct {
+		target string
+		server string
+		topic  string
+		valid  bool
+	}{
+		{"mechfeed_x7k2", NTFY_SERVER, "mechfeed_x7k2", true},
+		{"https://ntfy.example.com/mechfeed", "https://ntfy.example.com", "mechfeed", true},
+		{"https://ntfy.example.com/mechfeed/", "https://ntfy.example.com", "mechfeed", true},
+		{"http://ntfy.example.com/mechfeed", "", "", false},
+		{"https://ntfy.example.com/a/b", "", "", false},
+		{"https://ntfy.example.com/mechfeed?auth=secret", "", "", false},
+		{"two words", "", "", false},
+	}
+	for _, c := range cases {
+		server, topic, err := ParseNtfyTarget(c.target)
+		if (err == nil) != c.valid || server != c.server || topic != c.topic {
+			t.Errorf("%s: got %q %q %v", c.target, server, topic, err)
+		}
+	}
+}
+
+func TestCreateNtfyNotification(t *testing.T) {
+	ev := channels.Event{
+		Source: "discordportal",
+		Body:   "WTS GMK Olivia++ base\nmore details",
+		URL:    "https://discord.com/channels/1/2/3",
+		Images: []string{"https://i.imgur.com/abc.jpg"},
+		Author: channels.Author{Username: "seller_42"},
+	}
+	n := CreateNtfyNotification(ev, "mechfeed", "olivia", "")
+	if n.Topic != "mechfeed" || n.Title != "WTS GMK Olivia++ base" || n.Click != ev.URL || n.Attach != ev.Images[0] {
+		t.Errorf("got %+v", n)
+	}
+	if n.Message == "" {
+		t.Error("empty message")
+	}
+}
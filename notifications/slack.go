@@ -0,0 +1,167 @@
+package notifications
+
+import (
+	"net/url"
+	"path"
+	"strings"
+
+	"mechfeed/channels"
+)
+
+// Block Kit limits
+const (
+	MAX_SLACK_FIELDS      = 10
+	MAX_SLACK_FIELD_CHARS = 2000
+	MAX_SLACK_TEXT_CHARS  = 3000
+)
+
+// SlackNoti is the payload of a Slack incoming webhook, laid out with Block
+// Kit. Text is shown in notifications and by clients without blocks.
+type SlackNoti struct {
+	Text   string       `json:"text"`
+	Blocks []SlackBlock `json:"blocks,omitempty"`
+}
+
+// SlackBlock is a section, image or actions block.
+type SlackBlock struct {
+	Type     string         `json:"type"`
+	Text     *SlackText     `json:"text,omitempty"`
+	Fields   []SlackText    `json:"fields,omitempty"`
+	ImageURL string         `json:"image_url,omitempty"`
+	AltText  string         `json:"alt_text,omitempty"`
+	Elements []SlackElement `json:"elements,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"` // mrkdwn or plain_text
+	Text string `json:"text"`
+}
+
+// SlackElement is a link button of an actions block.
+type SlackElement struct {
+	Type     string    `json:"type"`
+	Text     SlackText `json:"text"`
+	URL      string    `json:"url"`
+	ActionID string    `json:"action_id"`
+	Style    string    `json:"style,omitempty"`
+}
+
+// Slack mrkdwn escapes
+var slack_escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func CreateSlackNotification(data channels.Event, alert, price string) SlackNoti {
+	title := "*" + slack_escaper.Replace(text_title(data)) + "*"
+	if data.URL != "" {
+		title = "*" + slack_link(text_title(data), data.URL) + "*"
+	}
+
+	lines := []string{title}
+	blocks := []SlackBlock{slack_section(truncate_text(title, MAX_SLACK_TEXT_CHARS))}
+	var fields []SlackText
+	field_lines := text_fields(data, alert, price, slack_escaper.Replace, slack_link)
+	for i, f := range notification_fields(data, alert, price) {
+		name, value, _ := strings.Cut(field_lines[i], ": ")
+		lines = append(lines, "*"+name+":* "+value)
+
+		// Links to the listing and seller are buttons instead
+		if f.Name == "Send Message" || f.Name == "Jump to message" {
+			continue
+		}
+		if f.Inline && len(fields) < MAX_SLACK_FIELDS {
+			fields = append(fields, SlackText{Type: "mrkdwn", Text: truncate_text("*"+name+"*\n"+value, MAX_SLACK_FIELD_CHARS)})
+			continue
+		}
+		if len(fields) > 0 {
+			blocks = append(blocks, SlackBlock{Type: "section", Fields: fields})
+			fields = nil
+		}
+		blocks = append(blocks, slack_section(truncate_text("*"+name+"*\n"+value, MAX_SLACK_TEXT_CHARS)))
+	}
+	if len(fields) > 0 {
+		blocks = append(blocks, SlackBlock{Type: "section", Fields: fields})
+	}
+
+	if thumbnail := imgur_thumbnail(data); thumbnail != "" {
+		blocks = append(blocks, SlackBlock{Type: "image", ImageURL: thumbnail, AltText: truncate_text(text_title(data), MAX_SLACK_FIELD_CHARS)})
+	}
+
+	var buttons []SlackElement
+	if data.URL != "" {
+		buttons = append(buttons, slack_button("open_listing", "Open listing", data.URL, "primary"))
+	}
+	if data.Author.ContactURL != "" {
+		buttons = append(buttons, slack_button("message_seller", "Message seller", data.Author.ContactURL, ""))
+	}
+	if len(buttons) > 0 {
+		blocks = append(blocks, SlackBlock{Type: "actions", Elements: buttons})
+	}
+
+	return SlackNoti{Text: truncate_text(strings.Join(lines, "\n"), MAX_SLACK_TEXT_CHARS), Blocks: blocks}
+}
+
+func slack_section(text string) SlackBlock {
+	return SlackBlock{Type: "section", Text: &SlackText{Type: "mrkdwn", Text: text}}
+}
+
+func slack_button(action_id, label, url, style string) SlackElement {
+	return SlackElement{
+		Type:     "button",
+		Text:     SlackText{Type: "plain_text", Text: label},
+		URL:      url,
+		ActionID: action_id,
+		Style:    style,
+	}
+}
+
+func slack_link(text, url string) string {
+	return "<" + url + "|" + strings.ReplaceAll(slack_escaper.Replace(text), "|", "¦") + ">"
+}
+
+// Large thumbnail of the first image if it's on Imgur, e.g.
+// https://i.imgur.com/AbCdEf1.jpg becomes https://i.imgur.com/AbCdEf1l.jpg
+func imgur_thumbnail(data channels.Event) string {
+	if len(data.Images) == 0 {
+		return ""
+	}
+	u, err := url.Parse(data.Images[0])
+	if err != nil || u.Scheme != "https" || u.Host != "i.imgur.com" {
+		return ""
+	}
+	ext := path.Ext(u.Path)
+	id := strings.TrimSuffix(strings.TrimPrefix(u.Path, "/"), ext)
+	if id == "" || strings.Contains(id, "/") || ext == ".gifv" || ext == ".mp4" {
+		return ""
+	}
+	// IDs are 5 or 7 characters, longer ones already have a size suffix
+	if len(id) == 5 || len(id) == 7 {
+		id += "l"
+	}
+	return "https://i.imgur.com/" + id + ext
+}
+
+// Truncate for mrkdwn, cutting before a link or escape that would be split,
+// e.g. <https://...|PM> or &amp;
+func truncate_text(s string, n int) string {
+	t := Truncate(s, n)
+	if t == s {
+		return s
+	}
+	cut := strings.TrimSuffix(t, "...")
+	open := -1 // Start of the link or escape left open
+	for i, r := range cut {
+		switch {
+		case r == '<':
+			open = i
+		case r == '>' && open >= 0 && cut[open] == '<':
+			open = -1
+		case r == '&' && open < 0:
+			open = i
+		case r == ';' && open >= 0 && cut[open] == '&':
+			open = -1
+		}
+	}
+	if open >= 0 {
+		cut = cut[:open]
+	}
+	return cut + "..."
+}
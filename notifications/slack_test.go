@@ -0,0 +1,93 @@
+package notifications
+
+import (
+	"strings"
+	"testing"
+
+	"mechfeed/channels"
+)
+
+func TestCreateSlackNotification(t *testing.T) {
+	ev := channels.Event{
+		Source:     "redditportal",
+		Title:      "[US-CA][H] GMK <Olivia> & more [W] PayPal",
+		URL:        "https://reddit.com/r/mechmarket/comments/1abc",
+		Images:     []string{"https://i.imgur.com/AbCdEf1.jpg"},
+		Author:     channels.Author{Username: "keebseller", ContactURL: "https://reddit.com/message/compose/?to=keebseller"},
+		Crossposts: []channels.Sighting{{Source: "discordportal", Server: "Top Clack", Channel: "buy-sell", URL: "https://discord.com/channels/1/2/3"}},
+	}
+	n := CreateSlackNotification(ev, "olivia", "$150")
+	expect := []string{
+		"*<https://reddit.com/r/mechmarket/comments/1abc|[US-CA][H] GMK &lt;Olivia&gt; &amp; more [W] PayPal>*",
+		"*Posted by:* u/keebseller",
+		"*Send Message:* <https://reddit.com/message/compose/?to=keebseller|PM>",
+		"*Price:* $150",
+		"*Also posted in:* <https://discord.com/channels/1/2/3|Top Clack #buy-sell>",
+		"*Matched alert:* `olivia`",
+	}
+	for _, line := range expect {
+		if !strings.Contains(n.Text, line) {
+			t.Errorf("missing %q in\n%s", line, n.Text)
+		}
+	}
+
+	var types []string
+	for _, b := range n.Blocks {
+		types = append(types, b.Type)
+	}
+	if got := strings.Join(types, " "); got != "section section section section image actions" {
+		t.Fatalf("got blocks %s", got)
+	}
+	if fields := n.Blocks[1].Fields; len(fields) != 2 || fields[0].Text != "*Posted by*\nu/keebseller" || fields[1].Text != "*Price*\n$150" {
+		t.Errorf("got fields %+v", fields)
+	}
+	if image := n.Blocks[4]; image.ImageURL != "https://i.imgur.com/AbCdEf1l.jpg" || image.AltText == "" {
+		t.Errorf("got image %+v", image)
+	}
+	buttons := n.Blocks[5].Elements
+	if len(buttons) != 2 || buttons[0].URL != ev.URL || buttons[1].URL != ev.Author.ContactURL {
+		t.Errorf("got buttons %+v", buttons)
+	}
+}
+
+func TestImgurThumbnail(t *testing.T) {
+	cases := []struct {
+		image  string
+		expect string
+	}{
+		{"https://i.imgur.com/AbCdEf1.jpg", "https://i.imgur.com/AbCdEf1l.jpg"},
+		{"https://i.imgur.com/AbCde.png", "https://i.imgur.com/AbCdel.png"},
+		{"https://i.imgur.com/AbCdEf1h.jpg", "https://i.imgur.com/AbCdEf1h.jpg"},
+		{"https://i.imgur.com/AbCdEf1.gifv", ""},
+		{"https://imgur.com/a/AbCdEf1", ""},
+		{"https://cdn.discordapp.com/attachments/1/2/keyboard.jpg", ""},
+	}
+	for _, c := range cases {
+		if got := imgur_thumbnail(channels.Event{Images: []string{c.image}}); got != c.expect {
+			t.Errorf("%s: got %q expect %q", c.image, got, c.expect)
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	cases := []struct {
+		text   string
+		n      int
+		expect string
+	}{
+		{"short", 10, "short"},
+		{"ünïcödé keyboard", 10, "ünïcödé..."},
+		{"see <https://example.com|PM> now", 15, "see ..."},
+		{"see <https://example.com|PM> now", 31, "see <https://example.com|PM>..."},
+		{"GMK &lt;Olivia&gt;", 9, "GMK ..."},
+		{"GMK &lt;Olivia&gt;", 15, "GMK &lt;Oliv..."},
+	}
+	for _, c := range cases {
+		if got := truncate_text(c.text, c.n); got != c.expect {
+			t.Errorf("truncate_text(%q, %d): got %q expect %q", c.text, c.n, got, c.expect)
+		}
+	}
+	if got := Truncate("see <https://example.com|PM> now", 15); got != "see <https:/..." {
+		t.Errorf("Truncate only cuts links for Slack, got %q", got)
+	}
+}
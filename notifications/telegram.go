@@ -0,0 +1,262 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mechfeed/fetch-errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"mechfeed/channels"
+)
+
+const TELEGRAM_API = "https://api.telegram.org"
+
+// Telegram limits, in characters of text after parsing
+const (
+	MAX_TELEGRAM_TEXT    = 4096
+	MAX_TELEGRAM_CAPTION = 1024
+)
+
+var ErrTelegramNotConfigured = errors.New("telegram notifications aren't set up on this mechfeed")
+
+// TelegramClient calls the Telegram Bot API.
+type TelegramClient struct {
+	HTTP    *http.Client
+	BaseURL string
+	Token   string // Telegram is disabled when empty
+}
+
+// DefaultTelegramClient is given its token by main from TELEGRAM_TOKEN.
+var DefaultTelegramClient = NewTelegramClient("")
+
+func NewTelegramClient(token string) *TelegramClient {
+	return &TelegramClient{
+		// Longer than the long polling of GetUpdates
+		HTTP:    &http.Client{Timeout: time.Second * 60},
+		BaseURL: TELEGRAM_API,
+		Token:   token,
+	}
+}
+
+// TelegramNoti is a notification sent to a Telegram chat, as a photo with
+// Text as caption when it has one that fits.
+type TelegramNoti struct {
+	Text     string             `json:"text"` // MarkdownV2
+	Photo    string             `json:"photo,omitempty"`
+	Keyboard [][]TelegramButton `json:"keyboard,omitempty"`
+}
+
+// TelegramButton is an inline keyboard button, opening URL or sending
+// CallbackData back to the bot.
+type TelegramButton struct {
+	Text         string `json:"text"`
+	URL          string `json:"url,omitempty"`
+	CallbackData string `json:"callback_data,omitempty"`
+}
+
+type TelegramUpdate struct {
+	UpdateID      int64                  `json:"update_id"`
+	Message       *TelegramMessage       `json:"message,omitempty"`
+	CallbackQuery *TelegramCallbackQuery `json:"callback_query,omitempty"`
+}
+
+type TelegramMessage struct {
+	MessageID int64         `json:"message_id"`
+	From      *TelegramUser `json:"from,omitempty"`
+	Chat      TelegramChat  `json:"chat"`
+	Text      string        `json:"text,omitempty"`
+}
+
+type TelegramChat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"`
+}
+
+type TelegramUser struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username,omitempty"`
+}
+
+type TelegramCallbackQuery struct {
+	ID      string           `json:"id"`
+	From    TelegramUser     `json:"from"`
+	Message *TelegramMessage `json:"message,omitempty"`
+	Data    string           `json:"data,omitempty"`
+}
+
+type telegram_response struct {
+	OK          bool            `json:"ok"`
+	Result      json.RawMessage `json:"result"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+type telegram_keyboard struct {
+	InlineKeyboard [][]TelegramButton `json:"inline_keyboard"`
+}
+
+// Characters escaped in MarkdownV2 text
+var telegram_escaper = strings.NewReplacer(
+	`\`, `\\`, "_", `\_`, "*", `\*`, "[", `\[`, "]", `\]`, "(", `\(`, ")", `\)`, "~", `\~`, "`", "\\`",
+	">", `\>`, "#", `\#`, "+", `\+`, "-", `\-`, "=", `\=`, "|", `\|`, "{", `\{`, "}", `\}`, ".", `\.`, "!", `\!`,
+)
+
+// EscapeMarkdownV2 escapes s to be shown as is in a MarkdownV2 message.
+func EscapeMarkdownV2(s string) string {
+	return telegram_escaper.Replace(s)
+}
+
+func telegram_link(text, url string) string {
+	url = strings.NewReplacer(`\`, `\\`, ")", `\)`).Replace(url)
+	return "[" + EscapeMarkdownV2(text) + "](" + url + ")"
+}
+
+// CreateTelegramNotification renders a notification with buttons to open the
+// listing and to mute alertID.
+func CreateTelegramNotification(data channels.Event, alert, price string, alertID int32) TelegramNoti {
+	title := "*" + EscapeMarkdownV2(text_title(data)) + "*"
+	if data.URL != "" {
+		title = "*" + telegram_link(text_title(data), data.URL) + "*"
+	}
+	lines := []string{title}
+	for _, line := range text_fields(data, alert, price, EscapeMarkdownV2, telegram_link) {
+		name, value, _ := strings.Cut(line, ": ")
+		lines = append(lines, "*"+name+":* "+value)
+	}
+
+	noti := TelegramNoti{Text: telegram_truncate(lines, MAX_TELEGRAM_TEXT)}
+	if len(data.Images) > 0 {
+		noti.Photo = data.Images[0]
+	}
+	var buttons []TelegramButton
+	if data.URL != "" {
+		buttons = append(buttons, TelegramButton{Text: "Open listing", URL: data.URL})
+	}
+	if alertID != 0 {
+		buttons = append(buttons, TelegramButton{Text: "Mute alert 24h", CallbackData: fmt.Sprintf("mute:%d", alertID)})
+	}
+	if len(buttons) > 0 {
+		noti.Keyboard = [][]TelegramButton{buttons}
+	}
+	return noti
+}
+
+// Joins as many whole lines as fit in max, as escapes can't be cut in half
+func telegram_truncate(lines []string, max int) string {
+	text := lines[0]
+	for _, line := range lines[1:] {
+		if len(text)+1+len(line) > max {
+			break
+		}
+		text += "\n" + line
+	}
+	return text
+}
+
+// Send sends n to a chat, falling back to a text message if Telegram can't
+// fetch the photo or the text is too long for a caption.
+func (c *TelegramClient) Send(ctx context.Context, chatID string, n TelegramNoti) error {
+	var markup *telegram_keyboard
+	if len(n.Keyboard) > 0 {
+		markup = &telegram_keyboard{InlineKeyboard: n.Keyboard}
+	}
+	if n.Photo != "" && len(n.Text) <= MAX_TELEGRAM_CAPTION {
+		err := c.call(ctx, "sendPhoto", map[string]interface{}{
+			"chat_id":      chatID,
+			"photo":        n.Photo,
+			"caption":      n.Text,
+			"parse_mode":   "MarkdownV2",
+			"reply_markup": markup,
+		}, nil)
+		var fetch_err fetcherrors.FetchError
+		if !errors.As(err, &fetch_err) || fetch_err.Code != http.StatusBadRequest {
+			return err
+		}
+	}
+	return c.call(ctx, "sendMessage", map[string]interface{}{
+		"chat_id":      chatID,
+		"text":         n.Text,
+		"parse_mode":   "MarkdownV2",
+		"reply_markup": markup,
+	}, nil)
+}
+
+// SendText sends text as is to a chat.
+func (c *TelegramClient) SendText(ctx context.Context, chatID, text string) error {
+	return c.call(ctx, "sendMessage", map[string]interface{}{"chat_id": chatID, "text": text}, nil)
+}
+
+// GetUpdates waits up to timeout for updates from offset on, the ID after the
+// last update handled.
+func (c *TelegramClient) GetUpdates(ctx context.Context, offset int64, timeout time.Duration) ([]TelegramUpdate, error) {
+	var updates []TelegramUpdate
+	err := c.call(ctx, "getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(timeout.Seconds()),
+		"allowed_updates": []string{"message", "callback_query"},
+	}, &updates)
+	return updates, err
+}
+
+// AnswerCallback acknowledges a button press, showing text to the user.
+func (c *TelegramClient) AnswerCallback(ctx context.Context, id, text string) error {
+	return c.call(ctx, "answerCallbackQuery", map[string]interface{}{"callback_query_id": id, "text": text}, nil)
+}
+
+// GetMe returns the bot's own user.
+func (c *TelegramClient) GetMe(ctx context.Context) (TelegramUser, error) {
+	var me TelegramUser
+	err := c.call(ctx, "getMe", struct{}{}, &me)
+	return me, err
+}
+
+// call posts params to a Bot API method and decodes its result into result,
+// returning a fetcherrors.FetchError for failures other than rate limiting.
+func (c *TelegramClient) call(ctx context.Context, method string, params, result interface{}) error {
+	if c.Token == "" {
+		return ErrTelegramNotConfigured
+	}
+	json_payload, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	// The token is part of the URL, which errors must not show
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/bot"+c.Token+"/"+method, bytes.NewReader(json_payload))
+	if err != nil {
+		return redact_error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return redact_error(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	var r telegram_response
+	if err := json.Unmarshal(body, &r); err != nil {
+		return fetcherrors.FetchError{Code: resp.StatusCode, Message: resp.Status}
+	}
+	if !r.OK {
+		if r.ErrorCode == http.StatusTooManyRequests {
+			return RateLimitError{RetryAfter: time.Duration(r.Parameters.RetryAfter) * time.Second}
+		}
+		return fetcherrors.FetchError{Code: r.ErrorCode, Message: method + ": " + r.Description}
+	}
+	if result != nil {
+		return json.Unmarshal(r.Result, result)
+	}
+	return nil
+}
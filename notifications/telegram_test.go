@@ -0,0 +1,191 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mechfeed/fetch-errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mechfeed/channels"
+)
+
+const telegram_token = "123456:AAHdqTcvCH1vGWJxfSeofSAs0K5PALDsaw"
+
+// Stand-in for the Bot API, answering each method with its canned response
+type telegram_api struct {
+	mu        sync.Mutex
+	calls     []string
+	params    []map[string]interface{}
+	responses map[string]string
+}
+
+func (api *telegram_api) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := strings.TrimPrefix(r.URL.Path, "/bot"+telegram_token+"/")
+	if method == r.URL.Path {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"ok":false,"error_code":404,"description":"Not Found"}`))
+		return
+	}
+	var params map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&params)
+	api.mu.Lock()
+	api.calls = append(api.calls, method)
+	api.params = append(api.params, params)
+	api.mu.Unlock()
+
+	response, ok := api.responses[method]
+	if !ok {
+		response = `{"ok":true,"result":true}`
+	}
+	var status struct {
+		ErrorCode int `json:"error_code"`
+	}
+	json.Unmarshal([]byte(response), &status)
+	if status.ErrorCode != 0 {
+		w.WriteHeader(status.ErrorCode)
+	}
+	w.Write([]byte(response))
+}
+
+func telegram_server(t *testing.T, responses map[string]string) (*TelegramClient, *telegram_api) {
+	api := &telegram_api{responses: responses}
+	server := httptest.NewServer(api)
+	t.Cleanup(server.Close)
+	c := NewTelegramClient(telegram_token)
+	c.BaseURL = server.URL
+	return c, api
+}
+
+func telegram_listing() channels.Event {
+	return channels.Event{
+		Source: "redditportal",
+		ID:     "1abc",
+		Title:  "[US-CA][H] GMK Olivia++ (base) [W] PayPal",
+		URL:    "https://reddit.com/r/mechmarket/comments/1abc",
+		Images: []string{"https://i.imgur.com/AbCdEf1.jpg"},
+		Author: channels.Author{Username: "keeb_seller"},
+	}
+}
+
+func TestEscapeMarkdownV2(t *testing.T) {
+	got := EscapeMarkdownV2(`[US-CA][H] GMK_Olivia++ (base) $150. Wow! a\b`)
+	expect := `\[US\-CA\]\[H\] GMK\_Olivia\+\+ \(base\) $150\. Wow\! a\\b`
+	if got != expect {
+		t.Errorf("got %s expect %s", got, expect)
+	}
+}
+
+func TestCreateTelegramNotification(t *testing.T) {
+	n := CreateTelegramNotification(telegram_listing(), "olivia", "$150", 7)
+	expect := []string{
+		`*[\[US\-CA\]\[H\] GMK Olivia\+\+ \(base\) \[W\] PayPal](https://reddit.com/r/mechmarket/comments/1abc)*`,
+		`*Posted by:* u/keeb\_seller`,
+		`*Price:* $150`,
+	}
+	for _, line := range expect {
+		if !strings.Contains(n.Text, line) {
+			t.Errorf("missing %q in\n%s", line, n.Text)
+		}
+	}
+	if n.Photo != "https://i.imgur.com/AbCdEf1.jpg" {
+		t.Errorf("got photo %q", n.Photo)
+	}
+	if len(n.Keyboard) != 1 || len(n.Keyboard[0]) != 2 || n.Keyboard[0][0].URL == "" || n.Keyboard[0][1].CallbackData != "mute:7" {
+		t.Errorf("got keyboard %+v", n.Keyboard)
+	}
+}
+
+func TestTelegramSend(t *testing.T) {
+	t.Run("photo with caption", func(t *testing.T) {
+		c, api := telegram_server(t, nil)
+		if err := c.Send(context.Background(), "42", CreateTelegramNotification(telegram_listing(), "olivia", "", 7)); err != nil {
+			t.Fatal(err)
+		}
+		if len(api.calls) != 1 || api.calls[0] != "sendPhoto" {
+			t.Fatalf("got calls %q", api.calls)
+		}
+		params := api.params[0]
+		if params["chat_id"] != "42" || params["parse_mode"] != "MarkdownV2" || params["photo"] != "https://i.imgur.com/AbCdEf1.jpg" {
+			t.Errorf("got params %v", params)
+		}
+		keyboard, _ := params["reply_markup"].(map[string]interface{})
+		if rows, _ := keyboard["inline_keyboard"].([]interface{}); len(rows) != 1 {
+			t.Errorf("got reply markup %v", params["reply_markup"])
+		}
+	})
+
+	t.Run("falls back to text when photo fails", func(t *testing.T) {
+		c, api := telegram_server(t, map[string]string{
+			"sendPhoto": `{"ok":false,"error_code":400,"description":"Bad Request: wrong file identifier/HTTP URL specified"}`,
+		})
+		if err := c.Send(context.Background(), "42", CreateTelegramNotification(telegram_listing(), "olivia", "", 7)); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(api.calls, " ") != "sendPhoto sendMessage" {
+			t.Errorf("got calls %q", api.calls)
+		}
+	})
+
+	t.Run("blocked bot", func(t *testing.T) {
+		c, _ := telegram_server(t, map[string]string{
+			"sendMessage": `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`,
+		})
+		err := c.SendText(context.Background(), "42", "hi")
+		var fetch_err fetcherrors.FetchError
+		if !errors.As(err, &fetch_err) || fetch_err.Code != 403 {
+			t.Errorf("got %v expect 403", err)
+		}
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		c, _ := telegram_server(t, map[string]string{
+			"sendMessage": `{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 5","parameters":{"retry_after":5}}`,
+		})
+		err := c.SendText(context.Background(), "42", "hi")
+		var rate_err RateLimitError
+		if !errors.As(err, &rate_err) || rate_err.RetryAfter != 5*time.Second {
+			t.Errorf("got %v expect rate limit", err)
+		}
+	})
+
+	t.Run("errors hide the token", func(t *testing.T) {
+		c := NewTelegramClient(telegram_token)
+		// Nothing listens on port 1 so the request fails before any response
+		c.BaseURL = "http://127.0.0.1:1"
+		err := c.SendText(context.Background(), "42", "hi")
+		if err == nil || strings.Contains(err.Error(), telegram_token) {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("not configured", func(t *testing.T) {
+		if err := NewTelegramClient("").SendText(context.Background(), "42", "hi"); !errors.Is(err, ErrTelegramNotConfigured) {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+func TestTelegramGetUpdates(t *testing.T) {
+	c, api := telegram_server(t, map[string]string{
+		"getUpdates": `{"ok":true,"result":[
+			{"update_id":10,"message":{"message_id":1,"chat":{"id":42,"type":"private"},"text":"/start ABCD2345"}},
+			{"update_id":11,"callback_query":{"id":"cb1","from":{"id":42},"message":{"message_id":2,"chat":{"id":42,"type":"private"}},"data":"mute:7"}}
+		]}`,
+	})
+	updates, err := c.GetUpdates(context.Background(), 10, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 || updates[0].Message.Text != "/start ABCD2345" || updates[1].CallbackQuery.Data != "mute:7" || updates[1].CallbackQuery.Message.Chat.ID != 42 {
+		t.Errorf("got updates %+v", updates)
+	}
+	if offset := api.params[0]["offset"]; offset != float64(10) {
+		t.Errorf("got offset %v", offset)
+	}
+}
@@ -0,0 +1,84 @@
+package notifications
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"mechfeed/channels"
+)
+
+// Markdown links of the embed fields, e.g. [r/mechmarket](https://...)
+var markdown_link = regexp.MustCompile(`\[(.+?)\]\((\S+?)\)`)
+
+// Title of a notification without embeds, the start of the body for untitled
+// messages
+func text_title(data channels.Event) string {
+	if data.Title != "" {
+		return data.Title
+	}
+	first, _, _ := strings.Cut(strings.TrimSpace(data.Body), "\n")
+	first = Truncate(first, 100)
+	if first == "" {
+		return "New message"
+	}
+	return first
+}
+
+// Truncate shortens s to n characters, ending with "..." when cut. Discord and
+// Slack limits count characters rather than bytes, and cutting runes in half
+// would leave invalid UTF-8.
+func Truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-3]) + "..."
+}
+
+// A notification field with its value formatted for a destination
+type text_field struct {
+	Name  string
+	Value string
+}
+
+// notification_fields with the text of their values escaped by escape and
+// links rewritten by link
+func format_fields(data channels.Event, alert, price string, escape func(string) string, link func(text, url string) string) []text_field {
+	var fields []text_field
+	for _, f := range notification_fields(data, alert, price) {
+		var value string
+		if f.Name == "Send Message" {
+			value = link("PM", data.Author.ContactURL)
+		} else {
+			start := 0
+			for _, m := range markdown_link.FindAllStringSubmatchIndex(f.Value, -1) {
+				value += escape(f.Value[start:m[0]]) + link(f.Value[m[2]:m[3]], f.Value[m[4]:m[5]])
+				start = m[1]
+			}
+			value += escape(f.Value[start:])
+		}
+		fields = append(fields, text_field{Name: f.Name, Value: value})
+	}
+	return fields
+}
+
+// notification_fields as "Name: value" lines, see format_fields
+func text_fields(data channels.Event, alert, price string, escape func(string) string, link func(text, url string) string) []string {
+	var lines []string
+	for _, f := range format_fields(data, alert, price, escape, link) {
+		lines = append(lines, escape(f.Name)+": "+f.Value)
+	}
+	return lines
+}
+
+func plain_text(s string) string {
+	return s
+}
+
+// Link as plain text
+func plain_link(text, url string) string {
+	if text == url {
+		return url
+	}
+	return text + " (" + url + ")"
+}
@@ -0,0 +1,308 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mechfeed/fetch-errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebhookClient posts to webhooks while honoring their rate limits.
+// Sends to the same webhook are queued behind each other and wait for the
+// bucket to reset once it runs out, and 429 responses are retried after the
+// delay Discord asks for. It is safe for concurrent use.
+type WebhookClient struct {
+	HTTP       *http.Client
+	MaxRetries int           // 429 responses retried per send
+	MaxWait    time.Duration // Longest a send waits on a rate limit before giving up
+	Global     bool          // Honor global 429s by pausing every send, only Discord has them
+	PerHost    bool          // Share one bucket between the webhooks of a host
+
+	mu           sync.Mutex
+	buckets      map[string]*bucket // Indexed by webhook URL, or host if PerHost
+	swept        time.Time          // Last time idle buckets were evicted
+	global_reset time.Time
+	stats        RateLimitStats
+}
+
+// Buckets unused for this long are forgotten, their rate limit has long reset
+const BUCKET_IDLE = time.Minute * 10
+
+// bucket is the rate limit state of a webhook, from the X-RateLimit-* headers.
+type bucket struct {
+	mu        sync.Mutex // Held for the duration of a send
+	remaining int
+	reset     time.Time
+
+	// Guarded by the client's mu
+	sends    int       // Sends holding or waiting for the bucket
+	last_use time.Time // End of the last send
+}
+
+// RateLimitStats counts the rate limiting seen by a WebhookClient.
+type RateLimitStats struct {
+	Sent        int64         // Successful sends
+	Failed      int64         // Sends that returned an error
+	RateLimited int64         // 429 responses
+	Global      int64         // 429 responses for the global rate limit
+	Waits       int64         // Sends delayed by an exhausted bucket or 429
+	Waited      time.Duration // Total time spent waiting
+	Buckets     int           // Webhooks sent to in the last BUCKET_IDLE
+}
+
+func (s RateLimitStats) String() string {
+	return fmt.Sprintf("%d sent, %d failed, %d rate limited (%d global), %d waits totalling %v across %d webhooks",
+		s.Sent, s.Failed, s.RateLimited, s.Global, s.Waits, s.Waited.Round(time.Millisecond), s.Buckets)
+}
+
+// RateLimitError is returned when a send would have to wait longer than
+// MaxWait or was still rate limited after MaxRetries.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e RateLimitError) Error() string {
+	return fmt.Sprintf("webhook rate limited, retry after %v", e.RetryAfter)
+}
+
+var DefaultWebhookClient = NewWebhookClient()
+
+// DefaultHookClient sends to Slack, JSON and ntfy webhooks, so that their rate
+// limits don't hold up Discord webhooks and the other way around.
+var DefaultHookClient = NewHookClient()
+
+func NewWebhookClient() *WebhookClient {
+	return &WebhookClient{
+		HTTP:       &http.Client{Timeout: time.Second * 15},
+		MaxRetries: 3,
+		MaxWait:    time.Minute,
+		Global:     true,
+		buckets:    make(map[string]*bucket),
+	}
+}
+
+// NewHookClient returns a client for webhooks other than Discord's. Their
+// servers rate limit by host rather than by webhook, and a 429 claiming to be
+// global only delays sends to that host. Since users pick any URL, it only
+// connects to public addresses and doesn't follow redirects.
+func NewHookClient() *WebhookClient {
+	c := NewWebhookClient()
+	c.Global = false
+	c.PerHost = true
+	dialer := &net.Dialer{Timeout: time.Second * 10, Control: public_dial}
+	c.HTTP = &http.Client{
+		Timeout: time.Second * 15,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: time.Second * 10,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     time.Second * 90,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return c
+}
+
+// Stats returns a copy of the client's rate limit counters.
+func (c *WebhookClient) Stats() RateLimitStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Buckets = len(c.buckets)
+	return stats
+}
+
+// Send posts message as JSON to a webhook, returning a fetcherrors.FetchError
+// for non-2xx responses other than 429.
+func (c *WebhookClient) Send(ctx context.Context, webhookURL string, message interface{}) error {
+	json_payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	b := c.bucket(webhookURL)
+	b.mu.Lock()
+	err = c.send(ctx, b, webhookURL, json_payload)
+	b.mu.Unlock()
+
+	c.mu.Lock()
+	b.sends--
+	b.last_use = time.Now()
+	if err != nil {
+		c.stats.Failed++
+	} else {
+		c.stats.Sent++
+	}
+	c.mu.Unlock()
+	return err
+}
+
+func (c *WebhookClient) send(ctx context.Context, b *bucket, webhookURL string, json_payload []byte) error {
+	for retries := 0; ; retries++ {
+		if err := c.wait(ctx, c.delay(b)); err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", webhookURL, bytes.NewReader(json_payload))
+		if err != nil {
+			return redact_error(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			return redact_error(err)
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+
+		b.update(resp.Header)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			retry_after := c.rate_limited(b, resp.Header, body)
+			if retries >= c.MaxRetries {
+				return RateLimitError{RetryAfter: retry_after}
+			}
+			continue
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fetcherrors.FetchError{
+				Code:    resp.StatusCode,
+				Message: strings.TrimSpace(resp.Status + " " + string(body)),
+			}
+		}
+		return nil
+	}
+}
+
+// bucket returns the bucket of a webhook, counting the caller as one of its
+// sends until Send is done with it.
+func (c *WebhookClient) bucket(webhookURL string) *bucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if now.Sub(c.swept) > BUCKET_IDLE {
+		c.evict_idle(now)
+		c.swept = now
+	}
+	key := webhookURL
+	if u, err := url.Parse(webhookURL); err == nil && c.PerHost {
+		key = strings.ToLower(u.Host)
+	}
+	b, ok := c.buckets[key]
+	if !ok {
+		b = &bucket{remaining: -1}
+		c.buckets[key] = b
+	}
+	b.sends++
+	return b
+}
+
+// evict_idle forgets the buckets that no send used in BUCKET_IDLE and that
+// aren't waiting on a reset, so that webhooks sent to once don't pile up.
+// Must hold c.mu.
+func (c *WebhookClient) evict_idle(now time.Time) {
+	for key, b := range c.buckets {
+		if b.sends == 0 && now.Sub(b.last_use) > BUCKET_IDLE && !b.reset.After(now) {
+			delete(c.buckets, key)
+		}
+	}
+}
+
+// delay returns how long to wait before the next request to b.
+func (c *WebhookClient) delay(b *bucket) time.Duration {
+	now := time.Now()
+	var delay time.Duration
+	if b.remaining == 0 && b.reset.After(now) {
+		delay = b.reset.Sub(now)
+	}
+	c.mu.Lock()
+	if global := c.global_reset.Sub(now); global > delay {
+		delay = global
+	}
+	c.mu.Unlock()
+	return delay
+}
+
+func (c *WebhookClient) wait(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+	if delay > c.MaxWait {
+		return RateLimitError{RetryAfter: delay}
+	}
+	c.mu.Lock()
+	c.stats.Waits++
+	c.stats.Waited += delay
+	c.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// update records the bucket headers of a response.
+func (b *bucket) update(h http.Header) {
+	if remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining")); err == nil {
+		b.remaining = remaining
+	}
+	if after, ok := parse_seconds(h.Get("X-RateLimit-Reset-After")); ok {
+		b.reset = time.Now().Add(after)
+	}
+}
+
+// rate_limited handles a 429 response and returns how long to back off.
+func (c *WebhookClient) rate_limited(b *bucket, h http.Header, body []byte) time.Duration {
+	var payload struct {
+		RetryAfter float64 `json:"retry_after"`
+		Global     bool    `json:"global"`
+	}
+	json.Unmarshal(body, &payload)
+
+	// The body has sub-second precision, Retry-After is whole seconds
+	retry_after := time.Duration(payload.RetryAfter * float64(time.Second))
+	if retry_after <= 0 {
+		retry_after, _ = parse_seconds(h.Get("Retry-After"))
+	}
+	if retry_after <= 0 {
+		retry_after = time.Second
+	}
+	global := c.Global && (payload.Global || h.Get("X-RateLimit-Global") == "true")
+	reset := time.Now().Add(retry_after)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.RateLimited++
+	if global {
+		c.stats.Global++
+		if reset.After(c.global_reset) {
+			c.global_reset = reset
+		}
+	} else {
+		b.remaining = 0
+		b.reset = reset
+	}
+	return retry_after
+}
+
+func parse_seconds(s string) (time.Duration, bool) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
@@ -0,0 +1,208 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"mechfeed/fetch-errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookClient(t *testing.T) {
+	t.Run("retries after 429", func(t *testing.T) {
+		var mu sync.Mutex
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests++
+			if requests == 1 {
+				w.Header().Set("Retry-After", "1")
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"message": "You are being rate limited.", "retry_after": 0.05, "global": false}`))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		c := NewWebhookClient()
+		start := time.Now()
+		if err := c.Send(context.Background(), server.URL, DiscordNoti{}); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Millisecond*50 {
+			t.Errorf("retried after %v, expected to wait for retry_after", elapsed)
+		}
+		stats := c.Stats()
+		if requests != 2 || stats.RateLimited != 1 || stats.Sent != 1 || stats.Waits != 1 {
+			t.Errorf("got %d requests, stats %+v", requests, stats)
+		}
+	})
+
+	t.Run("waits for exhausted bucket", func(t *testing.T) {
+		var mu sync.Mutex
+		var times []time.Time
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			times = append(times, time.Now())
+			w.Header().Set("X-RateLimit-Bucket", "abc")
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset-After", "0.05")
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		c := NewWebhookClient()
+		var wg sync.WaitGroup
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := c.Send(context.Background(), server.URL, DiscordNoti{}); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		for i := 1; i < len(times); i++ {
+			if gap := times[i].Sub(times[i-1]); gap < time.Millisecond*40 {
+				t.Errorf("request %d sent %v after the previous one", i, gap)
+			}
+		}
+		if stats := c.Stats(); stats.Sent != 3 || stats.Waits != 2 || stats.Buckets != 1 {
+			t.Errorf("got stats %+v", stats)
+		}
+	})
+
+	t.Run("gives up on long rate limits", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-RateLimit-Global", "true")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"retry_after": 120, "global": true}`))
+		}))
+		defer server.Close()
+
+		c := NewWebhookClient()
+		err := c.Send(context.Background(), server.URL, DiscordNoti{})
+		var rate_err RateLimitError
+		if !errors.As(err, &rate_err) || rate_err.RetryAfter < time.Minute {
+			t.Fatalf("got error %v expect RateLimitError", err)
+		}
+		if stats := c.Stats(); stats.Global != 1 || stats.Failed != 1 {
+			t.Errorf("got stats %+v", stats)
+		}
+	})
+
+	t.Run("non-2xx is an error", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message": "Unknown Webhook", "code": 10015}`))
+		}))
+		defer server.Close()
+
+		err := NewWebhookClient().Send(context.Background(), server.URL, DiscordNoti{})
+		var fetch_err fetcherrors.FetchError
+		if !errors.As(err, &fetch_err) || fetch_err.Code != http.StatusNotFound {
+			t.Fatalf("got error %v expect 404", err)
+		}
+	})
+
+	t.Run("forgets idle webhooks", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		c := NewWebhookClient()
+		for _, path := range []string{"/1", "/2"} {
+			if err := c.Send(context.Background(), server.URL+path, DiscordNoti{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c.buckets[server.URL+"/1"].last_use = time.Now().Add(-BUCKET_IDLE * 2)
+		c.swept = time.Now().Add(-BUCKET_IDLE * 2)
+		if err := c.Send(context.Background(), server.URL+"/3", DiscordNoti{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := c.buckets[server.URL+"/1"]; ok || c.Stats().Buckets != 2 {
+			t.Errorf("got %d buckets, expected the idle one to be evicted", c.Stats().Buckets)
+		}
+	})
+
+	t.Run("hook client keeps global 429s to the host", func(t *testing.T) {
+		var mu sync.Mutex
+		requests := map[string]int{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			requests[r.URL.Path]++
+			if r.URL.Path == "/limited" {
+				w.WriteHeader(http.StatusTooManyRequests)
+				w.Write([]byte(`{"retry_after": 120, "global": true}`))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+		other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer other.Close()
+
+		c := NewHookClient()
+		c.HTTP = &http.Client{} // The test servers are on loopback
+		var rate_err RateLimitError
+		if err := c.Send(context.Background(), server.URL+"/limited", JSONNoti{}); !errors.As(err, &rate_err) {
+			t.Fatalf("got error %v expect RateLimitError", err)
+		}
+		if err := c.Send(context.Background(), other.URL, JSONNoti{}); err != nil {
+			t.Errorf("other host was held up: %v", err)
+		}
+		// The host's bucket is exhausted, so the next send gives up before posting
+		if err := c.Send(context.Background(), server.URL+"/other", JSONNoti{}); !errors.As(err, &rate_err) {
+			t.Errorf("got error %v expect RateLimitError for the same host", err)
+		}
+		if requests["/other"] != 0 {
+			t.Errorf("sent %d requests to a rate limited host", requests["/other"])
+		}
+		if stats := c.Stats(); stats.Global != 0 || stats.Buckets != 2 {
+			t.Errorf("got stats %+v", stats)
+		}
+	})
+
+	t.Run("hook client only posts to public addresses", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("posted to a loopback address")
+		}))
+		defer server.Close()
+
+		err := NewHookClient().Send(context.Background(), server.URL, JSONNoti{})
+		if !errors.Is(err, ErrInvalidJSONURL) {
+			t.Errorf("got error %v expect ErrInvalidJSONURL", err)
+		}
+	})
+
+	t.Run("hook client doesn't follow redirects", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/hook" {
+				t.Error("followed redirect to", r.URL.Path)
+			}
+			http.Redirect(w, r, "/internal", http.StatusTemporaryRedirect)
+		}))
+		defer server.Close()
+
+		c := NewHookClient()
+		c.HTTP.Transport = http.DefaultTransport // Keeps CheckRedirect, allows loopback
+		err := c.Send(context.Background(), server.URL+"/hook", JSONNoti{})
+		var fetch_err fetcherrors.FetchError
+		if !errors.As(err, &fetch_err) || fetch_err.Code != http.StatusTemporaryRedirect {
+			t.Errorf("got error %v expect the redirect", err)
+		}
+	})
+}
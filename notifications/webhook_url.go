@@ -0,0 +1,75 @@
+package notifications
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+
+	"mechfeed/channels"
+)
+
+// Discord webhook URLs, e.g. https://discord.com/api/webhooks/<id>/<token>
+var discord_webhook = regexp.MustCompile(`^https://(?:(?:ptb|canary)\.)?discord(?:app)?\.com/api(?:/v\d+)?/webhooks/(\d{17,20})/([\w-]{30,100})$`)
+
+var ErrInvalidWebhookURL = errors.New("not a Discord webhook URL, copy it from Server Settings > Integrations > Webhooks, e.g. https://discord.com/api/webhooks/123/abc")
+
+// Slack incoming webhook URLs, e.g. https://hooks.slack.com/services/T000/B000/XXXX
+var slack_webhook = regexp.MustCompile(`^https://hooks\.slack\.com/(?:services|workflows|triggers)/[\w/-]+$`)
+
+// Payload formats of webhooks
+const (
+	FormatDiscord = "discord" // DiscordNoti
+	FormatSlack   = "slack"   // SlackNoti, also taken by Slack-compatible webhooks
+)
+
+// ValidateWebhookURL checks that s is a Discord webhook URL.
+func ValidateWebhookURL(s string) error {
+	if !discord_webhook.MatchString(s) {
+		return ErrInvalidWebhookURL
+	}
+	return nil
+}
+
+// WebhookFormat returns the payload format of a webhook by its URL, or ""
+// if it isn't a Discord or Slack webhook.
+func WebhookFormat(s string) string {
+	switch {
+	case discord_webhook.MatchString(s):
+		return FormatDiscord
+	case slack_webhook.MatchString(s):
+		return FormatSlack
+	}
+	return ""
+}
+
+// CreateWebhookNotification renders a notification in the format of the
+// webhook at url, Discord's unless it is a Slack webhook.
+func CreateWebhookNotification(url string, data channels.Event, alert, price string) interface{} {
+	if WebhookFormat(url) == FormatSlack {
+		return CreateSlackNotification(data, alert, price)
+	}
+	return CreateNotification(data, alert, price)
+}
+
+// RedactURL hides the secrets in a webhook URL so that it can be logged or
+// shown, keeping the ID of Discord webhooks to tell them apart.
+func RedactURL(s string) string {
+	if m := discord_webhook.FindStringSubmatch(s); m != nil {
+		return s[:len(s)-len(m[2])] + "***"
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return "***"
+	}
+	return u.Scheme + "://" + u.Host + "/***"
+}
+
+// redact_error hides the URL of failed requests, which net/http includes in
+// its errors.
+func redact_error(err error) error {
+	var url_err *url.Error
+	if errors.As(err, &url_err) {
+		url_err.URL = RedactURL(url_err.URL)
+	}
+	return err
+}
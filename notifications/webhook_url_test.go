@@ -0,0 +1,77 @@
+package notifications
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const webhook_token = "AbCdEfGhIjKlMnOpQrStUvWxYz0123456789-_AbCdEfGhIjKlMnOpQrStUvWxYz01"
+
+func TestValidateWebhookURL(t *testing.T) {
+	cases := []struct {
+		url   string
+		valid bool
+	}{
+		{"https://discord.com/api/webhooks/123456789012345678/" + webhook_token, true},
+		{"https://discordapp.com/api/webhooks/123456789012345678/" + webhook_token, true},
+		{"https://canary.discord.com/api/v10/webhooks/123456789012345678/" + webhook_token, true},
+		{"http://discord.com/api/webhooks/123456789012345678/" + webhook_token, false},
+		{"https://discord.com/api/webhooks/123456789012345678", false},
+		{"https://discord.com.evil.example/api/webhooks/123456789012345678/" + webhook_token, false},
+		{"https://example.com/api/webhooks/123456789012345678/" + webhook_token, false},
+		{"not a url", false},
+	}
+	for _, c := range cases {
+		if err := ValidateWebhookURL(c.url); (err == nil) != c.valid {
+			t.Errorf("%s: got %v expect valid %t", c.url, err, c.valid)
+		}
+	}
+}
+
+func TestWebhookFormat(t *testing.T) {
+	cases := []struct {
+		url    string
+		expect string
+	}{
+		{"https://discord.com/api/webhooks/123456789012345678/" + webhook_token, FormatDiscord},
+		{"https://hooks.slack.com/services/T0000000/B0000000/XXXXXXXXXXXXXXXXXXXXXXXX", FormatSlack},
+		{"https://hooks.slack.com/triggers/T0000000/1234/abcdef", FormatSlack},
+		{"http://hooks.slack.com/services/T0000000/B0000000/XXXX", ""},
+		{"https://hooks.slack.com.evil.example/services/T0000000/B0000000/XXXX", ""},
+		{"https://chat.example.com/hooks/abcdef", ""},
+	}
+	for _, c := range cases {
+		if got := WebhookFormat(c.url); got != c.expect {
+			t.Errorf("%s: got %q expect %q", c.url, got, c.expect)
+		}
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	cases := []struct {
+		url    string
+		expect string
+	}{
+		{"https://discord.com/api/webhooks/123456789012345678/" + webhook_token, "https://discord.com/api/webhooks/123456789012345678/***"},
+		{"https://hooks.example.com/services/T000/B000/secret", "https://hooks.example.com/***"},
+		{"::", "***"},
+	}
+	for _, c := range cases {
+		if got := RedactURL(c.url); got != c.expect {
+			t.Errorf("got %q expect %q", got, c.expect)
+		}
+	}
+}
+
+func TestSendErrorRedacted(t *testing.T) {
+	// Nothing listens on port 1 so the request fails before any response
+	url := "http://127.0.0.1:1/api/webhooks/123456789012345678/" + webhook_token
+	err := NewWebhookClient().Send(context.Background(), url, DiscordNoti{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if strings.Contains(err.Error(), webhook_token) {
+		t.Errorf("error contains webhook token: %v", err)
+	}
+}
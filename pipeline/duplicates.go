@@ -0,0 +1,392 @@
+package pipeline
+
+import (
+	"hash/fnv"
+	"mechfeed/channels"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Collapser groups listings cross-posted to several sources, e.g. the same
+// sale on r/mechmarket and two Discord servers, so that each user is only
+// notified once. Listings are duplicates if they share an image or album,
+// or if their text is similar enough, with a lower bar for listings that
+// appear to come from the same seller.
+type Collapser struct {
+	Window time.Duration // How long a listing is remembered
+	Hold   time.Duration // How long a notification waits for a likely crosspost
+
+	Similarity       float64 // Shingle containment for duplicates by text alone
+	AuthorSimilarity float64 // Shingle containment for duplicates by the same author
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	clusters []*cluster // In order of creation
+	next_seq int
+	swept    time.Time // Last time expired clusters were dropped
+	// Live clusters by the images and text shingles of their listings, as
+	// duplicates share one or the other, and by source and event ID
+	index map[string][]*cluster
+	by_id map[string]*cluster
+	// Identities of sellers last seen crossposting a listing
+	crossposters map[string]time.Time
+}
+
+// Expired clusters are dropped from the indexes this often, and expired
+// events from Dedupe
+const SWEEP_INTERVAL = time.Minute
+
+func NewCollapser(window, hold time.Duration) *Collapser {
+	return &Collapser{
+		Window:           window,
+		Hold:             hold,
+		Similarity:       0.6,
+		AuthorSimilarity: 0.3,
+		now:              time.Now,
+		index:            make(map[string][]*cluster),
+		by_id:            make(map[string]*cluster),
+		crossposters:     make(map[string]time.Time),
+	}
+}
+
+// cluster is a listing and its crossposts.
+type cluster struct {
+	seq      int
+	events   []channels.Event // In order of arrival
+	prints   []fingerprint
+	last     time.Time
+	notified map[string]bool // Users notified or about to be
+	expected bool            // The seller crossposts, so more sources may follow
+}
+
+type fingerprint struct {
+	shingles   map[uint64]struct{}
+	identities map[string]struct{}
+	images     map[string]struct{}
+}
+
+// keys are the index keys of a listing, any duplicate shares at least one.
+// Listings too short to compare by text are only found by their images.
+func (fp fingerprint) keys() []string {
+	var keys []string
+	for image := range fp.images {
+		keys = append(keys, "image:"+image)
+	}
+	if len(fp.shingles) >= MIN_SHINGLES {
+		for h := range fp.shingles {
+			keys = append(keys, "shingle:"+strconv.FormatUint(h, 16))
+		}
+	}
+	return keys
+}
+
+// add records ev and returns the cluster of listings it duplicates, and
+// whether ev is a crosspost posted somewhere the cluster wasn't yet.
+func (c *Collapser) add(ev channels.Event) (*cluster, bool) {
+	fp := fingerprint_event(ev)
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if now.Sub(c.swept) >= SWEEP_INTERVAL {
+		c.sweep(now)
+		c.swept = now
+	}
+
+	if cl, ok := c.by_id[event_key(ev)]; ok && c.live(cl, now) {
+		return cl, false
+	}
+
+	// The earliest cluster with a duplicate, as if they were all compared
+	var found *cluster
+	seen := make(map[*cluster]bool)
+	keys := fp.keys()
+	for _, key := range keys {
+		for _, cl := range c.index[key] {
+			if seen[cl] || !c.live(cl, now) || (found != nil && cl.seq > found.seq) {
+				continue
+			}
+			seen[cl] = true
+			for _, other := range cl.prints {
+				if c.duplicate(fp, other) {
+					found = cl
+					break
+				}
+			}
+		}
+	}
+
+	if found == nil {
+		found = &cluster{
+			seq:      c.next_seq,
+			notified: make(map[string]bool),
+			expected: c.crossposter(fp, now),
+		}
+		c.next_seq++
+		c.clusters = append(c.clusters, found)
+	}
+	crosspost := len(found.events) > 0 && !posted_in(found, venue(ev))
+	found.events = append(found.events, ev)
+	found.prints = append(found.prints, fp)
+	found.last = now
+	c.by_id[event_key(ev)] = found
+	for _, key := range keys {
+		if list := c.index[key]; len(list) == 0 || list[len(list)-1] != found {
+			c.index[key] = append(list, found)
+		}
+	}
+	if crosspost {
+		for _, other := range found.prints {
+			for identity := range other.identities {
+				c.crossposters[identity] = now
+			}
+		}
+	}
+	return found, crosspost
+}
+
+func (c *Collapser) live(cl *cluster, now time.Time) bool {
+	return now.Sub(cl.last) < c.Window
+}
+
+// crossposter reports whether the seller of a listing crossposted another
+// one within Window.
+func (c *Collapser) crossposter(fp fingerprint, now time.Time) bool {
+	for identity := range fp.identities {
+		if seen, ok := c.crossposters[identity]; ok && now.Sub(seen) < c.Window {
+			return true
+		}
+	}
+	return false
+}
+
+// sweep drops expired clusters and crossposters from the indexes.
+func (c *Collapser) sweep(now time.Time) {
+	live := c.clusters[:0]
+	for _, cl := range c.clusters {
+		if c.live(cl, now) {
+			live = append(live, cl)
+			continue
+		}
+		for i, ev := range cl.events {
+			if c.by_id[event_key(ev)] == cl {
+				delete(c.by_id, event_key(ev))
+			}
+			for _, key := range cl.prints[i].keys() {
+				c.unindex(key, cl)
+			}
+		}
+	}
+	for i := len(live); i < len(c.clusters); i++ {
+		c.clusters[i] = nil
+	}
+	c.clusters = live
+
+	for identity, seen := range c.crossposters {
+		if now.Sub(seen) >= c.Window {
+			delete(c.crossposters, identity)
+		}
+	}
+}
+
+func (c *Collapser) unindex(key string, cl *cluster) {
+	list := c.index[key][:0]
+	for _, other := range c.index[key] {
+		if other != cl {
+			list = append(list, other)
+		}
+	}
+	if len(list) == 0 {
+		delete(c.index, key)
+	} else {
+		c.index[key] = list
+	}
+}
+
+// pending reports whether a crosspost of the cluster is likely to show up,
+// as its seller crossposts and it has only been posted in one place so far.
+func (c *Collapser) pending(cl *cluster) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ev := range cl.events[1:] {
+		if venue(ev) != venue(cl.events[0]) {
+			return false
+		}
+	}
+	return cl.expected
+}
+
+// posted_in reports whether a listing of the cluster was posted in venue.
+func posted_in(cl *cluster, v string) bool {
+	for _, ev := range cl.events {
+		if venue(ev) == v {
+			return true
+		}
+	}
+	return false
+}
+
+// venue is where a listing was posted, crossposts are posted somewhere else,
+// e.g. another Discord server.
+func venue(ev channels.Event) string {
+	return ev.Source + "/" + ev.Server + "/" + ev.Channel
+}
+
+func event_key(ev channels.Event) string {
+	return ev.Source + ":" + ev.ID
+}
+
+// claim reports whether user hasn't been notified of the cluster yet and
+// marks them as notified.
+func (c *Collapser) claim(cl *cluster, user string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cl.notified[user] {
+		return false
+	}
+	cl.notified[user] = true
+	return true
+}
+
+// event returns ev, the listing of the cluster that matched, with the others
+// as its crossposts.
+func (c *Collapser) event(cl *cluster, ev channels.Event) channels.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ev.Crossposts = nil
+	for _, other := range cl.events {
+		if event_key(other) == event_key(ev) {
+			continue
+		}
+		ev.Crossposts = append(ev.Crossposts, channels.Sighting{
+			Source:    other.Source,
+			Server:    other.Server,
+			Channel:   other.Channel,
+			URL:       other.URL,
+			Timestamp: other.Timestamp,
+		})
+	}
+	return ev
+}
+
+// Listings with fewer shingles are too short to compare by text, e.g. "wtb
+// olivia"
+const MIN_SHINGLES = 8
+
+func (c *Collapser) duplicate(a, b fingerprint) bool {
+	if overlaps(a.images, b.images) {
+		return true
+	}
+	if len(a.shingles) < MIN_SHINGLES || len(b.shingles) < MIN_SHINGLES {
+		return false
+	}
+	similarity := containment(a.shingles, b.shingles)
+	if similarity >= c.Similarity {
+		return true
+	}
+	return similarity >= c.AuthorSimilarity && overlaps(a.identities, b.identities)
+}
+
+var (
+	non_word = regexp.MustCompile(`[^a-z0-9]+`)
+	// u/name mentions and reddit profile links, e.g. "reddit: u/seller"
+	reddit_user = regexp.MustCompile(`(?i)(?:^|[^a-z0-9_/])(?:/?u/|reddit\.com/u(?:ser)?/)([a-z0-9_-]{3,20})`)
+	// Album and image links
+	image_link = regexp.MustCompile(`(?i)https?://(?:i\.|m\.)?(?:imgur\.com|redd\.it|i\.redd\.it|cdn\.discordapp\.com|media\.discordapp\.net)/[^\s)\]>]+`)
+)
+
+func fingerprint_event(ev channels.Event) fingerprint {
+	fp := fingerprint{
+		shingles:   shingles(ev.Title + " " + ev.Body),
+		identities: make(map[string]struct{}),
+		images:     make(map[string]struct{}),
+	}
+
+	// Names are compared across sources, e.g. a Discord user posting as
+	// their Reddit username or mentioning it in the listing
+	add_name := func(name string) {
+		if name = non_word.ReplaceAllString(strings.ToLower(name), ""); len(name) >= 3 {
+			fp.identities["name:"+name] = struct{}{}
+		}
+	}
+	add_name(ev.Author.Username)
+	add_name(ev.Author.DisplayName)
+	if ev.Author.ID != "" {
+		fp.identities[ev.Source+":"+ev.Author.ID] = struct{}{}
+	}
+	for _, m := range reddit_user.FindAllStringSubmatch(ev.Body, -1) {
+		add_name(m[1])
+	}
+
+	add_image := func(link string) {
+		link = strings.ToLower(strings.TrimRight(link, "/.,"))
+		link = strings.TrimPrefix(strings.TrimPrefix(link, "https://"), "http://")
+		if i := strings.IndexAny(link, "?#"); i >= 0 {
+			link = link[:i]
+		}
+		if link != "" {
+			fp.images[link] = struct{}{}
+		}
+	}
+	for _, link := range ev.Images {
+		add_image(link)
+	}
+	if strings.HasPrefix(ev.Gallery, "http") {
+		add_image(ev.Gallery)
+	}
+	for _, link := range image_link.FindAllString(ev.Body, -1) {
+		add_image(link)
+	}
+	return fp
+}
+
+// shingles hashes every run of three consecutive words of normalized text,
+// or the words themselves for very short texts.
+func shingles(text string) map[uint64]struct{} {
+	words := strings.Fields(non_word.ReplaceAllString(strings.ToLower(text), " "))
+	size := 3
+	if len(words) < size {
+		size = 1
+	}
+	set := make(map[uint64]struct{})
+	for i := 0; i+size <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+size], " ")))
+		set[h.Sum64()] = struct{}{}
+	}
+	return set
+}
+
+// containment is the share of the smaller set found in the larger one, so a
+// short Discord post copied from a longer Reddit listing still matches.
+func containment(a, b map[uint64]struct{}) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for h := range a {
+		if _, ok := b[h]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a))
+}
+
+func overlaps(a, b map[string]struct{}) bool {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	for k := range a {
+		if _, ok := b[k]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package pipeline
+
+import (
+	"mechfeed/channels"
+	"mechfeed/listing"
+	"testing"
+	"time"
+)
+
+const listing_body = `Selling my GMK Olivia++ base kit, opened but never mounted.
+Timestamps: https://imgur.com/a/AbCdEf1
+Base kit $150 shipped CONUS, PayPal G&S only. Comment before PM please.`
+
+func reddit_post() channels.Event {
+	title := "[US-CA][H] GMK Olivia++ base [W] PayPal"
+	return channels.Event{
+		Source:  "redditportal",
+		ID:      "1abc",
+		Title:   title,
+		Body:    listing_body,
+		Author:  channels.Author{ID: "keebseller", Username: "keebseller"},
+		URL:     "https://reddit.com/r/mechmarket/comments/1abc",
+		Gallery: "https://imgur.com/a/AbCdEf1",
+		Server:  "r/mechmarket",
+		Listing: listing.ParseTitle(title),
+	}
+}
+
+func discord_post(id, body string, author channels.Author) channels.Event {
+	return channels.Event{
+		Source:  "discordportal",
+		ID:      id,
+		Body:    body,
+		Author:  author,
+		URL:     "https://discord.com/channels/1/2/" + id,
+		Server:  "Top Clack",
+		Channel: "buy-sell",
+	}
+}
+
+func TestCollapser(t *testing.T) {
+	seller := channels.Author{ID: "42", Username: "seller_42"}
+	cases := []struct {
+		name      string
+		discord   channels.Event
+		duplicate bool
+	}{
+		{"same album", discord_post("1", "GMK Olivia base for sale https://imgur.com/a/AbCdEf1", seller), true},
+		{"copied text", discord_post("2", "Selling my GMK Olivia++ base kit, opened but never mounted. Base kit $150 shipped CONUS, PayPal G&S only.", seller), true},
+		{
+			"same seller, reworded",
+			discord_post("3", "GMK Olivia++ base kit, opened but never mounted. Asking $150 shipped, DM me. Also on mechmarket as u/keebseller", seller),
+			true,
+		},
+		{
+			"other seller, reworded",
+			discord_post("4", "GMK Olivia++ base kit, opened but never mounted. Asking $150 shipped, DM me or check my other sales", seller),
+			false,
+		},
+		{"unrelated", discord_post("5", "WTB GMK Olivia++ base kit, will pay $150 shipped to the US, thanks in advance for any offers", seller), false},
+		{"short message", discord_post("6", "gmk olivia", channels.Author{ID: "7", Username: "keebseller"}), false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			collapser := NewCollapser(time.Hour, 0)
+			original, _ := collapser.add(reddit_post())
+			cl, crosspost := collapser.add(c.discord)
+			got := cl == original
+			if crosspost != got {
+				t.Errorf("got crosspost %t for duplicate %t", crosspost, got)
+			}
+			if got != c.duplicate {
+				t.Errorf("got duplicate %t expect %t", got, c.duplicate)
+			}
+		})
+	}
+
+	t.Run("forgets after window", func(t *testing.T) {
+		now := time.Now()
+		collapser := NewCollapser(time.Hour, 0)
+		collapser.now = func() time.Time { return now }
+		original, _ := collapser.add(reddit_post())
+		now = now.Add(time.Hour)
+		if cl, _ := collapser.add(discord_post("1", "https://imgur.com/a/AbCdEf1", seller)); cl == original {
+			t.Errorf("crosspost matched after window")
+		}
+		if len(collapser.clusters) != 1 || len(collapser.index["image:imgur.com/a/abcdef1"]) != 1 {
+			t.Errorf("expired cluster still indexed")
+		}
+	})
+
+	t.Run("earliest duplicate", func(t *testing.T) {
+		collapser := NewCollapser(time.Hour, 0)
+		first, _ := collapser.add(discord_post("1", "https://imgur.com/a/AbCdEf1", seller))
+		collapser.add(discord_post("2", "https://imgur.com/a/XyZxYz1", seller))
+		cl, _ := collapser.add(discord_post("3", "https://imgur.com/a/XyZxYz1 https://imgur.com/a/AbCdEf1", seller))
+		if cl != first {
+			t.Errorf("matched a later cluster")
+		}
+	})
+}
+
+// Makes keebseller a known crossposter with a listing no alert matches
+func crossposted(p *Pipeline) {
+	title := "[US-CA][H] Tofu65 [W] PayPal"
+	p.Handle(channels.Event{
+		Source:  "redditportal",
+		ID:      "0abc",
+		Title:   title,
+		Author:  channels.Author{ID: "keebseller", Username: "keebseller"},
+		Gallery: "https://imgur.com/a/ToFu651",
+		Listing: listing.ParseTitle(title),
+	})
+	p.Handle(discord_post("0", "Tofu65 https://imgur.com/a/ToFu651", channels.Author{ID: "42", Username: "keebseller"}))
+}
+
+func TestPipelineCrossposts(t *testing.T) {
+	setup := func() (*Pipeline, *recorder, chan channels.Event) {
+		r := &recorder{}
+		events := make(chan channels.Event, 10)
+		p := &Pipeline{
+			Matcher:  newMatcher(),
+			Collapse: NewCollapser(time.Hour, time.Hour),
+			Notifiers: []Notifier{r, NotifierFunc(func(ev channels.Event, m Match) error {
+				events <- ev
+				return nil
+			})},
+		}
+		return p, r, events
+	}
+	crosspost := discord_post("1", "GMK Olivia base https://imgur.com/a/AbCdEf1", channels.Author{ID: "42"})
+
+	t.Run("held until the crosspost", func(t *testing.T) {
+		p, r, events := setup()
+		crossposted(p)
+		if n := p.Handle(reddit_post()); n != 2 {
+			t.Errorf("got %d held notifications expect 2", n)
+		}
+		if len(r.got) != 0 {
+			t.Fatalf("notified %q before the crosspost", r.got)
+		}
+		if n := p.Handle(crosspost); n != 0 {
+			t.Errorf("got %d notifications for crosspost expect 0", n)
+		}
+		if len(r.got) != 2 {
+			t.Fatalf("got %q expect alice and bob once", r.got)
+		}
+		for i := 0; i < 2; i++ {
+			ev := <-events
+			if ev.ID != "1abc" || len(ev.Crossposts) != 1 || ev.Crossposts[0].URL != crosspost.URL {
+				t.Errorf("got event %s with crossposts %+v", ev.ID, ev.Crossposts)
+			}
+		}
+	})
+
+	t.Run("flushed without the crosspost", func(t *testing.T) {
+		p, r, _ := setup()
+		crossposted(p)
+		p.Handle(reddit_post())
+		p.Flush()
+		if len(r.got) != 2 {
+			t.Errorf("got %q expect alice and bob once", r.got)
+		}
+	})
+
+	t.Run("other sellers aren't held", func(t *testing.T) {
+		p, r, _ := setup()
+		p.Handle(reddit_post())
+		if len(r.got) != 2 {
+			t.Fatalf("got %q expect alice and bob right away", r.got)
+		}
+		p.Handle(crosspost)
+		if len(r.got) != 2 {
+			t.Errorf("got %q expect no notifications for the crosspost", r.got)
+		}
+	})
+
+	t.Run("notified with the matched listing", func(t *testing.T) {
+		p, r, events := setup()
+		unmatched := reddit_post()
+		unmatched.Title, unmatched.Body = "[US-CA][H] Keycaps [W] PayPal", "Timestamps: https://imgur.com/a/AbCdEf1"
+		unmatched.Listing = listing.ParseTitle(unmatched.Title)
+		p.Handle(unmatched)
+		p.Handle(crosspost)
+		if len(r.got) != 2 || r.got[0] != "alice:1" || r.got[1] != "bob:1" {
+			t.Fatalf("got %q expect alice and bob of the crosspost", r.got)
+		}
+		if ev := <-events; len(ev.Crossposts) != 1 || ev.Crossposts[0].Source != "redditportal" {
+			t.Errorf("got crossposts %+v", ev.Crossposts)
+		}
+	})
+}
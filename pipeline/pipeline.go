@@ -0,0 +1,206 @@
+package pipeline
+
+import (
+	"log"
+	"mechfeed/channels"
+	"mechfeed/filter"
+	"mechfeed/price"
+	"mechfeed/users"
+	"sync"
+	"time"
+)
+
+// Match is a user alert matched by an event.
+type Match struct {
+	Alert    users.UserAlert
+	User     users.User
+	Query    *filter.Query
+	Terms    []string    // Terms of the alert found in the event
+	Price    price.Price // Price nearest to the alert's keywords
+	HasPrice bool
+}
+
+// Matcher finds the alerts matching an event.
+type Matcher interface {
+	Match(ev channels.Event) []Match
+}
+
+// Filter drops matches that shouldn't be notified.
+type Filter interface {
+	Allow(ev channels.Event, m Match) bool
+}
+
+// Deduper reports whether an equivalent notification was already sent.
+type Deduper interface {
+	Seen(ev channels.Event, m Match) bool
+}
+
+// Notifier delivers a match to its user.
+type Notifier interface {
+	Notify(ev channels.Event, m Match) error
+}
+
+// NotifierFunc adapts a function to the Notifier interface.
+type NotifierFunc func(ev channels.Event, m Match) error
+
+func (f NotifierFunc) Notify(ev channels.Event, m Match) error {
+	return f(ev, m)
+}
+
+// Pipeline routes events through match -> filter -> dedupe -> collapse ->
+// notify.
+type Pipeline struct {
+	Matcher   Matcher
+	Filters   []Filter
+	Dedupe    Deduper    // Optional
+	Collapse  *Collapser // Optional
+	Notifiers []Notifier
+
+	mu      sync.Mutex
+	held    map[int]*held
+	next_id int
+}
+
+// held is a notification waiting for a likely crosspost of its listing.
+type held struct {
+	cluster *cluster
+	event   channels.Event // Listing the match is for
+	match   Match
+	timer   *time.Timer
+}
+
+// Handle runs an event through every stage and returns the number of matches
+// that were notified or held for crossposts. Notifier errors are logged and
+// don't stop the other notifiers.
+func (p *Pipeline) Handle(ev channels.Event) int {
+	var cl *cluster
+	if p.Collapse != nil {
+		var crosspost bool
+		cl, crosspost = p.Collapse.add(ev)
+		if crosspost {
+			// Held notifications were waiting for it
+			p.release(cl)
+		}
+	}
+
+	notified := 0
+	for _, m := range p.Matcher.Match(ev) {
+		if !p.allow(ev, m) {
+			continue
+		}
+		if p.Dedupe != nil && p.Dedupe.Seen(ev, m) {
+			continue
+		}
+		if cl != nil {
+			// Notified of a crosspost of this listing already
+			if !p.Collapse.claim(cl, m.User.ID) {
+				continue
+			}
+			p.hold(cl, ev, m)
+		} else {
+			p.notify(ev, m)
+		}
+		notified++
+	}
+	return notified
+}
+
+// Flush sends every notification still held for crossposts, e.g. before
+// shutting down.
+func (p *Pipeline) Flush() {
+	p.mu.Lock()
+	pending := p.held
+	p.held = nil
+	p.mu.Unlock()
+
+	for _, h := range pending {
+		if h.timer.Stop() {
+			p.notify(p.Collapse.event(h.cluster, h.event), h.match)
+		}
+	}
+}
+
+// release sends the notifications held for crossposts of a cluster.
+func (p *Pipeline) release(cl *cluster) {
+	var released []*held
+	p.mu.Lock()
+	for id, h := range p.held {
+		if h.cluster == cl && h.timer.Stop() {
+			released = append(released, h)
+			delete(p.held, id)
+		}
+	}
+	p.mu.Unlock()
+
+	for _, h := range released {
+		p.notify(p.Collapse.event(h.cluster, h.event), h.match)
+	}
+}
+
+// hold delays the notification of sellers who crosspost so that the
+// crosspost is listed in it, others are notified right away.
+func (p *Pipeline) hold(cl *cluster, ev channels.Event, m Match) {
+	if p.Collapse.Hold <= 0 || !p.Collapse.pending(cl) {
+		p.notify(p.Collapse.event(cl, ev), m)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.held == nil {
+		p.held = make(map[int]*held)
+	}
+	id := p.next_id
+	p.next_id++
+	h := &held{cluster: cl, event: ev, match: m}
+	h.timer = time.AfterFunc(p.Collapse.Hold, func() {
+		p.mu.Lock()
+		_, ok := p.held[id]
+		delete(p.held, id)
+		p.mu.Unlock()
+		if ok {
+			p.notify(p.Collapse.event(cl, ev), m)
+		}
+	})
+	p.held[id] = h
+}
+
+func (p *Pipeline) notify(ev channels.Event, m Match) {
+	for _, n := range p.Notifiers {
+		if err := n.Notify(ev, m); err != nil {
+			log.Printf("failed to notify %s of %s event %s: %v", m.User.Username, ev.Source, ev.ID, err)
+		}
+	}
+}
+
+func (p *Pipeline) allow(ev channels.Event, m Match) bool {
+	for _, f := range p.Filters {
+		if !f.Allow(ev, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// EventFields returns the fields alerts are matched against.
+func EventFields(ev channels.Event) filter.Fields {
+	fields := filter.Fields{
+		filter.FieldContent: ev.Body,
+		filter.FieldAuthor:  ev.Author.Username,
+	}
+	if ev.Author.DisplayName != "" && ev.Author.DisplayName != ev.Author.Username {
+		fields[filter.FieldAuthor] += "\n" + ev.Author.DisplayName
+	}
+	set := func(field, value string) {
+		if value != "" {
+			fields[field] = value
+		}
+	}
+	set(filter.FieldTitle, ev.Title)
+	set(filter.FieldFlair, ev.Category)
+	set(filter.FieldServer, ev.Server)
+	set(filter.FieldChannel, ev.Channel)
+	set(filter.FieldHave, ev.Listing.Have)
+	set(filter.FieldWant, ev.Listing.Want)
+	return fields
+}
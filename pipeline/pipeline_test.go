@@ -0,0 +1,280 @@
+package pipeline
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"mechfeed/channels"
+	"mechfeed/filter"
+	"mechfeed/listing"
+	"mechfeed/users"
+	"testing"
+	"time"
+)
+
+var alerts = map[int32]users.UserAlert{
+	1: {AlertID: 1, ID: "alice", Keyword: "olivia"},
+	2: {AlertID: 2, ID: "alice", Keyword: "gmk & olivia"},
+	3: {AlertID: 3, ID: "bob", Keyword: "olivia & loc:US price<200"},
+	4: {AlertID: 4, ID: "carol", Keyword: "title:kaze"},
+}
+
+func newMatcher() *IndexMatcher {
+	ix := filter.NewIndex()
+	for id, alert := range alerts {
+		ix.Set(id, alert.Keyword)
+	}
+	return &IndexMatcher{
+		Index: ix,
+		Alert: func(id int32) (users.UserAlert, bool) {
+			a, ok := alerts[id]
+			return a, ok
+		},
+		User: func(id string) (users.User, error) {
+			return users.User{ID: id, Username: id}, nil
+		},
+	}
+}
+
+func event(id, author, location string) channels.Event {
+	return channels.Event{
+		Source:  "test",
+		ID:      id,
+		Title:   "[" + location + "][H] GMK Olivia [W] PayPal",
+		Body:    "GMK Olivia base kit $150 shipped",
+		Author:  channels.Author{ID: author, Username: author},
+		Listing: listing.ParseTitle("[" + location + "][H] GMK Olivia [W] PayPal"),
+	}
+}
+
+func ignored(user string) []users.IgnoredAuthor {
+	if user == "bob" {
+		return []users.IgnoredAuthor{{ID: "bob", Source: "test", AuthorID: "spammer"}}
+	}
+	return nil
+}
+
+type recorder struct {
+	got []string
+}
+
+func (r *recorder) Notify(ev channels.Event, m Match) error {
+	r.got = append(r.got, m.User.ID+":"+ev.ID)
+	return nil
+}
+
+func TestPipeline(t *testing.T) {
+	cases := []struct {
+		name   string
+		events []channels.Event
+		expect []string
+	}{
+		{"one notification per user", []channels.Event{event("a", "seller", "US-CA")}, []string{"alice:a", "bob:a"}},
+		{"location constraint", []channels.Event{event("a", "seller", "CA-ON")}, []string{"alice:a"}},
+		{"ignored author", []channels.Event{event("a", "spammer", "US-CA")}, []string{"alice:a"}},
+		{"repeated event", []channels.Event{event("a", "seller", "US"), event("a", "seller", "US")}, []string{"alice:a", "bob:a"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := &recorder{}
+			p := &Pipeline{
+				Matcher:   newMatcher(),
+				Filters:   []Filter{ConstraintFilter{}, IgnoreFilter{Ignored: ignored}},
+				Dedupe:    NewDedupe(time.Hour),
+				Notifiers: []Notifier{r},
+			}
+			for _, ev := range c.events {
+				p.Handle(ev)
+			}
+			if len(r.got) != len(c.expect) {
+				t.Fatalf("got %q expect %q", r.got, c.expect)
+			}
+			for i := range r.got {
+				if r.got[i] != c.expect[i] {
+					t.Errorf("got %q expect %q", r.got, c.expect)
+				}
+			}
+		})
+	}
+
+	t.Run("failing notifier doesn't stop others", func(t *testing.T) {
+		r := &recorder{}
+		failing := NotifierFunc(func(channels.Event, Match) error { return errors.New("unreachable") })
+		p := &Pipeline{Matcher: newMatcher(), Notifiers: []Notifier{failing, r}}
+		if n := p.Handle(event("a", "seller", "US")); n != 3 || len(r.got) != 3 {
+			t.Errorf("got %d notified, %q expect 3", n, r.got)
+		}
+	})
+}
+
+func TestIndexMatcher(t *testing.T) {
+	ev := event("a", "seller", "US")
+	ev.Title = "Kaze Jimmy"
+	matches := newMatcher().Match(ev)
+	if len(matches) != 4 {
+		t.Fatalf("got %d matches expect 4", len(matches))
+	}
+	for _, m := range matches {
+		if !m.HasPrice || m.Price.Amount != 150 {
+			t.Errorf("alert %d: got price %+v expect $150", m.Alert.AlertID, m.Price)
+		}
+	}
+}
+
+func TestIgnoreFilter(t *testing.T) {
+	f := IgnoreFilter{Ignored: func(user string) []users.IgnoredAuthor {
+		return []users.IgnoredAuthor{
+			{ID: user, AuthorID: "Spammer"},
+			{ID: user, Source: "discordportal", AuthorID: "1234", AuthorName: "flipper"},
+			{ID: user, AlertID: sql.NullInt32{Int32: 2, Valid: true}, Source: "redditportal", AuthorID: "keebseller"},
+		}
+	}}
+	cases := []struct {
+		name   string
+		alert  int32
+		source string
+		author channels.Author
+		allow  bool
+	}{
+		{"blocked by name", 1, "redditportal", channels.Author{ID: "spammer", Username: "spammer"}, false},
+		{"blocked by name on discord", 1, "discordportal", channels.Author{ID: "999", Username: "spammer"}, false},
+		{"ignored by ID after rename", 1, "discordportal", channels.Author{ID: "1234", Username: "renamed"}, false},
+		{"same ID on another source", 1, "redditportal", channels.Author{ID: "1234", Username: "1234"}, true},
+		{"ignored on alert", 2, "redditportal", channels.Author{ID: "keebseller", Username: "keebseller"}, false},
+		{"ignored on another alert", 1, "redditportal", channels.Author{ID: "keebseller", Username: "keebseller"}, true},
+		{"not ignored", 1, "discordportal", channels.Author{ID: "5678", Username: "seller"}, true},
+	}
+	for _, c := range cases {
+		m := Match{Alert: users.UserAlert{AlertID: c.alert}, User: users.User{ID: "alice"}}
+		if got := f.Allow(channels.Event{Source: c.source, Author: c.author}, m); got != c.allow {
+			t.Errorf("%s: got allow %t expect %t", c.name, got, c.allow)
+		}
+	}
+}
+
+func TestPauseFilter(t *testing.T) {
+	now := time.Now()
+	f := PauseFilter{now: func() time.Time { return now }}
+	paused := func(until time.Time, valid bool) Match {
+		return Match{Alert: users.UserAlert{PausedUntil: sql.NullTime{Time: until, Valid: valid}}}
+	}
+	if !f.Allow(channels.Event{}, paused(time.Time{}, false)) {
+		t.Errorf("expected alert without pause to be allowed")
+	}
+	if f.Allow(channels.Event{}, paused(now.Add(time.Hour), true)) {
+		t.Errorf("expected paused alert to be dropped")
+	}
+	if !f.Allow(channels.Event{}, paused(now.Add(-time.Hour), true)) {
+		t.Errorf("expected alert to resume after its pause")
+	}
+	if f.Allow(channels.Event{}, Match{Alert: users.UserAlert{Paused: true}}) {
+		t.Errorf("expected alert paused until resumed to be dropped")
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	now := time.Now()
+	d := NewDedupe(time.Minute)
+	d.now = func() time.Time { return now }
+	ev := event("a", "seller", "US")
+	m := Match{User: users.User{ID: "alice"}}
+
+	if d.Seen(ev, m) {
+		t.Errorf("first event reported as seen")
+	}
+	if !d.Seen(ev, m) {
+		t.Errorf("repeated event not reported as seen")
+	}
+	if d.Seen(ev, Match{User: users.User{ID: "bob"}}) {
+		t.Errorf("event for another user reported as seen")
+	}
+	now = now.Add(time.Minute)
+	if d.Seen(ev, m) {
+		t.Errorf("event reported as seen after TTL")
+	}
+
+	// Expired events are dropped by the next sweep, not on every call
+	d.TTL = time.Second
+	d.Seen(event("b", "seller", "US"), m)
+	now = now.Add(time.Second * 30)
+	d.Seen(ev, m)
+	if len(d.seen) != 2 {
+		t.Errorf("got %d events remembered before the sweep expect 2", len(d.seen))
+	}
+	now = now.Add(SWEEP_INTERVAL)
+	d.Seen(ev, m)
+	if len(d.seen) != 1 {
+		t.Errorf("got %d events remembered after the sweep expect 1", len(d.seen))
+	}
+}
+
+func TestDestinationNotifier(t *testing.T) {
+	// 03:00 UTC, within quiet hours 23:00-07:00
+	now := time.Date(2024, 6, 1, 3, 0, 0, 0, time.UTC)
+	quiet := users.User{ID: "alice", QuietStart: sql.NullInt16{Int16: 23 * 60, Valid: true}, QuietEnd: sql.NullInt16{Int16: 7 * 60, Valid: true}, Timezone: "UTC"}
+	destinations := []users.UserDestination{
+		{DestinationID: 1, ID: "alice", Name: "dm", Kind: users.DestinationDM, Enabled: true},
+		{DestinationID: 2, ID: "alice", Name: "phone", Kind: users.DestinationNtfy, Enabled: true},
+		{DestinationID: 3, ID: "alice", Name: "old", Kind: users.DestinationSlack},
+	}
+	cases := []struct {
+		name  string
+		user  users.User
+		alert users.UserAlert
+		sent  []int32
+		held  []int32
+	}{
+		{"instant", users.User{ID: "alice"}, users.UserAlert{}, []int32{1, 2}, nil},
+		{"quiet hours", quiet, users.UserAlert{}, nil, []int32{1, 2}},
+		{"quiet hours routed to ntfy", quiet, users.UserAlert{Destinations: []int32{2}}, nil, []int32{2}},
+		{"urgent in quiet hours", quiet, users.UserAlert{Urgent: true}, []int32{1, 2}, nil},
+		{"digest", users.User{ID: "alice", DeliveryMode: "hourly"}, users.UserAlert{Urgent: true}, nil, []int32{1, 2}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var sent, held []int32
+			n := DestinationNotifier{
+				Destinations: func(user string) []users.UserDestination { return destinations },
+				Send: func(ev channels.Event, m Match, d users.UserDestination) error {
+					sent = append(sent, d.DestinationID)
+					return nil
+				},
+				Hold: func(ev channels.Event, m Match, d users.UserDestination) error {
+					held = append(held, d.DestinationID)
+					return nil
+				},
+				now: func() time.Time { return now },
+			}
+			if err := n.Notify(channels.Event{}, Match{User: c.user, Alert: c.alert}); err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(sent) != fmt.Sprint(c.sent) || fmt.Sprint(held) != fmt.Sprint(c.held) {
+				t.Errorf("got sent %v held %v expect sent %v held %v", sent, held, c.sent, c.held)
+			}
+		})
+	}
+}
+
+func TestEventFields(t *testing.T) {
+	ev := channels.Event{
+		Body:     "body",
+		Author:   channels.Author{Username: "user", DisplayName: "User"},
+		Server:   "Top Clack",
+		Category: "Selling",
+	}
+	fields := EventFields(ev)
+	expect := filter.Fields{
+		filter.FieldContent: "body",
+		filter.FieldAuthor:  "user\nUser",
+		filter.FieldServer:  "Top Clack",
+		filter.FieldFlair:   "Selling",
+	}
+	if len(fields) != len(expect) {
+		t.Fatalf("got %q expect %q", fields, expect)
+	}
+	for k, v := range expect {
+		if fields[k] != v {
+			t.Errorf("%q: got %q expect %q", k, fields[k], v)
+		}
+	}
+}
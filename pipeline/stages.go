@@ -0,0 +1,163 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mechfeed/channels"
+	"mechfeed/digest"
+	"mechfeed/filter"
+	"mechfeed/users"
+	"sync"
+	"time"
+)
+
+// IndexMatcher matches events against a compiled alert index.
+type IndexMatcher struct {
+	Index *filter.Index
+	Alert func(id int32) (users.UserAlert, bool)
+	User  func(id string) (users.User, error)
+}
+
+func (im *IndexMatcher) Match(ev channels.Event) []Match {
+	var matches []Match
+	fields := EventFields(ev)
+	for _, id := range im.Index.Match(fields) {
+		alert, ok := im.Alert(id)
+		if !ok {
+			continue
+		}
+		q, ok := im.Index.Query(id)
+		if !ok {
+			continue
+		}
+		user, err := im.User(alert.ID)
+		if err != nil {
+			log.Println("failed to fetch user: ", alert.ID, " , error: ", err)
+			continue
+		}
+		p, found := q.Price(ev.Body)
+		matches = append(matches, Match{
+			Alert:    alert,
+			User:     user,
+			Query:    q,
+			Terms:    q.MatchedTerms(fields),
+			Price:    p,
+			HasPrice: found,
+		})
+	}
+	return matches
+}
+
+// ConstraintFilter enforces the "loc:" and price constraints of alerts.
+type ConstraintFilter struct{}
+
+func (ConstraintFilter) Allow(ev channels.Event, m Match) bool {
+	return m.Query.MatchLocation(ev.Listing.Place()) && m.Query.MatchPrice(m.Price, m.HasPrice)
+}
+
+// IgnoreFilter drops events from authors the user ignores, on the alert or
+// on all of their alerts.
+type IgnoreFilter struct {
+	Ignored func(user string) []users.IgnoredAuthor
+}
+
+func (f IgnoreFilter) Allow(ev channels.Event, m Match) bool {
+	for _, ig := range f.Ignored(m.User.ID) {
+		if ig.Ignores(m.Alert.AlertID, ev.Source, ev.Author.ID, ev.Author.Username) {
+			log.Printf("Skipping alert... '%s' is ignored by %s", ev.Author.Username, m.User.Username)
+			return false
+		}
+	}
+	return true
+}
+
+// PauseFilter drops matches of paused alerts.
+type PauseFilter struct {
+	now func() time.Time
+}
+
+func (f PauseFilter) Allow(ev channels.Event, m Match) bool {
+	now := time.Now
+	if f.now != nil {
+		now = f.now
+	}
+	return !m.Alert.PausedAt(now())
+}
+
+// Dedupe notifies a user once per event, however many of their alerts match
+// it, and drops events seen again within TTL.
+type Dedupe struct {
+	TTL time.Duration
+	now func() time.Time
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	swept time.Time // Last time expired events were dropped, see SWEEP_INTERVAL
+}
+
+func NewDedupe(ttl time.Duration) *Dedupe {
+	return &Dedupe{
+		TTL:  ttl,
+		now:  time.Now,
+		seen: make(map[string]time.Time),
+	}
+}
+
+func (d *Dedupe) Seen(ev channels.Event, m Match) bool {
+	key := fmt.Sprintf("%s\x00%s\x00%s", m.User.ID, ev.Source, ev.ID)
+	now := d.now()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.swept) >= SWEEP_INTERVAL {
+		for k, t := range d.seen {
+			if now.Sub(t) >= d.TTL {
+				delete(d.seen, k)
+			}
+		}
+		d.swept = now
+	}
+	if t, ok := d.seen[key]; ok && now.Sub(t) < d.TTL {
+		return true
+	}
+	d.seen[key] = now
+	return false
+}
+
+// DestinationNotifier sends a match to every enabled destination its alert is
+// routed to. Matches of digest users, and of others during their quiet hours
+// unless the alert is urgent, are held for each destination instead.
+type DestinationNotifier struct {
+	Destinations func(user string) []users.UserDestination
+	Send         func(ev channels.Event, m Match, d users.UserDestination) error
+	Hold         func(ev channels.Event, m Match, d users.UserDestination) error
+	now          func() time.Time
+}
+
+func (n DestinationNotifier) Notify(ev channels.Event, m Match) error {
+	now := time.Now
+	if n.now != nil {
+		now = n.now
+	}
+	send := n.Send
+	if on_hold(m, now()) {
+		send = n.Hold
+	}
+	var errs []error
+	for _, d := range m.Alert.Routes(n.Destinations(m.User.ID)) {
+		if err := send(ev, m, d); err != nil {
+			errs = append(errs, fmt.Errorf("%s destination %s: %w", d.Kind, d.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Reports whether m is saved for its user's digest or until their quiet hours
+// end rather than sent at now
+func on_hold(m Match, now time.Time) bool {
+	if m.User.DeliveryMode != "" && m.User.DeliveryMode != digest.Instant {
+		return true
+	}
+	return !m.Alert.Urgent && digest.InQuietHours(m.User, now)
+}
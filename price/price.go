@@ -0,0 +1,136 @@
+package price
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Price is an amount of money found in a message.
+type Price struct {
+	Amount   float64
+	Currency string // ISO 4217 code, "USD" for a bare "$"
+	Text     string // As written, e.g. "$120"
+	Start    int    // Byte span of Text in the message
+	End      int
+}
+
+const amount = `(\d{1,3}(?:,\d{3})+|\d+)(\.\d{1,2})?`
+
+var (
+	// $120, C$ 95.50, €90
+	prefix_symbol = regexp.MustCompile(`(?i)(US\$|CAD?\$|C\$|AUD?\$|A\$|\$|€|£)\s?` + amount)
+	// 120$, 90 €, 150 USD, 80cad
+	suffix = regexp.MustCompile(`(?i)` + amount + `\s?(?:(\$|€|£)|(usd|cad|eur|gbp|aud)\b)`)
+	// USD 150
+	prefix_code = regexp.MustCompile(`(?i)\b(usd|cad|eur|gbp|aud)\s?` + amount)
+)
+
+var currencies = map[string]string{
+	"$":    "USD",
+	"US$":  "USD",
+	"C$":   "CAD",
+	"CA$":  "CAD",
+	"CAD$": "CAD",
+	"A$":   "AUD",
+	"AU$":  "AUD",
+	"AUD$": "AUD",
+	"€":    "EUR",
+	"£":    "GBP",
+	"USD":  "USD",
+	"CAD":  "CAD",
+	"EUR":  "EUR",
+	"GBP":  "GBP",
+	"AUD":  "AUD",
+}
+
+// ParseCurrency returns the ISO 4217 code of a currency symbol or code such
+// as "€" or "cad".
+func ParseCurrency(s string) (string, bool) {
+	code, ok := currencies[strings.ToUpper(s)]
+	return code, ok
+}
+
+// Extract returns every price in text, in order of appearance.
+func Extract(text string) []Price {
+	var prices []Price
+	add := func(m []int, amount_group int, currency_groups ...int) {
+		whole := strings.ReplaceAll(text[m[2*amount_group]:m[2*amount_group+1]], ",", "")
+		if m[2*amount_group+2] >= 0 {
+			whole += text[m[2*amount_group+2]:m[2*amount_group+3]]
+		}
+		value, err := strconv.ParseFloat(whole, 64)
+		if err != nil {
+			return
+		}
+		currency := ""
+		for _, g := range currency_groups {
+			if m[2*g] >= 0 {
+				currency = strings.ToUpper(text[m[2*g]:m[2*g+1]])
+			}
+		}
+		prices = append(prices, Price{
+			Amount:   value,
+			Currency: currencies[currency],
+			Text:     text[m[0]:m[1]],
+			Start:    m[0],
+			End:      m[1],
+		})
+	}
+
+	for _, m := range prefix_symbol.FindAllStringSubmatchIndex(text, -1) {
+		add(m, 2, 1)
+	}
+	for _, m := range suffix.FindAllStringSubmatchIndex(text, -1) {
+		add(m, 1, 3, 4)
+	}
+	for _, m := range prefix_code.FindAllStringSubmatchIndex(text, -1) {
+		add(m, 2, 1)
+	}
+
+	// Drop prices overlapping an earlier one, e.g. "$120 USD"
+	sort.SliceStable(prices, func(i, j int) bool { return prices[i].Start < prices[j].Start })
+	var result []Price
+	for _, p := range prices {
+		if len(result) > 0 && p.Start < result[len(result)-1].End {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// Nearest returns the price in text closest to any of the byte spans, which
+// are usually the positions of matched keywords. Prices on the same line as
+// a span are preferred since listings tend to put one item per line. Without
+// spans the first price is returned.
+func Nearest(text string, prices []Price, spans [][]int) (Price, bool) {
+	if len(prices) == 0 {
+		return Price{}, false
+	}
+	if len(spans) == 0 {
+		return prices[0], true
+	}
+
+	best, best_distance := 0, -1
+	for i, p := range prices {
+		for _, span := range spans {
+			from, to := p.End, span[0]
+			if p.Start >= span[1] {
+				from, to = span[1], p.Start
+			}
+			distance := 0
+			if from < to {
+				distance = to - from
+				if strings.Contains(text[from:to], "\n") {
+					distance += len(text)
+				}
+			}
+			if best_distance < 0 || distance < best_distance {
+				best, best_distance = i, distance
+			}
+		}
+	}
+	return prices[best], true
+}
@@ -0,0 +1,52 @@
+package price
+
+import "testing"
+
+func TestExtract(t *testing.T) {
+	const text = "GMK Olivia $120 shipped, Kaze 150 USD, SA Bliss €90, " +
+		"Bro C$1,250.50 OBO, desk mat 40$ , cables CAD 35 and 2 keycaps"
+	expect := []Price{
+		{Amount: 120, Currency: "USD", Text: "$120"},
+		{Amount: 150, Currency: "USD", Text: "150 USD"},
+		{Amount: 90, Currency: "EUR", Text: "€90"},
+		{Amount: 1250.50, Currency: "CAD", Text: "C$1,250.50"},
+		{Amount: 40, Currency: "USD", Text: "40$"},
+		{Amount: 35, Currency: "CAD", Text: "CAD 35"},
+	}
+	got := Extract(text)
+	if len(got) != len(expect) {
+		t.Fatalf("got %+v expect %+v", got, expect)
+	}
+	for i := range got {
+		if got[i].Amount != expect[i].Amount || got[i].Currency != expect[i].Currency || got[i].Text != expect[i].Text {
+			t.Errorf("got %+v expect %+v", got[i], expect[i])
+		}
+		if text[got[i].Start:got[i].End] != got[i].Text {
+			t.Errorf("span of %q doesn't match text", got[i].Text)
+		}
+	}
+}
+
+func TestNearest(t *testing.T) {
+	const text = "GMK Olivia $120 shipped\nKaze keyboard 150 USD"
+	prices := Extract(text)
+
+	t.Run("closest to keyword", func(t *testing.T) {
+		got, ok := Nearest(text, prices, [][]int{{24, 28}}) // "Kaze"
+		if !ok || got.Amount != 150 {
+			t.Errorf("got %+v expect 150 USD", got)
+		}
+	})
+	t.Run("first without keywords", func(t *testing.T) {
+		got, ok := Nearest(text, prices, nil)
+		if !ok || got.Amount != 120 {
+			t.Errorf("got %+v expect $120", got)
+		}
+	})
+	t.Run("no prices", func(t *testing.T) {
+		_, ok := Nearest(text, nil, [][]int{{0, 3}})
+		if ok {
+			t.Errorf("expected no price")
+		}
+	})
+}
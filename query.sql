@@ -10,19 +10,30 @@ SELECT 1 FROM users
 WHERE id = $1 LIMIT 1;
 
 -- name: CreateUser :one
-INSERT INTO users (
-  id, username, webhook_url
-) VALUES (
-  $1, $2, $3
+WITH new_user AS (
+  INSERT INTO users (
+    id, username
+  ) VALUES (
+    $1, $2
+  )
+  RETURNING *
+), dm AS (
+  INSERT INTO user_destinations (id, name, kind, target)
+  SELECT id, 'dm', 'dm', id FROM new_user
 )
-RETURNING *;
+SELECT * FROM new_user;
 
 -- name: GetAlerts :many
 SELECT * FROM user_alerts;
 
+-- name: GetAlert :one
+SELECT * FROM user_alerts
+WHERE alert_id = $1 LIMIT 1;
+
 -- name: GetUserAlerts :many
 SELECT * FROM user_alerts
-WHERE id = $1;
+WHERE id = $1
+ORDER BY alert_id;
 
 -- name: CreateAlert :exec
 INSERT INTO user_alerts (
@@ -39,7 +50,258 @@ WHERE alert_id = $1;
 DELETE FROM user_alerts
 WHERE id = $1;
 
--- name: IgnoreUserForAlert :exec
+-- name: UpdateAlertKeyword :exec
+UPDATE user_alerts
+SET keyword = $2
+WHERE alert_id = $1;
+
+-- name: AddIgnoredAuthor :exec
+INSERT INTO ignored_authors (
+  id, alert_id, source, author_id, author_name
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT DO NOTHING;
+
+-- name: GetIgnoredAuthors :many
+SELECT * FROM ignored_authors;
+
+-- name: GetUserIgnoredAuthors :many
+SELECT * FROM ignored_authors
+WHERE id = $1
+ORDER BY alert_id NULLS FIRST, created;
+
+-- name: DeleteIgnoredAuthors :execrows
+DELETE FROM ignored_authors
+WHERE id = sqlc.arg(id)
+  AND (sqlc.narg(alert_id)::INT IS NULL OR alert_id = sqlc.narg(alert_id))
+  AND (LOWER(author_id) = LOWER(sqlc.arg(author)) OR LOWER(author_name) = LOWER(sqlc.arg(author)));
+
+-- name: PauseAlert :exec
+UPDATE user_alerts
+SET paused = FALSE, paused_until = NOW() + make_interval(secs => sqlc.arg(duration_seconds))
+WHERE alert_id = sqlc.arg(alert_id);
+
+-- name: PauseAlertIndefinitely :exec
+UPDATE user_alerts
+SET paused = TRUE, paused_until = NULL
+WHERE alert_id = $1;
+
+-- name: ResumeAlert :exec
+UPDATE user_alerts
+SET paused = FALSE, paused_until = NULL
+WHERE alert_id = $1;
+
+-- name: EnqueueNotification :one
+INSERT INTO notification_outbox (
+  user_id, alert_id, kind, target, payload, history_id
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+)
+RETURNING id;
+
+-- name: ClaimNotifications :many
+UPDATE notification_outbox
+SET attempts = attempts + 1,
+    next_attempt = NOW() + make_interval(secs => sqlc.arg(lease_seconds))
+WHERE id IN (
+  SELECT id FROM notification_outbox
+  WHERE status = 'pending' AND next_attempt <= NOW()
+  ORDER BY next_attempt
+  LIMIT sqlc.arg(max_claimed)
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkNotificationDelivered :exec
+UPDATE notification_outbox
+SET status = 'delivered', delivered = NOW(), last_error = NULL
+WHERE id = $1;
+
+-- name: RetryNotification :exec
+UPDATE notification_outbox
+SET next_attempt = NOW() + make_interval(secs => sqlc.arg(delay_seconds)), last_error = sqlc.arg(last_error)
+WHERE id = sqlc.arg(id);
+
+-- name: DeadLetterNotification :exec
+UPDATE notification_outbox
+SET status = 'dead', last_error = $2
+WHERE id = $1;
+
+-- name: DeleteDeliveredNotifications :exec
+DELETE FROM notification_outbox
+WHERE status = 'delivered' AND delivered < NOW() - make_interval(secs => sqlc.arg(age_seconds));
+
+-- name: CreateHistory :one
+INSERT INTO notification_history (
+  id, alert_id, keyword, source, message_id, title, url, matched_terms, delivery
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9
+)
+RETURNING history_id;
+
+-- name: UpdateNotificationHistory :exec
+UPDATE notification_history
+SET status = $2
+WHERE history_id = (
+  SELECT history_id FROM notification_outbox
+  WHERE notification_outbox.id = $1
+);
+
+-- name: GetUserHistory :many
+SELECT * FROM notification_history
+WHERE id = $1
+ORDER BY created DESC
+LIMIT $2;
+
+-- name: GetUserHistorySince :many
+SELECT * FROM notification_history
+WHERE id = $1 AND created >= NOW() - make_interval(secs => sqlc.arg(age_seconds))
+ORDER BY created DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: UpdateUserDelivery :exec
+UPDATE users
+SET delivery_mode = $2, digest_at = $3, timezone = $4,
+    -- Instant users are sent what is left from their digest, see digest.UserDue
+    digest_sent = CASE WHEN $2 = 'instant' THEN NULL ELSE digest_sent END
+WHERE id = $1;
+
+-- name: MarkDigestSent :exec
+UPDATE users
+SET digest_sent = NOW()
+WHERE id = $1;
+
+-- name: AddDigestItem :exec
+INSERT INTO digest_items (
+  id, alert_id, history_id, keyword, source, title, url, place, price, destination_id
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+);
+
+-- name: GetDigestItems :many
+SELECT * FROM digest_items
+WHERE id = $1
+ORDER BY digest_item_id;
+
+-- name: DeleteDigestItems :exec
+DELETE FROM digest_items
+WHERE digest_item_id = ANY($1::BIGINT[]);
+
+-- name: MarkHistoryDelivered :exec
+UPDATE notification_history
+SET status = 'delivered'
+WHERE history_id = ANY(sqlc.arg(history_ids)::BIGINT[]);
+
+-- name: UpdateUserTimezone :exec
+UPDATE users
+SET timezone = $2
+WHERE id = $1;
+
+-- name: UpdateUserQuietHours :exec
+UPDATE users
+SET quiet_start = $2, quiet_end = $3
+WHERE id = $1;
+
+-- name: SetAlertUrgent :exec
+UPDATE user_alerts
+SET urgent = $2
+WHERE alert_id = $1;
+
+-- name: GetDestinations :many
+SELECT * FROM user_destinations
+ORDER BY destination_id;
+
+-- name: GetUserDestinations :many
+SELECT * FROM user_destinations
+WHERE id = $1
+ORDER BY destination_id;
+
+-- name: AddDestination :one
+INSERT INTO user_destinations (
+  id, name, kind, target, enabled
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT (id, name) DO UPDATE
+SET kind = EXCLUDED.kind, target = EXCLUDED.target, enabled = EXCLUDED.enabled
+RETURNING destination_id;
+
+-- name: DeleteDestination :one
+DELETE FROM user_destinations
+WHERE id = $1 AND name = $2
+RETURNING destination_id;
+
+-- name: SetDestinationEnabled :execrows
+-- Email destinations can't be enabled until they're confirmed
+UPDATE user_destinations
+SET enabled = $3
+WHERE id = $1 AND name = $2 AND (NOT $3 OR kind <> 'email' OR NOT EXISTS (
+  SELECT 1 FROM destination_confirmations c
+  WHERE c.destination_id = user_destinations.destination_id
+));
+
+-- name: SetAlertDestinations :exec
 UPDATE user_alerts
-SET ignored = ignored || $1
-WHERE id = $2 AND keyword = $3;
\ No newline at end of file
+SET destinations = $2
+WHERE alert_id = $1;
+
+-- name: RemoveAlertDestination :many
+-- Alerts without destinations are sent to all of them, so the alerts only
+-- routed to the removed destination are paused and their keywords returned
+WITH removed AS (
+  UPDATE user_alerts
+  SET destinations = array_remove(destinations, sqlc.arg(destination_id)::INT),
+      paused = paused OR destinations = ARRAY[sqlc.arg(destination_id)::INT],
+      paused_until = CASE WHEN destinations = ARRAY[sqlc.arg(destination_id)::INT] THEN NULL ELSE paused_until END
+  WHERE id = $1 AND sqlc.arg(destination_id)::INT = ANY(destinations)
+  RETURNING keyword, destinations
+)
+SELECT keyword FROM removed
+WHERE cardinality(destinations) = 0;
+
+-- name: CreateDestinationConfirmation :exec
+INSERT INTO destination_confirmations (
+  destination_id, code, expires
+) VALUES (
+  $1, $2, NOW() + make_interval(secs => sqlc.arg(ttl_seconds))
+)
+ON CONFLICT (destination_id) DO UPDATE
+SET code = EXCLUDED.code, expires = EXCLUDED.expires;
+
+-- name: ConfirmDestination :execrows
+WITH confirmed AS (
+  DELETE FROM destination_confirmations c
+  USING user_destinations d
+  WHERE c.destination_id = d.destination_id AND d.id = $1 AND d.name = $2
+    AND c.code = $3 AND c.expires > NOW()
+  RETURNING c.destination_id
+)
+UPDATE user_destinations
+SET enabled = TRUE
+WHERE destination_id IN (SELECT destination_id FROM confirmed);
+
+-- name: IsDestinationUnconfirmed :one
+SELECT EXISTS (
+  SELECT 1 FROM destination_confirmations
+  WHERE destination_id = $1
+);
+
+-- name: CreateTelegramLinkCode :exec
+INSERT INTO telegram_link_codes (
+  id, code, expires
+) VALUES (
+  $1, $2, NOW() + make_interval(secs => sqlc.arg(ttl_seconds))
+)
+ON CONFLICT (id) DO UPDATE
+SET code = EXCLUDED.code, expires = EXCLUDED.expires;
+
+-- name: TakeTelegramLinkCode :one
+DELETE FROM telegram_link_codes
+WHERE code = $1 AND expires > NOW()
+RETURNING id;
+
+-- name: GetDestinationsByTarget :many
+SELECT * FROM user_destinations
+WHERE kind = $1 AND target = $2
+ORDER BY destination_id;
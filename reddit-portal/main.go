@@ -1,12 +1,15 @@
 package redditportal
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"log"
 	"mechfeed/channels"
 	"mechfeed/fetch-errors"
+	"mechfeed/listing"
+	"mechfeed/sources"
 	"net/http"
 	"os"
 	"regexp"
@@ -18,6 +21,7 @@ const (
 	REDDIT_AUTH_ENDPOINT = "https://www.reddit.com/api/v1/access_token"
 	REDDIT_POST_ENDPOINT = "https://oauth.reddit.com/r/mechmarket/new.json"
 	IMGUR_ALBUM_ENDPOINT = "https://api.imgur.com/post/v1/albums/"
+	SOURCE_NAME          = "redditportal"
 )
 
 var (
@@ -52,12 +56,22 @@ func init_app() error {
 	return nil
 }
 
-func Monitor() {
+// Source polls r/mechmarket for new posts
+type Source struct{}
+
+func init() {
+	sources.Register(Source{})
+}
+
+func (Source) Name() string {
+	return SOURCE_NAME
+}
+
+func (Source) Start(ctx context.Context, out chan<- channels.Event) error {
 	var err error
 	if err = init_app(); err != nil {
-		log.Fatal(err)
+		return err
 	}
-	defer panic("exited redditportal")
 
 	var currID string
 	check_expiry := 300
@@ -73,10 +87,17 @@ func Monitor() {
 			}
 		}
 		check_expiry--
-		time.Sleep(2 * time.Second)
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 		var res RedditResponse
 
-		if err := getLatest(&res); err != nil {
+		if err := getLatest(ctx, &res); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
 			log.Print(err.Error())
 			continue
 		}
@@ -94,7 +115,9 @@ func Monitor() {
 		for i := len(res.Data.Children) - 1; i >= 0; i-- {
 			post := res.Data.Children[i].Data
 			if postPivot {
-				process_reddit_post(post)
+				if err := sources.Emit(ctx, out, process_reddit_post(post)); err != nil {
+					return err
+				}
 			} else if post.ID == currID {
 				postPivot = true
 				continue
@@ -145,9 +168,9 @@ func get_reddit_auth() (RedditAuth, error) {
 	return RedditAuth{access_token: auth_info.AccessToken, expires_at: expiration_time}, nil
 }
 
-func getLatest(result *RedditResponse) error {
+func getLatest(ctx context.Context, result *RedditResponse) error {
 	client := &http.Client{}
-	req, err := http.NewRequest("GET", REDDIT_POST_ENDPOINT, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", REDDIT_POST_ENDPOINT, nil)
 	if err != nil {
 		return err
 	}
@@ -178,7 +201,7 @@ func getLatest(result *RedditResponse) error {
 	return nil
 }
 
-func process_reddit_post(post RawRedditPost) {
+func process_reddit_post(post RawRedditPost) channels.Event {
 	imgurLinks := extract_imgur_links(post.HTMLText)
 	var imgurAlbumLink string = "No Imgur link found"
 	var thumbnailLink string
@@ -202,15 +225,28 @@ func process_reddit_post(post RawRedditPost) {
 		category = post.LinkFlairText
 	}
 
-	channels.RedditChannel <- channels.RedditMessage{
-		ID:        post.ID,
-		Title:     post.Title,
+	var images []string
+	if thumbnailLink != "" {
+		images = []string{thumbnailLink}
+	}
+
+	return channels.Event{
+		Source: SOURCE_NAME,
+		ID:     post.ID,
+		Title:  post.Title,
+		Body:   post.Content,
+		Author: channels.Author{
+			ID:         post.Author,
+			Username:   post.Author,
+			ContactURL: "https://www.reddit.com/message/compose/?to=" + post.Author,
+		},
 		URL:       post.URL,
-		Author:    post.Author,
+		Images:    images,
+		Gallery:   imgurAlbumLink,
+		Timestamp: time.Unix(int64(post.Created), 0),
+		Server:    "r/mechmarket",
 		Category:  category,
-		Imgur:     imgurAlbumLink,
-		Thumbnail: thumbnailLink,
-		Content:   post.Content,
+		Listing:   listing.ParseTitle(post.Title),
 	}
 }
 
@@ -1,14 +1,226 @@
 CREATE TABLE IF NOT EXISTS users (
     id VARCHAR(36) PRIMARY KEY,
     username VARCHAR(255) NOT NULL,
-    webhook_url VARCHAR(2083),
     created timestamp DEFAULT NOW()
 );
 
+-- Delivery preferences, see the digest package
+ALTER TABLE users ADD COLUMN IF NOT EXISTS delivery_mode VARCHAR(16) NOT NULL DEFAULT 'instant';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS digest_at SMALLINT NOT NULL DEFAULT 540; -- Minutes after local midnight
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS digest_sent TIMESTAMPTZ;
+
+-- Quiet hours in minutes after local midnight, DMs are held in between
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_start SMALLINT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS quiet_end SMALLINT;
+
 CREATE TABLE IF NOT EXISTS user_alerts (
     alert_id SERIAL PRIMARY KEY,
     id VARCHAR(36) NOT NULL,
     keyword VARCHAR(255) NOT NULL,
-    ignored VARCHAR(255)[] DEFAULT '{}',
     FOREIGN KEY (id) REFERENCES users(id) ON DELETE CASCADE
 );
+
+-- Urgent alerts are notified during quiet hours
+ALTER TABLE user_alerts ADD COLUMN IF NOT EXISTS urgent BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Paused alerts aren't matched until paused_until, or until resumed if
+-- paused is set
+ALTER TABLE user_alerts ADD COLUMN IF NOT EXISTS paused_until TIMESTAMPTZ;
+ALTER TABLE user_alerts ADD COLUMN IF NOT EXISTS paused BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Where a user's notifications are sent, see users.Destination* for the
+-- kinds. target is the user ID for DMs, a webhook URL, ntfy topic, email
+-- address or Telegram chat ID otherwise.
+CREATE TABLE IF NOT EXISTS user_destinations (
+    destination_id SERIAL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL,
+    name VARCHAR(32) NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    target TEXT NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    created TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_destinations_name ON user_destinations (id, name);
+
+-- One-time codes given by !telegram, sent to the Telegram bot from the chat
+-- to link as a destination
+CREATE TABLE IF NOT EXISTS telegram_link_codes (
+    id VARCHAR(36) PRIMARY KEY,
+    code VARCHAR(16) NOT NULL UNIQUE,
+    expires TIMESTAMPTZ NOT NULL,
+    FOREIGN KEY (id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Codes emailed to new email destinations, which stay disabled until the code
+-- is given to !destination confirm
+CREATE TABLE IF NOT EXISTS destination_confirmations (
+    destination_id INT PRIMARY KEY,
+    code VARCHAR(16) NOT NULL,
+    expires TIMESTAMPTZ NOT NULL,
+    FOREIGN KEY (destination_id) REFERENCES user_destinations(destination_id) ON DELETE CASCADE
+);
+
+-- Destinations an alert is sent to, every enabled one when empty
+ALTER TABLE user_alerts ADD COLUMN IF NOT EXISTS destinations INT[] NOT NULL DEFAULT '{}';
+
+-- Users used to be DMed plus sent to users.webhook_url
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'users' AND column_name = 'webhook_url'
+    ) THEN
+        INSERT INTO user_destinations (id, name, kind, target)
+        SELECT id, 'dm', 'dm', id FROM users
+        ON CONFLICT DO NOTHING;
+        INSERT INTO user_destinations (id, name, kind, target)
+        SELECT id, 'webhook', 'discord', webhook_url FROM users
+        WHERE webhook_url IS NOT NULL AND webhook_url != ''
+        ON CONFLICT DO NOTHING;
+        ALTER TABLE users DROP COLUMN webhook_url;
+    END IF;
+END $$;
+
+-- Authors whose listings a user doesn't want, on one alert or on all of them
+-- when alert_id is NULL. author_id is the Discord user ID or Reddit
+-- username, or a name matched against either when source is empty.
+CREATE TABLE IF NOT EXISTS ignored_authors (
+    ignore_id BIGSERIAL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL,
+    alert_id INT,
+    source VARCHAR(32) NOT NULL DEFAULT '',
+    author_id VARCHAR(64) NOT NULL,
+    author_name VARCHAR(255) NOT NULL DEFAULT '',
+    created TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (alert_id) REFERENCES user_alerts(alert_id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ignored_authors_unique
+    ON ignored_authors (id, COALESCE(alert_id, 0), source, author_id);
+
+-- Ignores used to be the names in user_alerts.ignored
+DO $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.columns
+        WHERE table_name = 'user_alerts' AND column_name = 'ignored'
+    ) THEN
+        INSERT INTO ignored_authors (id, alert_id, author_id, author_name)
+        SELECT user_alerts.id, user_alerts.alert_id, name, name
+        FROM user_alerts, unnest(user_alerts.ignored) AS name
+        ON CONFLICT DO NOTHING;
+        ALTER TABLE user_alerts DROP COLUMN ignored;
+    END IF;
+END $$;
+
+-- Every notification sent to a user, shown by !history
+CREATE TABLE IF NOT EXISTS notification_history (
+    history_id BIGSERIAL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL,
+    alert_id INT,
+    keyword VARCHAR(255) NOT NULL,
+    source VARCHAR(32) NOT NULL,
+    message_id VARCHAR(64) NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL DEFAULT '',
+    matched_terms TEXT[] NOT NULL DEFAULT '{}',
+    delivery VARCHAR(32) NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    created TIMESTAMP NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (alert_id) REFERENCES user_alerts(alert_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS notification_history_user
+    ON notification_history (id, created DESC);
+
+-- Matches waiting for the next digest of users not notified instantly
+CREATE TABLE IF NOT EXISTS digest_items (
+    digest_item_id BIGSERIAL PRIMARY KEY,
+    id VARCHAR(36) NOT NULL,
+    alert_id INT,
+    history_id BIGINT,
+    keyword VARCHAR(255) NOT NULL,
+    source VARCHAR(32) NOT NULL,
+    title TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL DEFAULT '',
+    place TEXT NOT NULL DEFAULT '',
+    price TEXT NOT NULL DEFAULT '',
+    created TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (alert_id) REFERENCES user_alerts(alert_id) ON DELETE SET NULL,
+    FOREIGN KEY (history_id) REFERENCES notification_history(history_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS digest_items_user ON digest_items (id);
+
+-- Destination the item is sent to in the digest, the user's DMs when NULL
+ALTER TABLE digest_items ADD COLUMN IF NOT EXISTS destination_id INT REFERENCES user_destinations(destination_id) ON DELETE CASCADE;
+
+-- Notifications waiting to be delivered (delivery.Queue). Rows are kept
+-- after delivery for a while and dead-lettered after too many failures.
+CREATE TABLE IF NOT EXISTS notification_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(36),
+    alert_id INT,
+    kind VARCHAR(32) NOT NULL,
+    target TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    created TIMESTAMP NOT NULL DEFAULT NOW(),
+    delivered TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (alert_id) REFERENCES user_alerts(alert_id) ON DELETE SET NULL
+);
+
+-- History entry updated with the delivery status
+ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS history_id BIGINT REFERENCES notification_history(history_id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS notification_outbox_pending
+    ON notification_outbox (next_attempt) WHERE status = 'pending';
+
+-- Change notifications for the in-memory alert cache (users.AlertCache)
+CREATE OR REPLACE FUNCTION notify_mechfeed_change() RETURNS trigger AS $$
+DECLARE
+    row_id TEXT;
+BEGIN
+    IF TG_TABLE_NAME = 'user_alerts' THEN
+        row_id := COALESCE(NEW.alert_id, OLD.alert_id)::TEXT;
+    ELSE
+        row_id := COALESCE(NEW.id, OLD.id);
+    END IF;
+    PERFORM pg_notify('mechfeed_changes', json_build_object(
+        'table', TG_TABLE_NAME,
+        'op', TG_OP,
+        'id', row_id
+    )::TEXT);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_notify ON users;
+CREATE TRIGGER users_notify
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION notify_mechfeed_change();
+
+DROP TRIGGER IF EXISTS user_alerts_notify ON user_alerts;
+CREATE TRIGGER user_alerts_notify
+    AFTER INSERT OR UPDATE OR DELETE ON user_alerts
+    FOR EACH ROW EXECUTE FUNCTION notify_mechfeed_change();
+
+DROP TRIGGER IF EXISTS ignored_authors_notify ON ignored_authors;
+CREATE TRIGGER ignored_authors_notify
+    AFTER INSERT OR UPDATE OR DELETE ON ignored_authors
+    FOR EACH ROW EXECUTE FUNCTION notify_mechfeed_change();
+
+DROP TRIGGER IF EXISTS user_destinations_notify ON user_destinations;
+CREATE TRIGGER user_destinations_notify
+    AFTER INSERT OR UPDATE OR DELETE ON user_destinations
+    FOR EACH ROW EXECUTE FUNCTION notify_mechfeed_change();
@@ -0,0 +1,83 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"mechfeed/channels"
+	"sync"
+	"time"
+)
+
+// Source is a marketplace that emits listings, e.g. the Discord gateway or
+// r/mechmarket. Sources register themselves with Register from an init
+// function, so adding one only requires importing its package.
+type Source interface {
+	Name() string
+	// Start runs the source until ctx is cancelled or it fails, sending
+	// every new message to out.
+	Start(ctx context.Context, out chan<- channels.Event) error
+}
+
+var (
+	registry_mu sync.Mutex
+	registry    []Source
+)
+
+// Register makes a source available to All. It panics if a source with the
+// same name is already registered.
+func Register(s Source) {
+	registry_mu.Lock()
+	defer registry_mu.Unlock()
+	for _, r := range registry {
+		if r.Name() == s.Name() {
+			panic("sources: Register called twice for source " + s.Name())
+		}
+	}
+	registry = append(registry, s)
+}
+
+// All returns the registered sources in registration order.
+func All() []Source {
+	registry_mu.Lock()
+	defer registry_mu.Unlock()
+	return append([]Source(nil), registry...)
+}
+
+// Supervise runs s until ctx is cancelled, restarting it after restartDelay
+// whenever it returns an error or panics.
+func Supervise(ctx context.Context, s Source, out chan<- channels.Event, restartDelay time.Duration) {
+	for ctx.Err() == nil {
+		err := run(ctx, s, out)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[ %s ] Crashed with error: %v. Restarting...\n", s.Name(), err)
+		select {
+		case <-time.After(restartDelay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func run(ctx context.Context, s Source, out chan<- channels.Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	if err := s.Start(ctx, out); err != nil {
+		return err
+	}
+	return fmt.Errorf("source stopped")
+}
+
+// Emit sends ev to out unless ctx is cancelled first.
+func Emit(ctx context.Context, out chan<- channels.Event, ev channels.Event) error {
+	select {
+	case out <- ev:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"mechfeed/channels"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type flakySource struct {
+	starts int
+}
+
+func (s *flakySource) Name() string {
+	return "flaky"
+}
+
+func (s *flakySource) Start(ctx context.Context, out chan<- channels.Event) error {
+	s.starts++
+	switch s.starts {
+	case 1:
+		panic("first start")
+	case 2:
+		return errors.New("second start")
+	}
+	if err := Emit(ctx, out, channels.Event{Source: s.Name(), ID: strconv.Itoa(s.starts)}); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSupervise(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	source := &flakySource{}
+	events := make(chan channels.Event)
+	done := make(chan struct{})
+	go func() {
+		Supervise(ctx, source, events, time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case ev := <-events:
+		if ev.ID != "3" {
+			t.Errorf("got event %q expect 3", ev.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("source wasn't restarted")
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Supervise didn't return after cancel")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	registry = nil
+	Register(&flakySource{})
+	if got := len(All()); got != 1 {
+		t.Errorf("got %d sources expect 1", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected panic on duplicate registration")
+		}
+	}()
+	Register(&flakySource{})
+}
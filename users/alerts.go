@@ -0,0 +1,24 @@
+package users
+
+import (
+	"strings"
+	"time"
+)
+
+// PausedAt reports whether the alert is paused at now, either until resumed
+// or until its pause runs out.
+func (a UserAlert) PausedAt(now time.Time) bool {
+	return a.Paused || (a.PausedUntil.Valid && now.Before(a.PausedUntil.Time))
+}
+
+// Ignores reports whether the ignore applies to a listing by the author with
+// the given ID and username on source, matched by alertID.
+func (ig IgnoredAuthor) Ignores(alertID int32, source, authorID, username string) bool {
+	if ig.AlertID.Valid && ig.AlertID.Int32 != alertID {
+		return false
+	}
+	if ig.Source != "" {
+		return ig.Source == source && ig.AuthorID == authorID
+	}
+	return strings.EqualFold(ig.AuthorID, authorID) || strings.EqualFold(ig.AuthorID, username)
+}
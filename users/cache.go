@@ -0,0 +1,321 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Postgres channel notified by the triggers in schema.sql
+const CHANGES_CHANNEL = "mechfeed_changes"
+
+// AlertCache keeps every user, alert, ignored author and destination in
+// memory. It is loaded once at startup and kept fresh through LISTEN/NOTIFY
+// on CHANGES_CHANNEL.
+type AlertCache struct {
+	ctx     context.Context
+	queries cache_queries
+
+	update_mu    sync.Mutex // Serializes changes so subscribers see them in order
+	mu           sync.RWMutex
+	alerts       map[int32]UserAlert
+	users        map[string]User
+	ignored      map[string][]IgnoredAuthor   // By user ID
+	destinations map[string][]UserDestination // By user ID
+	version      uint64
+	refreshed    time.Time
+	on_change    []func(Snapshot)
+}
+
+// Snapshot is a point-in-time copy of the cached alerts.
+type Snapshot struct {
+	Version   uint64 // Incremented on every change applied to the cache
+	Refreshed time.Time
+	Alerts    []UserAlert // Ordered by AlertID
+}
+
+// cache_queries are the queries an AlertCache loads from, *Queries in
+// production.
+type cache_queries interface {
+	GetAlerts(ctx context.Context) ([]UserAlert, error)
+	GetUsers(ctx context.Context) ([]User, error)
+	GetIgnoredAuthors(ctx context.Context) ([]IgnoredAuthor, error)
+	GetDestinations(ctx context.Context) ([]UserDestination, error)
+	GetAlert(ctx context.Context, alertID int32) (UserAlert, error)
+	GetUser(ctx context.Context, id string) (User, error)
+	GetUserIgnoredAuthors(ctx context.Context, id string) ([]IgnoredAuthor, error)
+	GetUserDestinations(ctx context.Context, id string) ([]UserDestination, error)
+}
+
+type change struct {
+	Table string `json:"table"`
+	Op    string `json:"op"`
+	ID    string `json:"id"`
+}
+
+func NewAlertCache(r *Repository) *AlertCache {
+	return &AlertCache{
+		ctx:          r.Ctx,
+		queries:      r.Queries,
+		alerts:       make(map[int32]UserAlert),
+		users:        make(map[string]User),
+		ignored:      make(map[string][]IgnoredAuthor),
+		destinations: make(map[string][]UserDestination),
+	}
+}
+
+// Start loads all users and alerts and listens for changes in the background
+// until ctx is cancelled.
+func (c *AlertCache) Start(ctx context.Context) error {
+	listener := pq.NewListener(POSTGRES_CONNECTION, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Println("alert cache listener:", err)
+		}
+	})
+	if err := listener.Listen(CHANGES_CHANNEL); err != nil {
+		listener.Close()
+		return err
+	}
+	// Listen before loading so that no change is missed in between
+	if err := c.Reload(); err != nil {
+		listener.Close()
+		return err
+	}
+	go c.listen(ctx, listener)
+	return nil
+}
+
+// OnChange registers fn to be called with a new snapshot after every change.
+func (c *AlertCache) OnChange(fn func(Snapshot)) {
+	c.mu.Lock()
+	c.on_change = append(c.on_change, fn)
+	c.mu.Unlock()
+}
+
+// Snapshot returns a copy of the cached alerts.
+func (c *AlertCache) Snapshot() Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.snapshot()
+}
+
+// Alert returns the cached alert with the given ID.
+func (c *AlertCache) Alert(id int32) (UserAlert, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	alert, ok := c.alerts[id]
+	return alert, ok
+}
+
+// User returns the cached user with the given ID.
+func (c *AlertCache) User(id string) (User, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	user, ok := c.users[id]
+	return user, ok
+}
+
+// IgnoredAuthors returns the authors ignored by the given user.
+func (c *AlertCache) IgnoredAuthors(user string) []IgnoredAuthor {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ignored[user]
+}
+
+// Destinations returns the destinations of the given user, ordered by ID.
+func (c *AlertCache) Destinations(user string) []UserDestination {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.destinations[user]
+}
+
+// Users returns every cached user, ordered by ID.
+func (c *AlertCache) Users() []User {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	list := make([]User, 0, len(c.users))
+	for _, user := range c.users {
+		list = append(list, user)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Reload replaces the cache contents with a fresh copy from the database.
+func (c *AlertCache) Reload() error {
+	c.update_mu.Lock()
+	defer c.update_mu.Unlock()
+
+	alerts, err := c.queries.GetAlerts(c.ctx)
+	if err != nil {
+		return err
+	}
+	users, err := c.queries.GetUsers(c.ctx)
+	if err != nil {
+		return err
+	}
+	ignored, err := c.queries.GetIgnoredAuthors(c.ctx)
+	if err != nil {
+		return err
+	}
+	destinations, err := c.queries.GetDestinations(c.ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.alerts = make(map[int32]UserAlert, len(alerts))
+	for _, alert := range alerts {
+		c.alerts[alert.AlertID] = alert
+	}
+	c.users = make(map[string]User, len(users))
+	for _, user := range users {
+		c.users[user.ID] = user
+	}
+	c.ignored = make(map[string][]IgnoredAuthor)
+	for _, ig := range ignored {
+		c.ignored[ig.ID] = append(c.ignored[ig.ID], ig)
+	}
+	c.destinations = make(map[string][]UserDestination)
+	for _, d := range destinations {
+		c.destinations[d.ID] = append(c.destinations[d.ID], d)
+	}
+	c.changed()
+	log.Printf("Alert cache loaded %d alerts for %d users (version %d)", len(alerts), len(users), c.version)
+	return nil
+}
+
+func (c *AlertCache) listen(ctx context.Context, l *pq.Listener) {
+	defer l.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-l.Notify:
+			if !ok {
+				return
+			}
+			if n == nil {
+				// Connection was re-established, notifications may have been lost
+				if err := c.Reload(); err != nil {
+					log.Println("failed to reload alert cache:", err)
+				}
+				continue
+			}
+			if err := c.apply(n.Extra); err != nil {
+				log.Println("failed to apply alert cache change:", err)
+			}
+		case <-time.After(90 * time.Second):
+			go l.Ping()
+		}
+	}
+}
+
+func (c *AlertCache) apply(payload string) error {
+	var ch change
+	if err := json.Unmarshal([]byte(payload), &ch); err != nil {
+		return err
+	}
+
+	c.update_mu.Lock()
+	defer c.update_mu.Unlock()
+
+	switch ch.Table {
+	case "user_alerts":
+		id, err := strconv.ParseInt(ch.ID, 10, 32)
+		if err != nil {
+			return err
+		}
+		alert, err := c.queries.GetAlert(c.ctx, int32(id))
+		deleted := errors.Is(err, sql.ErrNoRows)
+		if err != nil && !deleted {
+			return err
+		}
+		c.mu.Lock()
+		if deleted {
+			delete(c.alerts, int32(id))
+		} else {
+			c.alerts[alert.AlertID] = alert
+		}
+		c.changed()
+
+	case "users":
+		user, err := c.queries.GetUser(c.ctx, ch.ID)
+		deleted := errors.Is(err, sql.ErrNoRows)
+		if err != nil && !deleted {
+			return err
+		}
+		c.mu.Lock()
+		if deleted {
+			delete(c.users, ch.ID)
+		} else {
+			c.users[user.ID] = user
+		}
+		c.changed()
+
+	case "ignored_authors":
+		ignored, err := c.queries.GetUserIgnoredAuthors(c.ctx, ch.ID)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		if len(ignored) == 0 {
+			delete(c.ignored, ch.ID)
+		} else {
+			c.ignored[ch.ID] = ignored
+		}
+		c.changed()
+
+	case "user_destinations":
+		destinations, err := c.queries.GetUserDestinations(c.ctx, ch.ID)
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		if len(destinations) == 0 {
+			delete(c.destinations, ch.ID)
+		} else {
+			c.destinations[ch.ID] = destinations
+		}
+		c.changed()
+
+	default:
+		return errors.New("unknown table " + ch.Table)
+	}
+	return nil
+}
+
+// changed bumps the version and notifies subscribers. Must be called with
+// c.mu held, which it releases.
+func (c *AlertCache) changed() {
+	c.version++
+	c.refreshed = time.Now()
+	snap := c.snapshot()
+	subscribers := c.on_change
+	c.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(snap)
+	}
+}
+
+func (c *AlertCache) snapshot() Snapshot {
+	alerts := make([]UserAlert, 0, len(c.alerts))
+	for _, alert := range c.alerts {
+		alerts = append(alerts, alert)
+	}
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].AlertID < alerts[j].AlertID })
+	return Snapshot{
+		Version:   c.version,
+		Refreshed: c.refreshed,
+		Alerts:    alerts,
+	}
+}
@@ -0,0 +1,252 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"testing"
+)
+
+// fake_queries is a database of cached rows, failing every query with err
+// when set.
+type fake_queries struct {
+	alerts       map[int32]UserAlert
+	users        map[string]User
+	ignored      map[string][]IgnoredAuthor
+	destinations map[string][]UserDestination
+	err          error
+}
+
+func (f fake_queries) GetAlerts(ctx context.Context) ([]UserAlert, error) {
+	var alerts []UserAlert
+	for _, alert := range f.alerts {
+		alerts = append(alerts, alert)
+	}
+	return alerts, f.err
+}
+
+func (f fake_queries) GetUsers(ctx context.Context) ([]User, error) {
+	var users []User
+	for _, user := range f.users {
+		users = append(users, user)
+	}
+	return users, f.err
+}
+
+func (f fake_queries) GetIgnoredAuthors(ctx context.Context) ([]IgnoredAuthor, error) {
+	var ignored []IgnoredAuthor
+	for _, list := range f.ignored {
+		ignored = append(ignored, list...)
+	}
+	return ignored, f.err
+}
+
+func (f fake_queries) GetDestinations(ctx context.Context) ([]UserDestination, error) {
+	var destinations []UserDestination
+	for _, list := range f.destinations {
+		destinations = append(destinations, list...)
+	}
+	sort.Slice(destinations, func(i, j int) bool { return destinations[i].DestinationID < destinations[j].DestinationID })
+	return destinations, f.err
+}
+
+func (f fake_queries) GetAlert(ctx context.Context, alertID int32) (UserAlert, error) {
+	alert, ok := f.alerts[alertID]
+	if !ok && f.err == nil {
+		return alert, sql.ErrNoRows
+	}
+	return alert, f.err
+}
+
+func (f fake_queries) GetUser(ctx context.Context, id string) (User, error) {
+	user, ok := f.users[id]
+	if !ok && f.err == nil {
+		return user, sql.ErrNoRows
+	}
+	return user, f.err
+}
+
+func (f fake_queries) GetUserIgnoredAuthors(ctx context.Context, id string) ([]IgnoredAuthor, error) {
+	return f.ignored[id], f.err
+}
+
+func (f fake_queries) GetUserDestinations(ctx context.Context, id string) ([]UserDestination, error) {
+	return f.destinations[id], f.err
+}
+
+// The database before every change
+func fake_db() fake_queries {
+	return fake_queries{
+		alerts: map[int32]UserAlert{
+			1: {AlertID: 1, ID: "alice", Keyword: "olivia"},
+			2: {AlertID: 2, ID: "bob", Keyword: "kaze"},
+		},
+		users: map[string]User{
+			"alice": {ID: "alice", Username: "alice"},
+			"bob":   {ID: "bob", Username: "bob"},
+		},
+		ignored: map[string][]IgnoredAuthor{
+			"alice": {{ID: "alice", Source: "redditportal", AuthorID: "spammer"}},
+		},
+		destinations: map[string][]UserDestination{
+			"alice": {{DestinationID: 1, ID: "alice", Name: "dm", Kind: DestinationDM, Enabled: true}},
+		},
+	}
+}
+
+func TestAlertCacheApply(t *testing.T) {
+	cases := []struct {
+		name    string
+		change  func(db *fake_queries) // Made to the database before it's notified
+		payload string
+		err     bool
+		check   func(c *AlertCache) bool
+	}{
+		{
+			name:    "alert added",
+			change:  func(db *fake_queries) { db.alerts[3] = UserAlert{AlertID: 3, ID: "alice", Keyword: "gmk"} },
+			payload: `{"table": "user_alerts", "op": "INSERT", "id": "3"}`,
+			check: func(c *AlertCache) bool {
+				alert, ok := c.Alert(3)
+				return ok && alert.Keyword == "gmk" && len(c.Snapshot().Alerts) == 3
+			},
+		},
+		{
+			name:    "alert updated",
+			change:  func(db *fake_queries) { db.alerts[1] = UserAlert{AlertID: 1, ID: "alice", Keyword: "olivia & gmk"} },
+			payload: `{"table": "user_alerts", "op": "UPDATE", "id": "1"}`,
+			check: func(c *AlertCache) bool {
+				alert, _ := c.Alert(1)
+				return alert.Keyword == "olivia & gmk"
+			},
+		},
+		{
+			name:    "alert deleted",
+			change:  func(db *fake_queries) { delete(db.alerts, 2) },
+			payload: `{"table": "user_alerts", "op": "DELETE", "id": "2"}`,
+			check: func(c *AlertCache) bool {
+				_, ok := c.Alert(2)
+				return !ok && len(c.Snapshot().Alerts) == 1
+			},
+		},
+		{
+			name:    "user updated",
+			change:  func(db *fake_queries) { db.users["bob"] = User{ID: "bob", Username: "bob", DeliveryMode: "daily"} },
+			payload: `{"table": "users", "op": "UPDATE", "id": "bob"}`,
+			check: func(c *AlertCache) bool {
+				user, _ := c.User("bob")
+				return user.DeliveryMode == "daily"
+			},
+		},
+		{
+			name:    "user deleted",
+			change:  func(db *fake_queries) { delete(db.users, "bob") },
+			payload: `{"table": "users", "op": "DELETE", "id": "bob"}`,
+			check: func(c *AlertCache) bool {
+				_, ok := c.User("bob")
+				return !ok && len(c.Users()) == 1
+			},
+		},
+		{
+			name: "author ignored",
+			change: func(db *fake_queries) {
+				db.ignored["bob"] = []IgnoredAuthor{{ID: "bob", Source: "discordportal", AuthorID: "42"}}
+			},
+			payload: `{"table": "ignored_authors", "op": "INSERT", "id": "bob"}`,
+			check: func(c *AlertCache) bool {
+				return len(c.IgnoredAuthors("bob")) == 1 && len(c.IgnoredAuthors("alice")) == 1
+			},
+		},
+		{
+			name:    "last author unignored",
+			change:  func(db *fake_queries) { delete(db.ignored, "alice") },
+			payload: `{"table": "ignored_authors", "op": "DELETE", "id": "alice"}`,
+			check: func(c *AlertCache) bool {
+				_, ok := c.ignored["alice"]
+				return !ok
+			},
+		},
+		{
+			name: "destination added",
+			change: func(db *fake_queries) {
+				db.destinations["alice"] = append(db.destinations["alice"], UserDestination{DestinationID: 2, ID: "alice", Name: "phone", Kind: DestinationNtfy})
+			},
+			payload: `{"table": "user_destinations", "op": "INSERT", "id": "alice"}`,
+			check: func(c *AlertCache) bool {
+				destinations := c.Destinations("alice")
+				return len(destinations) == 2 && destinations[1].Name == "phone"
+			},
+		},
+		{
+			name:    "last destination removed",
+			change:  func(db *fake_queries) { delete(db.destinations, "alice") },
+			payload: `{"table": "user_destinations", "op": "DELETE", "id": "alice"}`,
+			check: func(c *AlertCache) bool {
+				_, ok := c.destinations["alice"]
+				return !ok
+			},
+		},
+		{
+			name:    "invalid payload",
+			payload: `user_alerts 1`,
+			err:     true,
+		},
+		{
+			name:    "unknown table",
+			payload: `{"table": "notification_outbox", "op": "INSERT", "id": "1"}`,
+			err:     true,
+		},
+		{
+			name:    "invalid alert ID",
+			payload: `{"table": "user_alerts", "op": "INSERT", "id": "abc"}`,
+			err:     true,
+		},
+		{
+			name:    "query failed",
+			change:  func(db *fake_queries) { db.err = errors.New("connection reset") },
+			payload: `{"table": "user_alerts", "op": "DELETE", "id": "1"}`,
+			err:     true,
+			check: func(c *AlertCache) bool {
+				_, ok := c.Alert(1)
+				return ok
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := fake_db()
+			cache := &AlertCache{ctx: context.Background(), queries: db}
+			if err := cache.Reload(); err != nil {
+				t.Fatal(err)
+			}
+			var notified []Snapshot
+			cache.OnChange(func(s Snapshot) { notified = append(notified, s) })
+
+			// A fresh copy, the cache may share slices with the loaded one
+			changed := fake_db()
+			if c.change != nil {
+				c.change(&changed)
+			}
+			cache.queries = changed
+			err := cache.apply(c.payload)
+			if (err != nil) != c.err {
+				t.Fatalf("got error %v expect error %t", err, c.err)
+			}
+			if c.check != nil && !c.check(cache) {
+				t.Errorf("cache not updated as expected")
+			}
+
+			expect := 1
+			if c.err {
+				expect = 0
+			}
+			if len(notified) != expect {
+				t.Fatalf("got %d change notifications expect %d", len(notified), expect)
+			}
+			if expect == 1 && notified[0].Version != 2 {
+				t.Errorf("got version %d expect 2", notified[0].Version)
+			}
+		})
+	}
+}
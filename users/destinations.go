@@ -0,0 +1,39 @@
+package users
+
+// Kinds of user_destinations
+const (
+	DestinationDM       = "dm"       // The user's Discord DMs, target is their user ID
+	DestinationDiscord  = "discord"  // Discord webhook URL
+	DestinationSlack    = "slack"    // Slack incoming webhook URL
+	DestinationJSON     = "json"     // Any URL, posted the listing as JSON
+	DestinationNtfy     = "ntfy"     // ntfy topic, on ntfy.sh unless a URL
+	DestinationEmail    = "email"    // Email address, sent through SMTP
+	DestinationTelegram = "telegram" // Telegram chat ID, linked with !telegram
+)
+
+var DestinationKinds = []string{DestinationDM, DestinationDiscord, DestinationSlack, DestinationJSON, DestinationNtfy, DestinationEmail, DestinationTelegram}
+
+// RoutesTo reports whether the alert's notifications are sent to d. Alerts
+// without destinations are sent to all of them.
+func (a UserAlert) RoutesTo(d UserDestination) bool {
+	if len(a.Destinations) == 0 {
+		return true
+	}
+	for _, id := range a.Destinations {
+		if id == d.DestinationID {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes returns the enabled destinations the alert is sent to.
+func (a UserAlert) Routes(destinations []UserDestination) []UserDestination {
+	var routes []UserDestination
+	for _, d := range destinations {
+		if d.Enabled && a.RoutesTo(d) {
+			routes = append(routes, d)
+		}
+	}
+	return routes
+}
@@ -6,18 +6,94 @@ package users
 
 import (
 	"database/sql"
+	"encoding/json"
+	"time"
 )
 
-type User struct {
+type DigestItem struct {
+	DigestItemID  int64
+	ID            string
+	AlertID       sql.NullInt32
+	HistoryID     sql.NullInt64
+	Keyword       string
+	Source        string
+	Title         string
+	Url           string
+	Place         string
+	Price         string
+	Created       time.Time
+	DestinationID sql.NullInt32
+}
+
+type IgnoredAuthor struct {
+	IgnoreID   int64
 	ID         string
-	Username   string
-	WebhookUrl sql.NullString
-	Created    sql.NullTime
+	AlertID    sql.NullInt32
+	Source     string
+	AuthorID   string
+	AuthorName string
+	Created    time.Time
+}
+
+type NotificationHistory struct {
+	HistoryID    int64
+	ID           string
+	AlertID      sql.NullInt32
+	Keyword      string
+	Source       string
+	MessageID    string
+	Title        string
+	Url          string
+	MatchedTerms []string
+	Delivery     string
+	Status       string
+	Created      time.Time
+}
+
+type NotificationOutbox struct {
+	ID          int64
+	UserID      sql.NullString
+	AlertID     sql.NullInt32
+	Kind        string
+	Target      string
+	Payload     json.RawMessage
+	Status      string
+	Attempts    int32
+	NextAttempt time.Time
+	LastError   sql.NullString
+	Created     time.Time
+	Delivered   sql.NullTime
+	HistoryID   sql.NullInt64
+}
+
+type User struct {
+	ID           string
+	Username     string
+	Created      sql.NullTime
+	DeliveryMode string
+	DigestAt     int16
+	Timezone     string
+	DigestSent   sql.NullTime
+	QuietStart   sql.NullInt16
+	QuietEnd     sql.NullInt16
 }
 
 type UserAlert struct {
-	AlertID int32
-	ID      string
-	Keyword string
-	Ignored []string
+	AlertID      int32
+	ID           string
+	Keyword      string
+	Urgent       bool
+	PausedUntil  sql.NullTime
+	Paused       bool
+	Destinations []int32
+}
+
+type UserDestination struct {
+	DestinationID int32
+	ID            string
+	Name          string
+	Kind          string
+	Target        string
+	Enabled       bool
+	Created       time.Time
 }
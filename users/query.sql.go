@@ -8,10 +8,191 @@ package users
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 
 	"github.com/lib/pq"
 )
 
+const addDestination = `-- name: AddDestination :one
+INSERT INTO user_destinations (
+  id, name, kind, target, enabled
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT (id, name) DO UPDATE
+SET kind = EXCLUDED.kind, target = EXCLUDED.target, enabled = EXCLUDED.enabled
+RETURNING destination_id
+`
+
+type AddDestinationParams struct {
+	ID      string
+	Name    string
+	Kind    string
+	Target  string
+	Enabled bool
+}
+
+func (q *Queries) AddDestination(ctx context.Context, arg AddDestinationParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, addDestination,
+		arg.ID,
+		arg.Name,
+		arg.Kind,
+		arg.Target,
+		arg.Enabled,
+	)
+	var destination_id int32
+	err := row.Scan(&destination_id)
+	return destination_id, err
+}
+
+const addDigestItem = `-- name: AddDigestItem :exec
+INSERT INTO digest_items (
+  id, alert_id, history_id, keyword, source, title, url, place, price, destination_id
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+)
+`
+
+type AddDigestItemParams struct {
+	ID            string
+	AlertID       sql.NullInt32
+	HistoryID     sql.NullInt64
+	Keyword       string
+	Source        string
+	Title         string
+	Url           string
+	Place         string
+	Price         string
+	DestinationID sql.NullInt32
+}
+
+func (q *Queries) AddDigestItem(ctx context.Context, arg AddDigestItemParams) error {
+	_, err := q.db.ExecContext(ctx, addDigestItem,
+		arg.ID,
+		arg.AlertID,
+		arg.HistoryID,
+		arg.Keyword,
+		arg.Source,
+		arg.Title,
+		arg.Url,
+		arg.Place,
+		arg.Price,
+		arg.DestinationID,
+	)
+	return err
+}
+
+const addIgnoredAuthor = `-- name: AddIgnoredAuthor :exec
+INSERT INTO ignored_authors (
+  id, alert_id, source, author_id, author_name
+) VALUES (
+  $1, $2, $3, $4, $5
+)
+ON CONFLICT DO NOTHING
+`
+
+type AddIgnoredAuthorParams struct {
+	ID         string
+	AlertID    sql.NullInt32
+	Source     string
+	AuthorID   string
+	AuthorName string
+}
+
+func (q *Queries) AddIgnoredAuthor(ctx context.Context, arg AddIgnoredAuthorParams) error {
+	_, err := q.db.ExecContext(ctx, addIgnoredAuthor,
+		arg.ID,
+		arg.AlertID,
+		arg.Source,
+		arg.AuthorID,
+		arg.AuthorName,
+	)
+	return err
+}
+
+const claimNotifications = `-- name: ClaimNotifications :many
+UPDATE notification_outbox
+SET attempts = attempts + 1,
+    next_attempt = NOW() + make_interval(secs => $1)
+WHERE id IN (
+  SELECT id FROM notification_outbox
+  WHERE status = 'pending' AND next_attempt <= NOW()
+  ORDER BY next_attempt
+  LIMIT $2
+  FOR UPDATE SKIP LOCKED
+)
+RETURNING id, user_id, alert_id, kind, target, payload, status, attempts, next_attempt, last_error, created, delivered, history_id
+`
+
+type ClaimNotificationsParams struct {
+	LeaseSeconds float64
+	MaxClaimed   int32
+}
+
+func (q *Queries) ClaimNotifications(ctx context.Context, arg ClaimNotificationsParams) ([]NotificationOutbox, error) {
+	rows, err := q.db.QueryContext(ctx, claimNotifications, arg.LeaseSeconds, arg.MaxClaimed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NotificationOutbox
+	for rows.Next() {
+		var i NotificationOutbox
+		if err := rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.AlertID,
+			&i.Kind,
+			&i.Target,
+			&i.Payload,
+			&i.Status,
+			&i.Attempts,
+			&i.NextAttempt,
+			&i.LastError,
+			&i.Created,
+			&i.Delivered,
+			&i.HistoryID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const confirmDestination = `-- name: ConfirmDestination :execrows
+WITH confirmed AS (
+  DELETE FROM destination_confirmations c
+  USING user_destinations d
+  WHERE c.destination_id = d.destination_id AND d.id = $1 AND d.name = $2
+    AND c.code = $3 AND c.expires > NOW()
+  RETURNING c.destination_id
+)
+UPDATE user_destinations
+SET enabled = TRUE
+WHERE destination_id IN (SELECT destination_id FROM confirmed)
+`
+
+type ConfirmDestinationParams struct {
+	ID   string
+	Name string
+	Code string
+}
+
+func (q *Queries) ConfirmDestination(ctx context.Context, arg ConfirmDestinationParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, confirmDestination, arg.ID, arg.Name, arg.Code)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const createAlert = `-- name: CreateAlert :exec
 INSERT INTO user_alerts (
   id, keyword
@@ -30,33 +211,139 @@ func (q *Queries) CreateAlert(ctx context.Context, arg CreateAlertParams) error
 	return err
 }
 
-const createUser = `-- name: CreateUser :one
-INSERT INTO users (
-  id, username, webhook_url
+const createDestinationConfirmation = `-- name: CreateDestinationConfirmation :exec
+INSERT INTO destination_confirmations (
+  destination_id, code, expires
 ) VALUES (
-  $1, $2, $3
+  $1, $2, NOW() + make_interval(secs => $3)
 )
-RETURNING id, username, webhook_url, created
+ON CONFLICT (destination_id) DO UPDATE
+SET code = EXCLUDED.code, expires = EXCLUDED.expires
 `
 
-type CreateUserParams struct {
+type CreateDestinationConfirmationParams struct {
+	DestinationID int32
+	Code          string
+	TtlSeconds    float64
+}
+
+func (q *Queries) CreateDestinationConfirmation(ctx context.Context, arg CreateDestinationConfirmationParams) error {
+	_, err := q.db.ExecContext(ctx, createDestinationConfirmation, arg.DestinationID, arg.Code, arg.TtlSeconds)
+	return err
+}
+
+const createHistory = `-- name: CreateHistory :one
+INSERT INTO notification_history (
+  id, alert_id, keyword, source, message_id, title, url, matched_terms, delivery
+) VALUES (
+  $1, $2, $3, $4, $5, $6, $7, $8, $9
+)
+RETURNING history_id
+`
+
+type CreateHistoryParams struct {
+	ID           string
+	AlertID      sql.NullInt32
+	Keyword      string
+	Source       string
+	MessageID    string
+	Title        string
+	Url          string
+	MatchedTerms []string
+	Delivery     string
+}
+
+func (q *Queries) CreateHistory(ctx context.Context, arg CreateHistoryParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createHistory,
+		arg.ID,
+		arg.AlertID,
+		arg.Keyword,
+		arg.Source,
+		arg.MessageID,
+		arg.Title,
+		arg.Url,
+		pq.Array(arg.MatchedTerms),
+		arg.Delivery,
+	)
+	var history_id int64
+	err := row.Scan(&history_id)
+	return history_id, err
+}
+
+const createTelegramLinkCode = `-- name: CreateTelegramLinkCode :exec
+INSERT INTO telegram_link_codes (
+  id, code, expires
+) VALUES (
+  $1, $2, NOW() + make_interval(secs => $3)
+)
+ON CONFLICT (id) DO UPDATE
+SET code = EXCLUDED.code, expires = EXCLUDED.expires
+`
+
+type CreateTelegramLinkCodeParams struct {
 	ID         string
-	Username   string
-	WebhookUrl sql.NullString
+	Code       string
+	TtlSeconds float64
+}
+
+func (q *Queries) CreateTelegramLinkCode(ctx context.Context, arg CreateTelegramLinkCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createTelegramLinkCode, arg.ID, arg.Code, arg.TtlSeconds)
+	return err
+}
+
+const createUser = `-- name: CreateUser :one
+WITH new_user AS (
+  INSERT INTO users (
+    id, username
+  ) VALUES (
+    $1, $2
+  )
+  RETURNING id, username, created, delivery_mode, digest_at, timezone, digest_sent, quiet_start, quiet_end
+), dm AS (
+  INSERT INTO user_destinations (id, name, kind, target)
+  SELECT id, 'dm', 'dm', id FROM new_user
+)
+SELECT id, username, created, delivery_mode, digest_at, timezone, digest_sent, quiet_start, quiet_end FROM new_user
+`
+
+type CreateUserParams struct {
+	ID       string
+	Username string
 }
 
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, createUser, arg.ID, arg.Username, arg.WebhookUrl)
+	row := q.db.QueryRowContext(ctx, createUser, arg.ID, arg.Username)
 	var i User
 	err := row.Scan(
 		&i.ID,
 		&i.Username,
-		&i.WebhookUrl,
 		&i.Created,
+		&i.DeliveryMode,
+		&i.DigestAt,
+		&i.Timezone,
+		&i.DigestSent,
+		&i.QuietStart,
+		&i.QuietEnd,
 	)
 	return i, err
 }
 
+const deadLetterNotification = `-- name: DeadLetterNotification :exec
+UPDATE notification_outbox
+SET status = 'dead', last_error = $2
+WHERE id = $1
+`
+
+type DeadLetterNotificationParams struct {
+	ID        int64
+	LastError sql.NullString
+}
+
+func (q *Queries) DeadLetterNotification(ctx context.Context, arg DeadLetterNotificationParams) error {
+	_, err := q.db.ExecContext(ctx, deadLetterNotification, arg.ID, arg.LastError)
+	return err
+}
+
 const deleteAlert = `-- name: DeleteAlert :exec
 DELETE FROM user_alerts
 WHERE alert_id = $1
@@ -77,8 +364,119 @@ func (q *Queries) DeleteAllAlerts(ctx context.Context, id string) error {
 	return err
 }
 
+const deleteDeliveredNotifications = `-- name: DeleteDeliveredNotifications :exec
+DELETE FROM notification_outbox
+WHERE status = 'delivered' AND delivered < NOW() - make_interval(secs => $1)
+`
+
+func (q *Queries) DeleteDeliveredNotifications(ctx context.Context, ageSeconds float64) error {
+	_, err := q.db.ExecContext(ctx, deleteDeliveredNotifications, ageSeconds)
+	return err
+}
+
+const deleteDestination = `-- name: DeleteDestination :one
+DELETE FROM user_destinations
+WHERE id = $1 AND name = $2
+RETURNING destination_id
+`
+
+type DeleteDestinationParams struct {
+	ID   string
+	Name string
+}
+
+func (q *Queries) DeleteDestination(ctx context.Context, arg DeleteDestinationParams) (int32, error) {
+	row := q.db.QueryRowContext(ctx, deleteDestination, arg.ID, arg.Name)
+	var destination_id int32
+	err := row.Scan(&destination_id)
+	return destination_id, err
+}
+
+const deleteDigestItems = `-- name: DeleteDigestItems :exec
+DELETE FROM digest_items
+WHERE digest_item_id = ANY($1::BIGINT[])
+`
+
+func (q *Queries) DeleteDigestItems(ctx context.Context, digestItemIds []int64) error {
+	_, err := q.db.ExecContext(ctx, deleteDigestItems, pq.Array(digestItemIds))
+	return err
+}
+
+const deleteIgnoredAuthors = `-- name: DeleteIgnoredAuthors :execrows
+DELETE FROM ignored_authors
+WHERE id = $1
+  AND ($2::INT IS NULL OR alert_id = $2)
+  AND (LOWER(author_id) = LOWER($3) OR LOWER(author_name) = LOWER($3))
+`
+
+type DeleteIgnoredAuthorsParams struct {
+	ID      string
+	AlertID sql.NullInt32
+	Author  string
+}
+
+func (q *Queries) DeleteIgnoredAuthors(ctx context.Context, arg DeleteIgnoredAuthorsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteIgnoredAuthors, arg.ID, arg.AlertID, arg.Author)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const enqueueNotification = `-- name: EnqueueNotification :one
+INSERT INTO notification_outbox (
+  user_id, alert_id, kind, target, payload, history_id
+) VALUES (
+  $1, $2, $3, $4, $5, $6
+)
+RETURNING id
+`
+
+type EnqueueNotificationParams struct {
+	UserID    sql.NullString
+	AlertID   sql.NullInt32
+	Kind      string
+	Target    string
+	Payload   json.RawMessage
+	HistoryID sql.NullInt64
+}
+
+func (q *Queries) EnqueueNotification(ctx context.Context, arg EnqueueNotificationParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, enqueueNotification,
+		arg.UserID,
+		arg.AlertID,
+		arg.Kind,
+		arg.Target,
+		arg.Payload,
+		arg.HistoryID,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const getAlert = `-- name: GetAlert :one
+SELECT alert_id, id, keyword, urgent, paused_until, paused, destinations FROM user_alerts
+WHERE alert_id = $1 LIMIT 1
+`
+
+func (q *Queries) GetAlert(ctx context.Context, alertID int32) (UserAlert, error) {
+	row := q.db.QueryRowContext(ctx, getAlert, alertID)
+	var i UserAlert
+	err := row.Scan(
+		&i.AlertID,
+		&i.ID,
+		&i.Keyword,
+		&i.Urgent,
+		&i.PausedUntil,
+		&i.Paused,
+		pq.Array(&i.Destinations),
+	)
+	return i, err
+}
+
 const getAlerts = `-- name: GetAlerts :many
-SELECT alert_id, id, keyword, ignored FROM user_alerts
+SELECT alert_id, id, keyword, urgent, paused_until, paused, destinations FROM user_alerts
 `
 
 func (q *Queries) GetAlerts(ctx context.Context) ([]UserAlert, error) {
@@ -94,7 +492,165 @@ func (q *Queries) GetAlerts(ctx context.Context) ([]UserAlert, error) {
 			&i.AlertID,
 			&i.ID,
 			&i.Keyword,
-			pq.Array(&i.Ignored),
+			&i.Urgent,
+			&i.PausedUntil,
+			&i.Paused,
+			pq.Array(&i.Destinations),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDestinations = `-- name: GetDestinations :many
+SELECT destination_id, id, name, kind, target, enabled, created FROM user_destinations
+ORDER BY destination_id
+`
+
+func (q *Queries) GetDestinations(ctx context.Context) ([]UserDestination, error) {
+	rows, err := q.db.QueryContext(ctx, getDestinations)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UserDestination
+	for rows.Next() {
+		var i UserDestination
+		if err := rows.Scan(
+			&i.DestinationID,
+			&i.ID,
+			&i.Name,
+			&i.Kind,
+			&i.Target,
+			&i.Enabled,
+			&i.Created,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDestinationsByTarget = `-- name: GetDestinationsByTarget :many
+SELECT destination_id, id, name, kind, target, enabled, created FROM user_destinations
+WHERE kind = $1 AND target = $2
+ORDER BY destination_id
+`
+
+type GetDestinationsByTargetParams struct {
+	Kind   string
+	Target string
+}
+
+func (q *Queries) GetDestinationsByTarget(ctx context.Context, arg GetDestinationsByTargetParams) ([]UserDestination, error) {
+	rows, err := q.db.QueryContext(ctx, getDestinationsByTarget, arg.Kind, arg.Target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UserDestination
+	for rows.Next() {
+		var i UserDestination
+		if err := rows.Scan(
+			&i.DestinationID,
+			&i.ID,
+			&i.Name,
+			&i.Kind,
+			&i.Target,
+			&i.Enabled,
+			&i.Created,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDigestItems = `-- name: GetDigestItems :many
+SELECT digest_item_id, id, alert_id, history_id, keyword, source, title, url, place, price, created, destination_id FROM digest_items
+WHERE id = $1
+ORDER BY digest_item_id
+`
+
+func (q *Queries) GetDigestItems(ctx context.Context, id string) ([]DigestItem, error) {
+	rows, err := q.db.QueryContext(ctx, getDigestItems, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DigestItem
+	for rows.Next() {
+		var i DigestItem
+		if err := rows.Scan(
+			&i.DigestItemID,
+			&i.ID,
+			&i.AlertID,
+			&i.HistoryID,
+			&i.Keyword,
+			&i.Source,
+			&i.Title,
+			&i.Url,
+			&i.Place,
+			&i.Price,
+			&i.Created,
+			&i.DestinationID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getIgnoredAuthors = `-- name: GetIgnoredAuthors :many
+SELECT ignore_id, id, alert_id, source, author_id, author_name, created FROM ignored_authors
+`
+
+func (q *Queries) GetIgnoredAuthors(ctx context.Context) ([]IgnoredAuthor, error) {
+	rows, err := q.db.QueryContext(ctx, getIgnoredAuthors)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []IgnoredAuthor
+	for rows.Next() {
+		var i IgnoredAuthor
+		if err := rows.Scan(
+			&i.IgnoreID,
+			&i.ID,
+			&i.AlertID,
+			&i.Source,
+			&i.AuthorID,
+			&i.AuthorName,
+			&i.Created,
 		); err != nil {
 			return nil, err
 		}
@@ -110,7 +666,7 @@ func (q *Queries) GetAlerts(ctx context.Context) ([]UserAlert, error) {
 }
 
 const getUser = `-- name: GetUser :one
-SELECT id, username, webhook_url, created FROM users 
+SELECT id, username, created, delivery_mode, digest_at, timezone, digest_sent, quiet_start, quiet_end FROM users 
 WHERE id = $1 LIMIT 1
 `
 
@@ -120,15 +676,21 @@ func (q *Queries) GetUser(ctx context.Context, id string) (User, error) {
 	err := row.Scan(
 		&i.ID,
 		&i.Username,
-		&i.WebhookUrl,
 		&i.Created,
+		&i.DeliveryMode,
+		&i.DigestAt,
+		&i.Timezone,
+		&i.DigestSent,
+		&i.QuietStart,
+		&i.QuietEnd,
 	)
 	return i, err
 }
 
 const getUserAlerts = `-- name: GetUserAlerts :many
-SELECT alert_id, id, keyword, ignored FROM user_alerts
+SELECT alert_id, id, keyword, urgent, paused_until, paused, destinations FROM user_alerts
 WHERE id = $1
+ORDER BY alert_id
 `
 
 func (q *Queries) GetUserAlerts(ctx context.Context, id string) ([]UserAlert, error) {
@@ -144,7 +706,47 @@ func (q *Queries) GetUserAlerts(ctx context.Context, id string) ([]UserAlert, er
 			&i.AlertID,
 			&i.ID,
 			&i.Keyword,
-			pq.Array(&i.Ignored),
+			&i.Urgent,
+			&i.PausedUntil,
+			&i.Paused,
+			pq.Array(&i.Destinations),
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserDestinations = `-- name: GetUserDestinations :many
+SELECT destination_id, id, name, kind, target, enabled, created FROM user_destinations
+WHERE id = $1
+ORDER BY destination_id
+`
+
+func (q *Queries) GetUserDestinations(ctx context.Context, id string) ([]UserDestination, error) {
+	rows, err := q.db.QueryContext(ctx, getUserDestinations, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UserDestination
+	for rows.Next() {
+		var i UserDestination
+		if err := rows.Scan(
+			&i.DestinationID,
+			&i.ID,
+			&i.Name,
+			&i.Kind,
+			&i.Target,
+			&i.Enabled,
+			&i.Created,
 		); err != nil {
 			return nil, err
 		}
@@ -171,8 +773,142 @@ func (q *Queries) GetUserExistence(ctx context.Context, id string) (int32, error
 	return column_1, err
 }
 
+const getUserHistory = `-- name: GetUserHistory :many
+SELECT history_id, id, alert_id, keyword, source, message_id, title, url, matched_terms, delivery, status, created FROM notification_history
+WHERE id = $1
+ORDER BY created DESC
+LIMIT $2
+`
+
+type GetUserHistoryParams struct {
+	ID    string
+	Limit int32
+}
+
+func (q *Queries) GetUserHistory(ctx context.Context, arg GetUserHistoryParams) ([]NotificationHistory, error) {
+	rows, err := q.db.QueryContext(ctx, getUserHistory, arg.ID, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NotificationHistory
+	for rows.Next() {
+		var i NotificationHistory
+		if err := rows.Scan(
+			&i.HistoryID,
+			&i.ID,
+			&i.AlertID,
+			&i.Keyword,
+			&i.Source,
+			&i.MessageID,
+			&i.Title,
+			&i.Url,
+			pq.Array(&i.MatchedTerms),
+			&i.Delivery,
+			&i.Status,
+			&i.Created,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserHistorySince = `-- name: GetUserHistorySince :many
+SELECT history_id, id, alert_id, keyword, source, message_id, title, url, matched_terms, delivery, status, created FROM notification_history
+WHERE id = $1 AND created >= NOW() - make_interval(secs => $2)
+ORDER BY created DESC
+LIMIT $3
+`
+
+type GetUserHistorySinceParams struct {
+	ID         string
+	AgeSeconds float64
+	MaxResults int32
+}
+
+func (q *Queries) GetUserHistorySince(ctx context.Context, arg GetUserHistorySinceParams) ([]NotificationHistory, error) {
+	rows, err := q.db.QueryContext(ctx, getUserHistorySince, arg.ID, arg.AgeSeconds, arg.MaxResults)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []NotificationHistory
+	for rows.Next() {
+		var i NotificationHistory
+		if err := rows.Scan(
+			&i.HistoryID,
+			&i.ID,
+			&i.AlertID,
+			&i.Keyword,
+			&i.Source,
+			&i.MessageID,
+			&i.Title,
+			&i.Url,
+			pq.Array(&i.MatchedTerms),
+			&i.Delivery,
+			&i.Status,
+			&i.Created,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUserIgnoredAuthors = `-- name: GetUserIgnoredAuthors :many
+SELECT ignore_id, id, alert_id, source, author_id, author_name, created FROM ignored_authors
+WHERE id = $1
+ORDER BY alert_id NULLS FIRST, created
+`
+
+func (q *Queries) GetUserIgnoredAuthors(ctx context.Context, id string) ([]IgnoredAuthor, error) {
+	rows, err := q.db.QueryContext(ctx, getUserIgnoredAuthors, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []IgnoredAuthor
+	for rows.Next() {
+		var i IgnoredAuthor
+		if err := rows.Scan(
+			&i.IgnoreID,
+			&i.ID,
+			&i.AlertID,
+			&i.Source,
+			&i.AuthorID,
+			&i.AuthorName,
+			&i.Created,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUsers = `-- name: GetUsers :many
-SELECT id, username, webhook_url, created FROM users
+SELECT id, username, created, delivery_mode, digest_at, timezone, digest_sent, quiet_start, quiet_end FROM users
 `
 
 func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
@@ -187,8 +923,13 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 		if err := rows.Scan(
 			&i.ID,
 			&i.Username,
-			&i.WebhookUrl,
 			&i.Created,
+			&i.DeliveryMode,
+			&i.DigestAt,
+			&i.Timezone,
+			&i.DigestSent,
+			&i.QuietStart,
+			&i.QuietEnd,
 		); err != nil {
 			return nil, err
 		}
@@ -203,19 +944,309 @@ func (q *Queries) GetUsers(ctx context.Context) ([]User, error) {
 	return items, nil
 }
 
-const ignoreUserForAlert = `-- name: IgnoreUserForAlert :exec
+const isDestinationUnconfirmed = `-- name: IsDestinationUnconfirmed :one
+SELECT EXISTS (
+  SELECT 1 FROM destination_confirmations
+  WHERE destination_id = $1
+)
+`
+
+func (q *Queries) IsDestinationUnconfirmed(ctx context.Context, destinationID int32) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isDestinationUnconfirmed, destinationID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const markDigestSent = `-- name: MarkDigestSent :exec
+UPDATE users
+SET digest_sent = NOW()
+WHERE id = $1
+`
+
+func (q *Queries) MarkDigestSent(ctx context.Context, id string) error {
+	_, err := q.db.ExecContext(ctx, markDigestSent, id)
+	return err
+}
+
+const markHistoryDelivered = `-- name: MarkHistoryDelivered :exec
+UPDATE notification_history
+SET status = 'delivered'
+WHERE history_id = ANY($1::BIGINT[])
+`
+
+func (q *Queries) MarkHistoryDelivered(ctx context.Context, historyIds []int64) error {
+	_, err := q.db.ExecContext(ctx, markHistoryDelivered, pq.Array(historyIds))
+	return err
+}
+
+const markNotificationDelivered = `-- name: MarkNotificationDelivered :exec
+UPDATE notification_outbox
+SET status = 'delivered', delivered = NOW(), last_error = NULL
+WHERE id = $1
+`
+
+func (q *Queries) MarkNotificationDelivered(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, markNotificationDelivered, id)
+	return err
+}
+
+const pauseAlert = `-- name: PauseAlert :exec
+UPDATE user_alerts
+SET paused = FALSE, paused_until = NOW() + make_interval(secs => $1)
+WHERE alert_id = $2
+`
+
+type PauseAlertParams struct {
+	DurationSeconds float64
+	AlertID         int32
+}
+
+func (q *Queries) PauseAlert(ctx context.Context, arg PauseAlertParams) error {
+	_, err := q.db.ExecContext(ctx, pauseAlert, arg.DurationSeconds, arg.AlertID)
+	return err
+}
+
+const pauseAlertIndefinitely = `-- name: PauseAlertIndefinitely :exec
+UPDATE user_alerts
+SET paused = TRUE, paused_until = NULL
+WHERE alert_id = $1
+`
+
+func (q *Queries) PauseAlertIndefinitely(ctx context.Context, alertID int32) error {
+	_, err := q.db.ExecContext(ctx, pauseAlertIndefinitely, alertID)
+	return err
+}
+
+const removeAlertDestination = `-- name: RemoveAlertDestination :many
+WITH removed AS (
+  UPDATE user_alerts
+  SET destinations = array_remove(destinations, $2::INT),
+      paused = paused OR destinations = ARRAY[$2::INT],
+      paused_until = CASE WHEN destinations = ARRAY[$2::INT] THEN NULL ELSE paused_until END
+  WHERE id = $1 AND $2::INT = ANY(destinations)
+  RETURNING keyword, destinations
+)
+SELECT keyword FROM removed
+WHERE cardinality(destinations) = 0
+`
+
+type RemoveAlertDestinationParams struct {
+	ID            string
+	DestinationID int32
+}
+
+// Alerts without destinations are sent to all of them, so the alerts only
+// routed to the removed destination are paused and their keywords returned
+func (q *Queries) RemoveAlertDestination(ctx context.Context, arg RemoveAlertDestinationParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, removeAlertDestination, arg.ID, arg.DestinationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var keyword string
+		if err := rows.Scan(&keyword); err != nil {
+			return nil, err
+		}
+		items = append(items, keyword)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const resumeAlert = `-- name: ResumeAlert :exec
+UPDATE user_alerts
+SET paused = FALSE, paused_until = NULL
+WHERE alert_id = $1
+`
+
+func (q *Queries) ResumeAlert(ctx context.Context, alertID int32) error {
+	_, err := q.db.ExecContext(ctx, resumeAlert, alertID)
+	return err
+}
+
+const retryNotification = `-- name: RetryNotification :exec
+UPDATE notification_outbox
+SET next_attempt = NOW() + make_interval(secs => $1), last_error = $2
+WHERE id = $3
+`
+
+type RetryNotificationParams struct {
+	DelaySeconds float64
+	LastError    sql.NullString
+	ID           int64
+}
+
+func (q *Queries) RetryNotification(ctx context.Context, arg RetryNotificationParams) error {
+	_, err := q.db.ExecContext(ctx, retryNotification, arg.DelaySeconds, arg.LastError, arg.ID)
+	return err
+}
+
+const setAlertDestinations = `-- name: SetAlertDestinations :exec
+UPDATE user_alerts
+SET destinations = $2
+WHERE alert_id = $1
+`
+
+type SetAlertDestinationsParams struct {
+	AlertID      int32
+	Destinations []int32
+}
+
+func (q *Queries) SetAlertDestinations(ctx context.Context, arg SetAlertDestinationsParams) error {
+	_, err := q.db.ExecContext(ctx, setAlertDestinations, arg.AlertID, pq.Array(arg.Destinations))
+	return err
+}
+
+const setAlertUrgent = `-- name: SetAlertUrgent :exec
 UPDATE user_alerts
-SET ignored = ignored || $1
-WHERE id = $2 AND keyword = $3
+SET urgent = $2
+WHERE alert_id = $1
+`
+
+type SetAlertUrgentParams struct {
+	AlertID int32
+	Urgent  bool
+}
+
+func (q *Queries) SetAlertUrgent(ctx context.Context, arg SetAlertUrgentParams) error {
+	_, err := q.db.ExecContext(ctx, setAlertUrgent, arg.AlertID, arg.Urgent)
+	return err
+}
+
+const setDestinationEnabled = `-- name: SetDestinationEnabled :execrows
+UPDATE user_destinations
+SET enabled = $3
+WHERE id = $1 AND name = $2 AND (NOT $3 OR kind <> 'email' OR NOT EXISTS (
+  SELECT 1 FROM destination_confirmations c
+  WHERE c.destination_id = user_destinations.destination_id
+))
 `
 
-type IgnoreUserForAlertParams struct {
-	Ignored []string
+type SetDestinationEnabledParams struct {
 	ID      string
+	Name    string
+	Enabled bool
+}
+
+// Email destinations can't be enabled until they're confirmed
+func (q *Queries) SetDestinationEnabled(ctx context.Context, arg SetDestinationEnabledParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setDestinationEnabled, arg.ID, arg.Name, arg.Enabled)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const takeTelegramLinkCode = `-- name: TakeTelegramLinkCode :one
+DELETE FROM telegram_link_codes
+WHERE code = $1 AND expires > NOW()
+RETURNING id
+`
+
+func (q *Queries) TakeTelegramLinkCode(ctx context.Context, code string) (string, error) {
+	row := q.db.QueryRowContext(ctx, takeTelegramLinkCode, code)
+	var id string
+	err := row.Scan(&id)
+	return id, err
+}
+
+const updateAlertKeyword = `-- name: UpdateAlertKeyword :exec
+UPDATE user_alerts
+SET keyword = $2
+WHERE alert_id = $1
+`
+
+type UpdateAlertKeywordParams struct {
+	AlertID int32
 	Keyword string
 }
 
-func (q *Queries) IgnoreUserForAlert(ctx context.Context, arg IgnoreUserForAlertParams) error {
-	_, err := q.db.ExecContext(ctx, ignoreUserForAlert, pq.Array(arg.Ignored), arg.ID, arg.Keyword)
+func (q *Queries) UpdateAlertKeyword(ctx context.Context, arg UpdateAlertKeywordParams) error {
+	_, err := q.db.ExecContext(ctx, updateAlertKeyword, arg.AlertID, arg.Keyword)
+	return err
+}
+
+const updateNotificationHistory = `-- name: UpdateNotificationHistory :exec
+UPDATE notification_history
+SET status = $2
+WHERE history_id = (
+  SELECT history_id FROM notification_outbox
+  WHERE notification_outbox.id = $1
+)
+`
+
+type UpdateNotificationHistoryParams struct {
+	ID     int64
+	Status string
+}
+
+func (q *Queries) UpdateNotificationHistory(ctx context.Context, arg UpdateNotificationHistoryParams) error {
+	_, err := q.db.ExecContext(ctx, updateNotificationHistory, arg.ID, arg.Status)
+	return err
+}
+
+const updateUserDelivery = `-- name: UpdateUserDelivery :exec
+UPDATE users
+SET delivery_mode = $2, digest_at = $3, timezone = $4,
+    -- Instant users are sent what is left from their digest, see digest.UserDue
+    digest_sent = CASE WHEN $2 = 'instant' THEN NULL ELSE digest_sent END
+WHERE id = $1
+`
+
+type UpdateUserDeliveryParams struct {
+	ID           string
+	DeliveryMode string
+	DigestAt     int16
+	Timezone     string
+}
+
+func (q *Queries) UpdateUserDelivery(ctx context.Context, arg UpdateUserDeliveryParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserDelivery,
+		arg.ID,
+		arg.DeliveryMode,
+		arg.DigestAt,
+		arg.Timezone,
+	)
+	return err
+}
+
+const updateUserQuietHours = `-- name: UpdateUserQuietHours :exec
+UPDATE users
+SET quiet_start = $2, quiet_end = $3
+WHERE id = $1
+`
+
+type UpdateUserQuietHoursParams struct {
+	ID         string
+	QuietStart sql.NullInt16
+	QuietEnd   sql.NullInt16
+}
+
+func (q *Queries) UpdateUserQuietHours(ctx context.Context, arg UpdateUserQuietHoursParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserQuietHours, arg.ID, arg.QuietStart, arg.QuietEnd)
+	return err
+}
+
+const updateUserTimezone = `-- name: UpdateUserTimezone :exec
+UPDATE users
+SET timezone = $2
+WHERE id = $1
+`
+
+type UpdateUserTimezoneParams struct {
+	ID       string
+	Timezone string
+}
+
+func (q *Queries) UpdateUserTimezone(ctx context.Context, arg UpdateUserTimezoneParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserTimezone, arg.ID, arg.Timezone)
 	return err
 }
@@ -10,8 +10,9 @@ import (
 
 type Repository struct {
 	Db         	*sql.DB
-	Ctx         context.Context
+	Ctx         context.Context // Cancelled by Close
 	Queries     *Queries
+	cancel      context.CancelFunc
 }
 
 var (
@@ -43,10 +44,18 @@ func DBConnection() (*Repository, error) {
 
 	log.Println("Successfully connected to database")
 
+	ctx, cancel := context.WithCancel(context.Background())
 	Repo = &Repository{
 		Db:      db,
-		Ctx:     context.Background(),
+		Ctx:     ctx,
 		Queries: New(db),
+		cancel:  cancel,
 	}
 	return Repo, nil
+}
+
+// Close cancels queries still running on Ctx and closes the database.
+func (r *Repository) Close() error {
+	r.cancel()
+	return r.Db.Close()
 }
\ No newline at end of file